@@ -1,5 +1,12 @@
 package cmd
 
+import (
+	"time"
+
+	"github.com/forgeronvirtuel/lab-golang/internal/api/pubsub/bench"
+	"github.com/forgeronvirtuel/lab-golang/internal/largedataset"
+)
+
 var (
 	outputFile string
 	numRows    int
@@ -7,10 +14,62 @@ var (
 	separator  string
 	showFirst  int
 	hasHeader  bool
-	groupByCol int
+	groupBy    []string
 	validate   bool
 	filters    []string
+	compute    []string
+	measures   []string
+	histograms []string
+	distinct   []string
+	topK       []string
+	// Group-by flags
+	rollup        bool
+	sortBy        string
+	sortAscending bool
+	// Time bucket flags
+	timeBucket  largedataset.TimeBucketSpec
+	sortedInput bool
+	// Report flags
+	outputFormat string
+	// Extract flags
+	extractPath    string
+	extractFormat  string
+	extractColumns []string
+	// Error budget flags
+	rejectsPath  string
+	maxErrors    int
+	maxErrorRate float64
+	// Parallel processing flags
+	workers   int
+	chunkSize int
 	// Pub/Sub server flags
 	serverPort string
 	serverHost string
+	// Pub/Sub rate limiting flags
+	clientRate   float64
+	clientBurst  int
+	channelRate  float64
+	channelBurst int
+	// Pub/Sub shutdown flags
+	shutdownTimeout time.Duration
+	snapshotPath    string
+	// Pub/Sub configuration and limits flags
+	brokerConfigPath string
+	maxBodyBytes     int64
+	maxChannelLength uint8
+	maxDataBytes     uint32
+	maxChannelDepth  int
+	dedupWindow      time.Duration
+	partitions       int
+	consumerTimeout  time.Duration
+	retention        time.Duration
+	retentionMax     int
+	// Pub/Sub webhook flags
+	webhookAllowedNetworks []string
+	// Pub/Sub replication flags
+	followPrimary       string
+	replicationLogSize  int
+	replicationLogBytes int64
+	// Pub/Sub benchmark flags
+	benchConfig bench.Config
 )
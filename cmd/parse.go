@@ -2,11 +2,15 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/forgeronvirtuel/lab-golang/internal/largedataset"
@@ -21,7 +25,18 @@ You can specify a custom separator, show the first N rows, enable group-by, vali
 	Example: `  lab-golang parse --file data.csv
   lab-golang parse --file data.csv --sep ";" --show-first 10 --has-header
   lab-golang parse --file data.csv --has-header --group-by 2
+  lab-golang parse --file data.csv --has-header --group-by Exchange,Sector --rollup --sort-by avg:Price
+  lab-golang parse --file data.csv --has-header --measure Price,Quantity,Volume
+  lab-golang parse --file data.csv --has-header --compute "Change = (ClosePrice - OpenPrice) / OpenPrice * 100" --filter "Change > 1" --measure Change
+  lab-golang parse --file data.csv --has-header --measure Price --histogram "Price:10,50,100,500"
+  lab-golang parse --file data.csv --has-header --distinct Symbol,TradeID --top-k Symbol:5
+  lab-golang parse --file data.csv --has-header --time-bucket 1h --sorted
+  lab-golang parse --file data.csv --has-header --time-bucket 1d --symbol-column "" --volume-column Volume
   lab-golang parse --file data.csv --has-header --validate
+  lab-golang parse --file data.csv --has-header --validate --workers 0
+  lab-golang parse --file data.csv --has-header --group-by Sector --output-format json > report.json
+  lab-golang parse --file data.csv --has-header --filter "Sector = Technology" --select Symbol,Price --out tech.jsonl
+  lab-golang parse --file data.csv --has-header --validate --rejects rejects.csv --max-error-rate 0.01
   lab-golang parse --file data.csv --has-header --filter "Price > 100" --filter "Symbol = 'AAPL'"`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if filePath == "" {
@@ -33,12 +48,52 @@ You can specify a custom separator, show the first N rows, enable group-by, vali
 		}
 
 		cfg := ProcessConfig{
-			Path:       filePath,
-			Sep:        rune(separator[0]),
-			HasHeader:  hasHeader,
-			ShowFirst:  showFirst,
-			GroupByCol: groupByCol,
-			Filters:    filters,
+			Path:         filePath,
+			Sep:          rune(separator[0]),
+			HasHeader:    hasHeader,
+			ShowFirst:    showFirst,
+			GroupBy:      groupBy,
+			Rollup:       rollup,
+			SortBy:       sortBy,
+			Ascending:    sortAscending,
+			Filters:      filters,
+			Compute:      compute,
+			Measures:     measures,
+			Histograms:   histograms,
+			Distinct:     distinct,
+			TopK:         topK,
+			TimeBucket:   timeBucket,
+			Sorted:       sortedInput,
+			OutputFormat: outputFormat,
+			Out:          extractPath,
+			OutFormat:    extractFormat,
+			Select:       extractColumns,
+			Rejects:      rejectsPath,
+			MaxErrors:    maxErrors,
+			MaxErrorRate: maxErrorRate,
+			Workers:      workers,
+			ChunkSize:    chunkSize,
+		}
+		if cfg.Workers <= 0 {
+			cfg.Workers = runtime.NumCPU()
+		}
+		if !slices.Contains(largedataset.OutputFormats, cfg.OutputFormat) {
+			log.Fatalf("output format must be one of %s", strings.Join(largedataset.OutputFormats, ", "))
+		}
+		if cfg.Out == "" && (cfg.OutFormat != "" || len(cfg.Select) > 0) {
+			log.Fatal("--out-format and --select require --out")
+		}
+		if cfg.Out != "" && cfg.OutFormat == "" {
+			cfg.OutFormat = extractFormatOf(cfg.Out)
+		}
+		if cfg.Out != "" && !slices.Contains(largedataset.ExtractFormats, cfg.OutFormat) {
+			log.Fatalf("out format must be one of %s", strings.Join(largedataset.ExtractFormats, ", "))
+		}
+		if cfg.MaxErrorRate < 0 || cfg.MaxErrorRate > 1 {
+			log.Fatal("--max-error-rate must be between 0 and 1")
+		}
+		if cfg.Sorted && cfg.Workers > 1 {
+			log.Fatal("--sorted requires --workers 1, parallel workers don't see the rows in order")
 		}
 
 		start := time.Now()
@@ -47,29 +102,19 @@ You can specify a custom separator, show the first N rows, enable group-by, vali
 		var schema *largedataset.CSVSchema
 		if validate {
 			schema = largedataset.NewStockDataSchema()
-			fmt.Printf("Schema validation: ENABLED\n")
-		}
-
-		// Build aggregators
-		globalAgg := largedataset.NewGlobalAmountAggregator()
-		var aggs []largedataset.Aggregator
-		aggs = append(aggs, globalAgg)
-
-		if cfg.GroupByCol >= 0 {
-			aggs = append(aggs, largedataset.NewGroupByAggregator())
-		}
-
-		if cfg.ShowFirst > 0 {
-			aggs = append(aggs, largedataset.NewDebugAggregator(cfg.ShowFirst))
+			fmt.Fprintf(cfg.info(), "Schema validation: ENABLED\n")
 		}
 
-		composite := largedataset.NewCompositeAggregator(aggs...)
-
-		if err := processCSV(cfg, schema, composite); err != nil {
+		if err := processCSV(cfg, schema); err != nil {
+			if errors.Is(err, largedataset.ErrBudgetExceeded) {
+				// A distinct exit code tells invalid data from other failures
+				log.Printf("Error processing CSV: %v", err)
+				os.Exit(2)
+			}
 			log.Fatalf("Error processing CSV: %v", err)
 		}
 		elapsed := time.Since(start)
-		fmt.Printf("\nProcessing took %s\n", elapsed)
+		fmt.Fprintf(cfg.info(), "\nProcessing took %s\n", elapsed)
 	},
 }
 
@@ -80,131 +125,338 @@ func init() {
 	parseCmd.Flags().StringVarP(&separator, "sep", "s", ",", "CSV separator (single character)")
 	parseCmd.Flags().IntVar(&showFirst, "show-first", 5, "Show first N rows for debugging (0 to disable)")
 	parseCmd.Flags().BoolVar(&hasHeader, "has-header", false, "Specify if the CSV file has a header row")
-	parseCmd.Flags().IntVar(&groupByCol, "group-by", -1, "Column index (0-based) for group-by statistics (-1 to disable)")
+	parseCmd.Flags().StringSliceVar(&groupBy, "group-by", nil, "Columns (names or 0-based indexes) for group-by statistics, e.g. --group-by Exchange,Sector")
+	parseCmd.Flags().BoolVar(&rollup, "rollup", false, "Nest the groups column after column with subtotals")
+	parseCmd.Flags().StringVar(&sortBy, "sort-by", "sum", "Statistic the groups are sorted by: count, sum, min, max, avg, stddev, p50, p90 or p99, optionally followed by :measure, e.g. avg:Price")
+	parseCmd.Flags().BoolVar(&sortAscending, "ascending", false, "Sort the groups in ascending order")
+	parseCmd.Flags().StringSliceVar(&distinct, "distinct", nil, "Columns whose distinct values are counted, approximately for large counts, e.g. --distinct Symbol,TradeID")
+	parseCmd.Flags().StringSliceVar(&topK, "top-k", nil, "Columns whose most frequent values are shown, optionally followed by :K (default 10), e.g. --top-k Symbol:5")
+	parseCmd.Flags().StringVar(&timeBucket.Size, "time-bucket", "", "Compute the OHLC of each time bucket: 1m, 1h, 1d or any duration, e.g. 15m")
+	parseCmd.Flags().StringVar(&timeBucket.Time, "time-column", "Timestamp", "Time column of the buckets")
+	parseCmd.Flags().StringVar(&timeBucket.Layout, "time-layout", time.DateTime, "Layout of the time column, as for Go time.Parse")
+	parseCmd.Flags().StringVar(&timeBucket.Price, "price-column", "Price", "Price column of the OHLC")
+	parseCmd.Flags().StringVar(&timeBucket.Volume, "volume-column", "Quantity", "Column summed as the volume of the buckets (empty for none)")
+	parseCmd.Flags().StringVar(&timeBucket.Symbol, "symbol-column", "Symbol", "Column the buckets are split by (empty for none)")
+	parseCmd.Flags().BoolVar(&sortedInput, "sorted", false, "The file is sorted by time: write the buckets once complete, keeping only the current ones in memory")
 	parseCmd.Flags().BoolVar(&validate, "validate", false, "Enable CSV schema validation for stock market data")
 	parseCmd.Flags().StringArrayVar(&filters, "filter", []string{}, "Filter expression (can be specified multiple times, e.g., --filter \"Price > 100\")")
+	parseCmd.Flags().StringArrayVar(&compute, "compute", nil, "Column computed from the others, usable by filters, group-bys and measures (can be specified multiple times, e.g. --compute \"Spread = HighPrice - LowPrice\")")
+	parseCmd.Flags().StringSliceVar(&measures, "measure", []string{largedataset.DefaultMeasure}, "Numeric columns (names or 0-based indexes) to compute statistics on, e.g. --measure Price,Volume")
+	parseCmd.Flags().StringArrayVar(&histograms, "histogram", nil, "Histogram of a measure with the given bucket bounds (can be specified multiple times, e.g. --histogram \"Price:10,50,100\")")
+
+	parseCmd.Flags().StringVar(&outputFormat, "output-format", "table", "Format of the results: "+strings.Join(largedataset.OutputFormats, ", "))
+
+	parseCmd.Flags().StringVar(&extractPath, "out", "", "Write the rows passing the validation and the filters to this file")
+	parseCmd.Flags().StringVar(&extractFormat, "out-format", "", "Format of the --out file: "+strings.Join(largedataset.ExtractFormats, ", ")+" (default from the file extension, csv otherwise)")
+	parseCmd.Flags().StringSliceVar(&extractColumns, "select", nil, "Columns written to the --out file, e.g. --select Symbol,Price (default all)")
+
+	parseCmd.Flags().StringVar(&rejectsPath, "rejects", "", "Write the invalid rows, with their row number and error, to this CSV file instead of logging them")
+	parseCmd.Flags().IntVar(&maxErrors, "max-errors", -1, "Stop with exit code 2 once more rows are invalid (-1 for no limit)")
+	parseCmd.Flags().Float64Var(&maxErrorRate, "max-error-rate", 0, "Exit with code 2 if more than this fraction of the rows is invalid, e.g. 0.01 (0 for no limit)")
+
+	parseCmd.Flags().IntVar(&workers, "workers", 1, "Goroutines processing the file in parallel (0 for one per CPU)")
+	parseCmd.Flags().IntVar(&chunkSize, "chunk-size", largedataset.DefaultChunkSize, "Bytes per chunk of the file handed to each worker")
 
 	parseCmd.MarkFlagRequired("file")
 }
 
 type ProcessConfig struct {
-	Path       string
-	Sep        rune
-	HasHeader  bool
-	ShowFirst  int
-	GroupByCol int      // -1 means no group-by
-	Filters    []string // Filter expressions
+	Path         string
+	Sep          rune
+	HasHeader    bool
+	ShowFirst    int
+	GroupBy      []string // Group-by column names or indexes, none means no group-by
+	Rollup       bool     // Report subtotals of each group-by level
+	SortBy       string   // Group order, see largedataset.ParseSortKey
+	Ascending    bool
+	Filters      []string                    // Filter expressions
+	Compute      []string                    // Computed columns, see largedataset.ParseComputed
+	Measures     []string                    // Measure column names or indexes
+	Histograms   []string                    // Histogram specs, see largedataset.ParseHistogram
+	Distinct     []string                    // Columns whose distinct values are counted
+	TopK         []string                    // Top-K specs, see largedataset.ParseTopK
+	TimeBucket   largedataset.TimeBucketSpec // No time bucket if its Size is empty
+	Sorted       bool                        // Rows come in time order
+	OutputFormat string                      // One of largedataset.OutputFormats
+	Out          string                      // File the kept rows are written to, empty for none
+	OutFormat    string                      // One of largedataset.ExtractFormats
+	Select       []string                    // Columns written to Out, all of them if empty
+	Rejects      string                      // File the invalid rows are written to, empty to log them
+	MaxErrors    int                         // Invalid rows allowed, negative for no limit
+	MaxErrorRate float64                     // Fraction of invalid rows allowed, 0 for no limit
+	Workers      int                         // Goroutines processing chunks of the file, 1 for serial processing
+	ChunkSize    int                         // Bytes per chunk in parallel mode
+}
+
+// extractFormatOf returns the extract format matching the extension of path.
+func extractFormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tsv":
+		return "tsv"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	case ".pql":
+		return "parquet-lite"
+	}
+	return "csv"
+}
+
+// info returns where the messages about the processing are written: stdout
+// along the tables, stderr when stdout is meant for other tools.
+func (cfg ProcessConfig) info() io.Writer {
+	if cfg.OutputFormat == "table" {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+// aggregation holds the columns of a ProcessConfig resolved against the
+// header of the file.
+type aggregation struct {
+	Measures   []largedataset.Measure
+	GroupBy    []largedataset.Column
+	SortBy     largedataset.SortKey
+	Histograms []largedataset.Histogram
+	Distinct   []largedataset.Column
+	TopK       []largedataset.TopK
+	TimeBucket *largedataset.TimeBucket
+}
+
+// resolveAggregation resolves the columns aggregated by cfg.
+func resolveAggregation(cfg ProcessConfig, header []string, schema *largedataset.CSVSchema) (*aggregation, error) {
+	var a aggregation
+	var err error
+	if a.Measures, err = largedataset.ParseMeasures(cfg.Measures, header, schema); err != nil {
+		return nil, err
+	}
+	if a.GroupBy, err = largedataset.ResolveColumns(cfg.GroupBy, header); err != nil {
+		return nil, fmt.Errorf("invalid group-by: %w", err)
+	}
+	if a.SortBy, err = largedataset.ParseSortKey(cfg.SortBy, a.Measures, header); err != nil {
+		return nil, fmt.Errorf("invalid sort: %w", err)
+	}
+	a.SortBy.Ascending = cfg.Ascending
+	for _, spec := range cfg.Histograms {
+		hist, err := largedataset.ParseHistogram(spec, a.Measures, header)
+		if err != nil {
+			return nil, fmt.Errorf("invalid histogram: %w", err)
+		}
+		a.Histograms = append(a.Histograms, hist)
+	}
+	if a.Distinct, err = largedataset.ResolveColumns(cfg.Distinct, header); err != nil {
+		return nil, fmt.Errorf("invalid distinct: %w", err)
+	}
+	for _, spec := range cfg.TopK {
+		top, err := largedataset.ParseTopK(spec, header)
+		if err != nil {
+			return nil, fmt.Errorf("invalid top-k: %w", err)
+		}
+		a.TopK = append(a.TopK, top)
+	}
+	if cfg.TimeBucket.Size != "" {
+		bucket, err := largedataset.ResolveTimeBucket(cfg.TimeBucket, header)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time bucket: %w", err)
+		}
+		a.TimeBucket = &bucket
+	}
+	return &a, nil
+}
+
+// buildAggregators returns the aggregators computing the statistics of the
+// measures, overall and per group if there is a group-by, their histograms,
+// the distinct and most frequent values of the requested columns, and the
+// OHLC of the time buckets.
+func buildAggregators(cfg ProcessConfig, a *aggregation, out largedataset.Renderer) largedataset.Aggregator {
+	aggs := []largedataset.Aggregator{largedataset.NewGlobalAmountAggregator(a.Measures)}
+
+	if len(a.GroupBy) > 0 {
+		groups := largedataset.NewGroupByAggregator(a.Measures, a.GroupBy)
+		groups.Rollup = cfg.Rollup
+		groups.SortBy = a.SortBy
+		aggs = append(aggs, groups)
+	}
+
+	for _, hist := range a.Histograms {
+		aggs = append(aggs, largedataset.NewHistogramAggregator(a.Measures, hist))
+	}
+	for _, col := range a.Distinct {
+		aggs = append(aggs, largedataset.NewDistinctAggregator(col))
+	}
+	for _, top := range a.TopK {
+		aggs = append(aggs, largedataset.NewTopKAggregator(top))
+	}
+	if a.TimeBucket != nil {
+		buckets := largedataset.NewTimeBucketAggregator(*a.TimeBucket)
+		if cfg.Sorted {
+			buckets.Sorted = true
+			buckets.Out = out
+		}
+		aggs = append(aggs, buckets)
+	}
+
+	if cfg.ShowFirst > 0 {
+		aggs = append(aggs, largedataset.NewDebugAggregator(cfg.ShowFirst))
+	}
+
+	return largedataset.NewCompositeAggregator(aggs...)
 }
 
 // processCSV opens the file, streams CSV rows, parses them into LogicalRow,
 // and counts valid / invalid rows.
-func processCSV(cfg ProcessConfig, schema *largedataset.CSVSchema, composite largedataset.Aggregator) error {
+func processCSV(cfg ProcessConfig, schema *largedataset.CSVSchema) error {
 	f, err := os.Open(cfg.Path)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer f.Close()
 
+	out, err := largedataset.NewRenderer(cfg.OutputFormat, os.Stdout)
+	if err != nil {
+		return err
+	}
 	reader := bufio.NewReader(f)
-	r := csv.NewReader(reader)
-	r.Comma = cfg.Sep
-
-	var (
-		totalRows        int
-		validRows        int
-		invalidRows      int
-		validationErrors int
-		filteredRows     int
-		groupByEnabled   = cfg.GroupByCol >= 0
-	)
+	pipeline := &largedataset.Pipeline{
+		Sep:       cfg.Sep,
+		Schema:    schema,
+		Workers:   cfg.Workers,
+		ChunkSize: cfg.ChunkSize,
+	}
 
 	// Optionally read and ignore the header row
 	var header []string
 	if cfg.HasHeader {
-		header, err = r.Read()
+		header, err = largedataset.ReadRecord(reader, cfg.Sep)
 		if err != nil {
 			if err == io.EOF {
 				return fmt.Errorf("file contains only a header and no data rows")
 			}
 			return fmt.Errorf("failed to read header: %w", err)
 		}
-		fmt.Printf("Header: %v\n", header)
-		if groupByEnabled && groupByCol < len(header) {
-			fmt.Printf("Group-by column: %s (index %d)\n", header[groupByCol], groupByCol)
+		fmt.Fprintf(cfg.info(), "Header: %v\n", header)
+		pipeline.FieldsPerRecord = len(header)
+	}
+
+	// Computed columns follow the columns of the file, under their names
+	if len(cfg.Compute) > 0 {
+		if !cfg.HasHeader {
+			return fmt.Errorf("computed columns require a header")
+		}
+		if pipeline.Computed, header, err = largedataset.ParseComputedColumns(cfg.Compute, header); err != nil {
+			return err
+		}
+		for _, c := range pipeline.Computed {
+			fmt.Fprintf(cfg.info(), "Computed column: %s\n", c)
 		}
 	}
 
+	agg, err := resolveAggregation(cfg, header, schema)
+	if err != nil {
+		return err
+	}
+	for _, col := range agg.GroupBy {
+		fmt.Fprintf(cfg.info(), "Group-by column: %s (index %d)\n", col.Name, col.Index)
+	}
+	pipeline.Parser = &largedataset.RowParser{Measures: agg.Measures, GroupBy: agg.GroupBy}
+	pipeline.NewAggregator = func() largedataset.Aggregator {
+		return buildAggregators(cfg, agg, out)
+	}
+
 	// Parse filters if any
-	var filterSet *largedataset.FilterSet
 	if len(cfg.Filters) > 0 {
-		filterSet, err = largedataset.NewFilterSet(cfg.Filters, header)
+		pipeline.Filters, err = largedataset.NewFilterSet(cfg.Filters, header)
 		if err != nil {
 			return fmt.Errorf("failed to parse filters: %w", err)
 		}
-		fmt.Printf("Filters: %s\n", filterSet.String())
+		fmt.Fprintf(cfg.info(), "Filters: %s\n", pipeline.Filters.String())
+	}
+	if cfg.Workers > 1 {
+		fmt.Fprintf(cfg.info(), "Workers: %d\n", cfg.Workers)
 	}
 
-	for {
-		record, err := r.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
+	// Write the kept rows to the extract file if any
+	var extract *bufio.Writer
+	var extractFile *os.File
+	if cfg.Out != "" {
+		var columns []largedataset.Column // All of them
+		if len(cfg.Select) > 0 {
+			if columns, err = largedataset.ResolveColumns(cfg.Select, header); err != nil {
+				return fmt.Errorf("invalid select: %w", err)
 			}
-			// At this stage, we consider this a "fatal" CSV error (bad format)
-			return fmt.Errorf("error while reading CSV: %w", err)
 		}
+		if extractFile, err = os.Create(cfg.Out); err != nil {
+			return fmt.Errorf("failed to create extract: %w", err)
+		}
+		defer extractFile.Close() // Closed once complete below, this is for early returns
+		extract = bufio.NewWriter(extractFile)
+		if pipeline.Extract, err = largedataset.NewExtract(extract, cfg.OutFormat, columns, header); err != nil {
+			return err
+		}
+	}
 
-		totalRows++
-
-		// Validate schema if enabled
-		if schema != nil {
-			if err := schema.ValidateRecord(record); err != nil {
-				validationErrors++
-				log.Printf("row %d validation error: %v", totalRows, err)
-				continue
-			}
+	// Write the invalid rows to the rejects file if any, instead of logging
+	// them
+	var rejects *bufio.Writer
+	var rejectsFile *os.File
+	if cfg.Rejects != "" {
+		if rejectsFile, err = os.Create(cfg.Rejects); err != nil {
+			return fmt.Errorf("failed to create rejects: %w", err)
 		}
+		defer rejectsFile.Close() // Closed once complete below, this is for early returns
+		rejects = bufio.NewWriter(rejectsFile)
+		pipeline.Rejects = &largedataset.Rejects{W: rejects, Sep: cfg.Sep}
+	}
+	if cfg.MaxErrors >= 0 || cfg.MaxErrorRate > 0 {
+		pipeline.Budget = &largedataset.ErrorBudget{MaxErrors: cfg.MaxErrors, MaxRate: cfg.MaxErrorRate}
+	}
 
-		// Apply filters if any
-		if filterSet != nil {
-			match, err := filterSet.Evaluate(record)
-			if err != nil {
-				log.Printf("row %d filter error: %v", totalRows, err)
-				continue
-			}
-			if !match {
-				filteredRows++
-				continue // Skip this row
+	counters, composite, err := pipeline.Run(reader)
+	if err != nil {
+		// The rows processed before the error are kept
+		out.Flush()
+		if extract != nil {
+			if closeErr := closeOutput(extract, extractFile); closeErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to write extract: %w", closeErr))
 			}
 		}
-
-		logical, err := largedataset.ParseLogicalRowWithGroupBy(record, groupByCol)
-		if err != nil {
-			invalidRows++
-			// For now, we just log the error and continue.
-			// Later, we can introduce --limit-errors, etc.
-			log.Printf("skipping invalid row %d: %v", totalRows, err)
-			continue
+		if rejects != nil {
+			// Joined so that an exceeded error budget keeps its exit code
+			if closeErr := closeOutput(rejects, rejectsFile); closeErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to write rejects: %w", closeErr))
+			} else {
+				fmt.Fprintf(os.Stderr, "Rejected rows written to %s\n", cfg.Rejects)
+			}
 		}
-
-		validRows++
-		composite.Consume(logical)
+		return err
 	}
-
-	fmt.Printf("\n=== Summary ===\n")
-	fmt.Printf("Total rows read:    %d\n", totalRows)
-	if len(cfg.Filters) > 0 {
-		fmt.Printf("Filtered out:       %d\n", filteredRows)
+	if extract != nil {
+		if err := closeOutput(extract, extractFile); err != nil {
+			return fmt.Errorf("failed to write extract: %w", err)
+		}
+		fmt.Fprintf(cfg.info(), "Extracted %d rows to %s (%s)\n", counters.Extracted, cfg.Out, cfg.OutFormat)
 	}
-	fmt.Printf("Valid logical rows: %d\n", validRows)
-	if schema != nil {
-		fmt.Printf("Validation errors:  %d\n", validationErrors)
-	} else {
-		fmt.Printf("Invalid rows:       %d\n", invalidRows)
+	if rejects != nil {
+		if err := closeOutput(rejects, rejectsFile); err != nil {
+			return fmt.Errorf("failed to write rejects: %w", err)
+		}
+		fmt.Fprintf(cfg.info(), "Rejected %d rows to %s\n", counters.Rejected(), cfg.Rejects)
 	}
 
-	// Detailed reports
-	composite.Report(os.Stdout)
+	// Tables are collected first, the sorted time buckets ending their table
+	tables := composite.Tables()
+	out.Summary(counters)
+	for _, t := range tables {
+		largedataset.RenderTable(out, t)
+	}
+	return out.Close()
+}
 
-	return nil
+// closeOutput flushes w and closes f, the file it writes to. Short writes,
+// such as on a full disk, may only be reported when closing.
+func closeOutput(w *bufio.Writer, f *os.File) error {
+	err := w.Flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
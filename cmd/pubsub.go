@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/forgeronvirtuel/lab-golang/internal/api/pubsub"
 	"github.com/forgeronvirtuel/lab-golang/internal/httpsrv"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/cobra"
@@ -18,7 +20,21 @@ import (
 var pubsubCmd = &cobra.Command{
 	Use:   "pubsub",
 	Short: "Start an HTTP pub/sub server",
-	Long:  `Start an HTTP server that provides pub/sub functionality.`,
+	Long: `Start an HTTP server that provides pub/sub functionality.
+
+Settings are read from defaults, then the --config file (YAML or TOML), then
+LAB_PUBSUB_* environment variables, then the flags explicitly given. Sending
+SIGHUP reloads them without dropping connections; listen address changes
+require a restart.
+
+With --follow, the broker is a read-only follower replicating the channels of
+the primary at the given URL. The primary must keep a replication log for its
+followers to tail, enabled with --replication-log-size. Check the follower lag
+with GET /replication/status and promote it with POST /replication/promote
+when the primary is lost.
+
+Open /dashboard in a browser to watch the channels, peek at their next
+messages, purge them and replay dead letters.`,
 	Example: `  # Start the server on default port 8080
   lab-golang pubsub
 
@@ -27,11 +43,64 @@ var pubsubCmd = &cobra.Command{
 
   # Start the server on a specific host and port
   lab-golang pubsub --host 0.0.0.0 --port 8080
+
+  # Limit each client to 100 msg/s and each channel to 1000 msg/s
+  lab-golang pubsub --client-rate 100 --client-burst 200 --channel-rate 1000
+
+  # Persist queued messages across restarts
+  lab-golang pubsub --snapshot-path broker.snapshot --shutdown-timeout 30s
+
+  # Keep trades ordered per symbol across 8 partitions
+  lab-golang pubsub --partitions 8
+
+  # Send a request to the "jobs" responders and wait up to 10s for the reply
+  curl --data-binary @job.json 'localhost:8080/request/jobs?timeout=10s'
+
+  # Push gzip compressed data, pop it compressed or decompressed
+  curl -H 'X-Frame-Encoding: gzip' --data-binary @frame.bin localhost:8080/push
+  curl -H 'Accept-Encoding: gzip' localhost:8080/channels/events/pop
+
+  # Look at the next 20 messages of a channel without consuming them
+  curl 'localhost:8080/channels/events/peek?n=20'
+
+  # Deliver the messages of "orders" to an HTTP service, signed with HMAC.
+  # Webhooks to internal addresses must be allowed with --webhook-allowed-networks
+  curl localhost:8080/subscriptions -d '{"channel":"orders","url":"http://billing:9000/hook","secret":"s3cret","concurrency":4}'
+
+  # Reject trade rows which do not match the generated stock data schema
+  curl -X PUT localhost:8080/channels/trades/schema -d '{"preset":"stock"}'
+
+  # Only pop, or deliver, the trades matching a filter on the schema columns
+  curl -G localhost:8080/channels/trades/pop --data-urlencode "filter=Price > 100 AND Symbol = 'AAPL'"
+  curl localhost:8080/subscriptions -d '{"channel":"trades","url":"http://desk:9000/hook","filter":"Volume > 5000"}'
+
+  # Keep a day of history, then reprocess it from a given time
+  lab-golang pubsub --retention 24h
+  curl -X PUT localhost:8080/channels/events/cursors/billing -d '{"since":"2026-01-02T15:00:00Z"}'
+  curl 'localhost:8080/channels/events/messages?consumer=billing'
+
+  # Run a standby replicating a primary, then promote it
+  lab-golang pubsub --replication-log-size 100000
+  lab-golang pubsub --port 8081 --follow http://localhost:8080
+  curl -X POST localhost:8081/replication/promote
+
+  # Load settings from a file, overriding the port from the environment
+  LAB_PUBSUB_PORT=9090 lab-golang pubsub --config broker.yaml
 `,
 	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := loadBrokerConfig(cmd)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+
 		// Configure Gin
 		gin.SetMode(gin.ReleaseMode)
 		router := gin.Default()
+		// Rate limits key on the client IP, which must not come from a
+		// spoofable X-Forwarded-For header
+		if err := router.SetTrustedProxies(nil); err != nil {
+			log.Fatalf("Failed to configure trusted proxies: %v", err)
+		}
 
 		// Define routes
 		router.GET("/health", func(c *gin.Context) {
@@ -42,8 +111,70 @@ var pubsubCmd = &cobra.Command{
 			c.JSON(http.StatusOK, gin.H{"message": "pong"})
 		})
 
+		broker := pubsub.NewBroker()
+		if cfg.Replication.LogSize > 0 {
+			broker.EnableReplicationLog(cfg.Replication.LogSize, cfg.Replication.LogBytes)
+		}
+		// A follower gets its messages from the primary instead
+		if cfg.Persistence.SnapshotPath != "" && cfg.Replication.Follow == "" {
+			n, err := broker.LoadSnapshotFile(cfg.Persistence.SnapshotPath)
+			if err != nil {
+				log.Fatalf("Failed to load snapshot %s: %v", cfg.Persistence.SnapshotPath, err)
+			}
+			log.Printf("Restored %d messages from %s\n", n, cfg.Persistence.SnapshotPath)
+		}
+
+		var follower *pubsub.Follower
+		if cfg.Replication.Follow != "" {
+			follower = pubsub.NewFollower(broker, cfg.Replication.Follow)
+			follower.Start(context.Background())
+			log.Printf("Following primary %s\n", cfg.Replication.Follow)
+		}
+
+		pushHandler := pubsub.NewPushHandler(broker)
+		limiter := pubsub.NewRateLimiter(cfg.RateLimits.Client, cfg.RateLimits.Channel)
+		limiter.SetChannelLookup(func(channel string) bool {
+			_, ok := broker.Lookup(channel)
+			return ok
+		})
+		pushHandler.Limiter = limiter
+		channelsHandler := pubsub.NewChannelsHandler(broker, limiter)
+		requestHandler := pubsub.NewRequestHandler(broker, pushHandler)
+		replicationHandler := pubsub.NewReplicationHandler(broker, follower)
+		dispatcher := pubsub.NewDispatcher(broker)
+		subscriptionsHandler := pubsub.NewSubscriptionsHandler(dispatcher)
+		dashboardHandler := pubsub.NewDashboardHandler(broker)
+		if err := applyBrokerConfig(cfg, broker, pushHandler, limiter, dispatcher); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+
+		router.POST("/push", pushHandler.HandlePush)
+		router.POST("/request/:channel", requestHandler.HandleRequest)
+		router.GET("/channels", channelsHandler.HandleList)
+		router.GET("/channels/:name/stats", channelsHandler.HandleStats)
+		router.GET("/channels/:name/pop", channelsHandler.HandlePop)
+		router.GET("/channels/:name/peek", channelsHandler.HandlePeek)
+		router.GET("/channels/:name/consumers", channelsHandler.HandleConsumers)
+		router.GET("/channels/:name/messages", channelsHandler.HandleMessages)
+		router.GET("/channels/:name/schema", channelsHandler.HandleGetSchema)
+		router.PUT("/channels/:name/schema", channelsHandler.HandleSetSchema)
+		router.DELETE("/channels/:name/schema", channelsHandler.HandleDeleteSchema)
+		router.DELETE("/channels/:name/messages", channelsHandler.HandlePurge)
+		router.POST("/channels/:name/replay", channelsHandler.HandleReplayDeadLetters)
+		router.GET("/channels/:name/cursors", channelsHandler.HandleCursors)
+		router.PUT("/channels/:name/cursors/:consumer", channelsHandler.HandleSeekCursor)
+		router.POST("/subscriptions", subscriptionsHandler.HandleCreate)
+		router.GET("/subscriptions", subscriptionsHandler.HandleList)
+		router.GET("/subscriptions/:id", subscriptionsHandler.HandleGet)
+		router.DELETE("/subscriptions/:id", subscriptionsHandler.HandleDelete)
+		router.GET("/replication/log", replicationHandler.HandleLog)
+		router.GET("/replication/snapshot", replicationHandler.HandleSnapshot)
+		router.GET("/replication/status", replicationHandler.HandleStatus)
+		router.POST("/replication/promote", replicationHandler.HandlePromote)
+		router.GET("/dashboard", dashboardHandler.HandleIndex)
+
 		// Configure HTTP server
-		addr := fmt.Sprintf("%s:%s", serverHost, serverPort)
+		addr := fmt.Sprintf("%s:%s", cfg.Listen.Host, cfg.Listen.Port)
 		srv := &http.Server{
 			Addr:    addr,
 			Handler: router,
@@ -53,17 +184,49 @@ var pubsubCmd = &cobra.Command{
 		var wg sync.WaitGroup
 		httpsrv.StartHTTPServer(srv, &wg)
 
-		// Wait for interrupt signal for graceful shutdown
-		quit := make(chan os.Signal, 1)
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-		<-quit
+		// Reload on SIGHUP, wait for interrupt signal for graceful shutdown
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := range sigs {
+			if sig != syscall.SIGHUP {
+				break
+			}
 
-		log.Println("Shutting down server...")
-		if err := httpsrv.StopHTTPServer(srv, 5*time.Second); err != nil {
-			log.Fatalf("Server forced to shutdown: %v", err)
+			next, err := loadBrokerConfig(cmd)
+			if err != nil {
+				log.Printf("Keeping current configuration: %v", err)
+				continue
+			}
+			if next.Listen != cfg.Listen {
+				log.Printf("Listen address change requires a restart, still serving on %s", addr)
+			}
+			if next.Replication != cfg.Replication {
+				log.Println("Replication changes require a restart")
+			}
+			if err := applyBrokerConfig(next, broker, pushHandler, limiter, dispatcher); err != nil {
+				log.Printf("Keeping current configuration: %v", err)
+				continue
+			}
+			cfg = next
+			log.Println("Configuration reloaded")
 		}
 
+		// Stop accepting pushes, then let in-flight requests complete
+		log.Println("Shutting down server...")
+		pushHandler.Drain()
+		if err := httpsrv.StopHTTPServer(srv, time.Duration(cfg.Persistence.ShutdownTimeout)); err != nil {
+			log.Printf("Server forced to shutdown: %v", err)
+		}
 		wg.Wait()
+		dispatcher.Stop()
+
+		if cfg.Persistence.SnapshotPath != "" {
+			n, err := broker.SaveSnapshotFile(cfg.Persistence.SnapshotPath)
+			if err != nil {
+				log.Fatalf("Failed to write snapshot: %v", err)
+			}
+			log.Printf("Saved %d messages to %s\n", n, cfg.Persistence.SnapshotPath)
+		}
 		log.Println("Exiting application, bye!")
 	},
 }
@@ -71,6 +234,94 @@ var pubsubCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(pubsubCmd)
 
-	pubsubCmd.Flags().StringVarP(&serverPort, "port", "p", "8080", "Port to listen on")
-	pubsubCmd.Flags().StringVarP(&serverHost, "host", "H", "localhost", "Host to bind to")
+	defaults := pubsub.DefaultConfig()
+	pubsubCmd.Flags().StringVar(&brokerConfigPath, "config", "", "Path to a YAML or TOML configuration file")
+	pubsubCmd.Flags().StringVarP(&serverPort, "port", "p", defaults.Listen.Port, "Port to listen on")
+	pubsubCmd.Flags().StringVarP(&serverHost, "host", "H", defaults.Listen.Host, "Host to bind to")
+	pubsubCmd.Flags().Int64Var(&maxBodyBytes, "max-body", defaults.Limits.MaxBody, "Maximum push request body, in bytes")
+	pubsubCmd.Flags().Uint8Var(&maxChannelLength, "max-channel-len", defaults.Limits.MaxChannelLen, "Maximum channel name length")
+	pubsubCmd.Flags().Uint32Var(&maxDataBytes, "max-data", defaults.Limits.MaxData, "Maximum message data, in bytes")
+	pubsubCmd.Flags().IntVar(&maxChannelDepth, "max-depth", defaults.Channels.MaxDepth, "Maximum queued messages per channel (0 for unbounded)")
+	pubsubCmd.Flags().DurationVar(&dedupWindow, "dedup-window", time.Duration(defaults.Channels.DedupWindow), "How long X-Message-ID values are remembered to deduplicate pushes (0 to disable)")
+	pubsubCmd.Flags().IntVar(&partitions, "partitions", defaults.Channels.Partitions, "Number of partitions of new channels")
+	pubsubCmd.Flags().DurationVar(&consumerTimeout, "consumer-timeout", time.Duration(defaults.Channels.ConsumerTimeout), "Idle time after which a consumer loses its partitions")
+	pubsubCmd.Flags().DurationVar(&retention, "retention", time.Duration(defaults.Channels.Retention), "How long published messages can be replayed (0 to disable)")
+	pubsubCmd.Flags().IntVar(&retentionMax, "retention-max", defaults.Channels.RetentionMax, "Maximum retained messages per channel (0 for unbounded)")
+	pubsubCmd.Flags().Float64Var(&clientRate, "client-rate", 0, "Pushes per second allowed for each client (0 to disable)")
+	pubsubCmd.Flags().IntVar(&clientBurst, "client-burst", 0, "Burst size for each client (defaults to one second of --client-rate)")
+	pubsubCmd.Flags().Float64Var(&channelRate, "channel-rate", 0, "Pushes per second allowed for each channel (0 to disable)")
+	pubsubCmd.Flags().IntVar(&channelBurst, "channel-burst", 0, "Burst size for each channel (defaults to one second of --channel-rate)")
+	pubsubCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", time.Duration(defaults.Persistence.ShutdownTimeout), "Time allowed for in-flight requests to complete on shutdown")
+	pubsubCmd.Flags().StringVar(&snapshotPath, "snapshot-path", "", "File where queued messages are saved on shutdown and restored on start")
+	pubsubCmd.Flags().StringSliceVar(&webhookAllowedNetworks, "webhook-allowed-networks", nil, "Loopback, private or link-local CIDRs webhooks may deliver to, refused otherwise")
+	pubsubCmd.Flags().StringVar(&followPrimary, "follow", "", "URL of a primary broker to replicate, read-only until promoted")
+	pubsubCmd.Flags().IntVar(&replicationLogSize, "replication-log-size", defaults.Replication.LogSize, "Changes kept in memory for followers to tail (0 to disable)")
+	pubsubCmd.Flags().Int64Var(&replicationLogBytes, "replication-log-bytes", defaults.Replication.LogBytes, "Message data kept in the replication log, in bytes (0 for unbounded)")
+}
+
+// loadBrokerConfig builds the broker configuration from defaults, the config
+// file, the environment and the flags explicitly set, in that order.
+func loadBrokerConfig(cmd *cobra.Command) (pubsub.Config, error) {
+	cfg := pubsub.DefaultConfig()
+	if brokerConfigPath != "" {
+		if err := cfg.LoadConfigFile(brokerConfigPath); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return cfg, err
+	}
+
+	flags := cmd.Flags()
+	overrides := []struct {
+		flag  string
+		apply func()
+	}{
+		{"host", func() { cfg.Listen.Host = serverHost }},
+		{"port", func() { cfg.Listen.Port = serverPort }},
+		{"max-body", func() { cfg.Limits.MaxBody = maxBodyBytes }},
+		{"max-channel-len", func() { cfg.Limits.MaxChannelLen = maxChannelLength }},
+		{"max-data", func() { cfg.Limits.MaxData = maxDataBytes }},
+		{"max-depth", func() { cfg.Channels.MaxDepth = maxChannelDepth }},
+		{"dedup-window", func() { cfg.Channels.DedupWindow = pubsub.Duration(dedupWindow) }},
+		{"partitions", func() { cfg.Channels.Partitions = partitions }},
+		{"consumer-timeout", func() { cfg.Channels.ConsumerTimeout = pubsub.Duration(consumerTimeout) }},
+		{"client-rate", func() { cfg.RateLimits.Client.Rate = clientRate }},
+		{"client-burst", func() { cfg.RateLimits.Client.Burst = clientBurst }},
+		{"channel-rate", func() { cfg.RateLimits.Channel.Rate = channelRate }},
+		{"channel-burst", func() { cfg.RateLimits.Channel.Burst = channelBurst }},
+		{"shutdown-timeout", func() { cfg.Persistence.ShutdownTimeout = pubsub.Duration(shutdownTimeout) }},
+		{"retention", func() { cfg.Channels.Retention = pubsub.Duration(retention) }},
+		{"retention-max", func() { cfg.Channels.RetentionMax = retentionMax }},
+		{"snapshot-path", func() { cfg.Persistence.SnapshotPath = snapshotPath }},
+		{"webhook-allowed-networks", func() { cfg.Webhooks.AllowedNetworks = webhookAllowedNetworks }},
+		{"follow", func() { cfg.Replication.Follow = followPrimary }},
+		{"replication-log-size", func() { cfg.Replication.LogSize = replicationLogSize }},
+		{"replication-log-bytes", func() { cfg.Replication.LogBytes = replicationLogBytes }},
+	}
+	for _, o := range overrides {
+		if flags.Changed(o.flag) {
+			o.apply()
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// applyBrokerConfig pushes the settings which can change at runtime to the
+// broker components. Nothing is applied when the schemas are invalid.
+func applyBrokerConfig(cfg pubsub.Config, broker *pubsub.Broker, push *pubsub.PushHandler, limiter *pubsub.RateLimiter, dispatcher *pubsub.Dispatcher) error {
+	if err := broker.ConfigureSchemas(cfg.Schemas); err != nil {
+		return err
+	}
+	networks, err := cfg.Webhooks.Networks()
+	if err != nil {
+		return err
+	}
+	dispatcher.SetAllowedNetworks(networks)
+	push.SetLimits(cfg.Limits)
+	broker.SetLimits(cfg.Limits)
+	limiter.SetLimits(cfg.RateLimits.Client, cfg.RateLimits.Channel)
+	broker.SetDefaults(cfg.Channels)
+	return nil
 }
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/forgeronvirtuel/lab-golang/internal/api/pubsub/bench"
+	"github.com/spf13/cobra"
+)
+
+var pubsubBenchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Load test a pub/sub broker",
+	Long: `Push and pop messages with concurrent publishers and consumers, then report
+the throughput, the end-to-end latency percentiles and the error rates.
+
+Without --target, an in-process broker is started on a random local port.
+Interrupting the run stops it early and still prints the report.`,
+	Example: `  # Benchmark an in-process broker for 10 seconds
+  lab-golang pubsub bench
+
+  # 8 publishers and 8 consumers of 1 KiB messages over 4 channels
+  lab-golang pubsub bench --target http://localhost:8080 -P 8 -C 8 --channels 4 --payload-size 1024
+
+  # Publish exactly 100000 messages
+  lab-golang pubsub bench --messages 100000
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		report, err := bench.Run(ctx, benchConfig)
+		if err != nil {
+			log.Fatalf("Benchmark failed: %v", err)
+		}
+		report.Print(os.Stdout)
+	},
+}
+
+func init() {
+	pubsubCmd.AddCommand(pubsubBenchCmd)
+
+	flags := pubsubBenchCmd.Flags()
+	flags.StringVar(&benchConfig.Target, "target", "", "Base URL of the broker to test (default: in-process broker)")
+	flags.IntVarP(&benchConfig.Publishers, "publishers", "P", 4, "Number of concurrent publishers")
+	flags.IntVarP(&benchConfig.Consumers, "consumers", "C", 4, "Number of concurrent consumers")
+	flags.IntVar(&benchConfig.Channels, "channels", 1, "Number of channels the messages are spread over")
+	flags.IntVar(&benchConfig.PayloadSize, "payload-size", 256, "Message size in bytes (at least 8)")
+	flags.IntVar(&benchConfig.Messages, "messages", 0, "Total messages to publish (0 to publish for --duration)")
+	flags.DurationVar(&benchConfig.Duration, "duration", 10*time.Second, "How long to publish")
+	flags.DurationVar(&benchConfig.DrainWait, "drain-wait", 5*time.Second, "How long consumers may take to pop the remaining messages")
+}
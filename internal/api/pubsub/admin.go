@@ -0,0 +1,96 @@
+package pubsub
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrNotDeadLetterChannel = errors.New("not a dead letter channel")
+
+// Peek returns the n oldest queued messages of ch in publish order, without
+// removing them. With several partitions, pops take turns between partitions
+// and may hand them out in a different order.
+func (b *Broker) Peek(ch *Channel, n int) []*Message {
+	var head []*Message
+	for _, q := range ch.Partitions {
+		// Only the first n of each partition may be among the first n overall
+		taken := 0
+		for msg := range q.All() {
+			if taken == n {
+				break
+			}
+			head = append(head, msg)
+			taken++
+		}
+	}
+	sort.Slice(head, func(i, j int) bool { return head[i].Seq < head[j].Seq })
+	return head[:min(n, len(head))]
+}
+
+// Purge drops every queued message of ch, along with the copies webhook
+// subscriptions have yet to deliver, and returns how many were dropped.
+// Retained messages stay readable.
+func (b *Broker) Purge(ch *Channel) (int, error) {
+	if b.ReadOnly() {
+		return 0, ErrReadOnly
+	}
+	purged := make(map[*Message]bool)
+	for _, q := range ch.Partitions {
+		for {
+			msg, ok := q.Dequeue()
+			if !ok {
+				break
+			}
+			ch.log.append(LogEntry{Op: OpRemove, Channel: ch.Name, Seq: msg.Seq})
+			purged[msg] = true
+		}
+	}
+	ch.dropCopies(purged)
+	return len(purged), nil
+}
+
+// ReplayDeadLetters moves the messages of the dead letter channel ch back to
+// the subscription which failed to deliver them. Once the subscription is
+// gone, they go back to the channels they come from as new messages. It
+// returns how many were moved. Messages without origin are left in place.
+func (b *Broker) ReplayDeadLetters(ch *Channel) (int, error) {
+	if !IsDeadLetterChannel(ch.Name) {
+		return 0, ErrNotDeadLetterChannel
+	}
+	if b.ReadOnly() {
+		return 0, ErrReadOnly
+	}
+	subscription := strings.TrimPrefix(ch.Name, DeadLetterPrefix)
+	replayed := 0
+	for {
+		msg, ok := ch.removeFirst(func(msg *Message) bool { return msg.Origin != "" })
+		if !ok {
+			return replayed, nil
+		}
+		b.Channel(msg.Origin).redeliver(msg, subscription, b.defaults.Load(), b.now())
+		replayed++
+	}
+}
+
+// redeliver hands a copy of a dead letter to the subscription it failed to be
+// delivered to. Without that subscription, the copy is enqueued as a new
+// message, bypassing deduplication and the depth limit.
+func (ch *Channel) redeliver(msg *Message, subscription string, defaults *ChannelDefaults, now time.Time) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	replayed := *msg
+	replayed.Origin = ""
+	replayed.Error = ""
+	if q, ok := ch.subscribers[subscription]; ok {
+		q.push(&replayed, 0)
+		ch.wakeUp()
+		return
+	}
+	replayed.Seq = ch.lastSeq + 1
+	replayed.Partition = ch.partitionFor(msg.Key)
+	replayed.Time = now
+	ch.enqueue(&replayed)
+	ch.retain(&replayed, defaults, now)
+}
@@ -0,0 +1,161 @@
+package pubsub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBroker_Peek(t *testing.T) {
+	b := NewBroker()
+	b.SetDefaults(ChannelDefaults{Partitions: 3})
+	for _, data := range []string{"a", "b", "c", "d", "e"} {
+		b.Publish(&Frame{ChannelName: "events", Data: []byte(data)})
+	}
+	ch, _ := b.Lookup("events")
+	b.Pop(ch, "") // a message of some partition
+
+	head := b.Peek(ch, 3)
+	if len(head) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(head))
+	}
+	for i := 1; i < len(head); i++ {
+		if head[i-1].Seq >= head[i].Seq {
+			t.Errorf("expected sequence order, got %d before %d", head[i-1].Seq, head[i].Seq)
+		}
+	}
+	if ch.Size() != 4 {
+		t.Errorf("expected peek to leave 4 messages, got %d", ch.Size())
+	}
+	if got := len(b.Peek(ch, 10)); got != 4 {
+		t.Errorf("expected every message, got %d", got)
+	}
+}
+
+func TestBroker_Purge(t *testing.T) {
+	b := retainingBroker()
+	b.EnableReplicationLog(100, 0)
+	for range 3 {
+		b.Publish(&Frame{ChannelName: "events", Data: []byte("x")})
+	}
+	ch, _ := b.Lookup("events")
+
+	if n, err := b.Purge(ch); err != nil || n != 3 {
+		t.Fatalf("Purge() = %d, %v, want 3", n, err)
+	}
+	if ch.Size() != 0 {
+		t.Errorf("expected an empty channel, got %d", ch.Size())
+	}
+	if got := b.ReplicationLog().Head(); got != 6 {
+		t.Errorf("expected the removals logged, log head is %d", got)
+	}
+	if len(b.Replay(ch, 0, 10).Messages) != 3 {
+		t.Error("expected purged messages to stay retained")
+	}
+
+	b.SetReadOnly(true)
+	if _, err := b.Purge(ch); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("error = %v, want ErrReadOnly", err)
+	}
+}
+
+func TestBroker_PurgeSubscriptions(t *testing.T) {
+	b := NewBroker()
+	d := newTestDispatcher(t, b)
+	release := make(chan struct{})
+	rec := newReceiver(t, func(r *http.Request, body []byte) int {
+		if string(body) == "a" {
+			<-release
+		}
+		return http.StatusOK
+	})
+	sub := &Subscription{Channel: "events", URL: rec.URL}
+	if err := d.Subscribe(sub); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, data := range []string{"a", "b", "c"} {
+		b.Publish(&Frame{ChannelName: "events", Data: []byte(data)})
+	}
+	waitFor(t, "delivery in progress", func() bool { return sub.Stats.InFlight.Load() == 1 })
+
+	ch, _ := b.Lookup("events")
+	if n, err := b.Purge(ch); err != nil || n != 3 {
+		t.Fatalf("Purge() = %d, %v, want 3", n, err)
+	}
+	if sub.queue.Size() != 0 || len(ch.pending) != 0 {
+		t.Errorf("expected the subscription copies dropped, got %d queued and %d pending", sub.queue.Size(), len(ch.pending))
+	}
+
+	// The delivery in progress completes, the purged copies are not delivered
+	close(release)
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("d")})
+	waitFor(t, "deliveries", func() bool { return sub.Stats.Delivered.Load() == 2 })
+	if got := strings.Join(rec.received(), ""); got != "ad" {
+		t.Errorf("expected a and d delivered, got %q", got)
+	}
+	waitFor(t, "delivered messages removed", func() bool { return ch.Size() == 0 })
+}
+
+func TestBroker_ReplayDeadLetters(t *testing.T) {
+	b := NewBroker()
+	b.SetDefaults(ChannelDefaults{Partitions: 2})
+	b.Publish(&Frame{ChannelName: "orders", Data: []byte("first")})
+	dead := b.Channel(DeadLetterPrefix + "sub1")
+	dead.deadLetter(&Message{Seq: 7, Key: "k", Data: []byte("lost")}, "orders", errors.New("endpoint returned 500"))
+	dead.deadLetter(&Message{Seq: 8, Data: []byte("refunds")}, "refunds", nil)
+
+	if _, err := b.ReplayDeadLetters(b.Channel("orders")); !errors.Is(err, ErrNotDeadLetterChannel) {
+		t.Errorf("error = %v, want ErrNotDeadLetterChannel", err)
+	}
+	if n, err := b.ReplayDeadLetters(dead); err != nil || n != 2 {
+		t.Fatalf("ReplayDeadLetters() = %d, %v, want 2", n, err)
+	}
+	if dead.Size() != 0 {
+		t.Errorf("expected an empty dead letter channel, got %d", dead.Size())
+	}
+
+	orders, _ := b.Lookup("orders")
+	msg, ok := orders.removeFirst(func(msg *Message) bool { return msg.Key == "k" })
+	if !ok || string(msg.Data) != "lost" || msg.Seq != 2 || msg.Origin != "" || msg.Error != "" {
+		t.Errorf("unexpected replayed message: %+v", msg)
+	}
+	if refunds, ok := b.Lookup("refunds"); !ok || refunds.Size() != 1 {
+		t.Error("expected the second dead letter back in refunds")
+	}
+}
+
+func TestDashboardHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b := NewBroker()
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("hello")})
+	h := NewDashboardHandler(b)
+	channels := NewChannelsHandler(b, nil)
+	r := gin.New()
+	r.GET("/dashboard", h.HandleIndex)
+	r.DELETE("/channels/:name/messages", channels.HandlePurge)
+	r.POST("/channels/:name/replay", channels.HandleReplayDeadLetters)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := serve("GET", "/dashboard")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected the HTML page, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := serve("POST", "/channels/events/replay"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a regular channel, got %d", w.Code)
+	}
+	if w := serve("DELETE", "/channels/events/messages"); w.Body.String() != `{"purged":1}` {
+		t.Errorf("unexpected purge response: %s", w.Body.String())
+	}
+	if w := serve("DELETE", "/channels/missing/messages"); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,290 @@
+// Package bench load tests a pub/sub broker over HTTP, with concurrent
+// publishers pushing frames and consumers popping them.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/forgeronvirtuel/lab-golang/internal/api/pubsub"
+	"github.com/gin-gonic/gin"
+)
+
+// Config describes a load test.
+type Config struct {
+	Target      string        // Base URL of the broker, empty to start one in process
+	Publishers  int           // Concurrent publishers
+	Consumers   int           // Concurrent consumers
+	Channels    int           // Channels the messages are spread over
+	PayloadSize int           // Bytes per message, at least 8 for the timestamp
+	Messages    int           // Messages to publish in total, 0 to publish for Duration
+	Duration    time.Duration // How long to publish when Messages is 0
+	DrainWait   time.Duration // How long consumers may take to pop the remaining messages
+}
+
+// Report sums up a load test.
+type Report struct {
+	Elapsed        time.Duration // Whole test, waiting for the consumers included
+	PublishElapsed time.Duration // Until the publishers were done
+	ConsumeElapsed time.Duration // Until the last message was consumed
+	Published      uint64
+	PublishErrors  uint64
+	Consumed       uint64
+	ConsumeErrors  uint64
+	Latencies      Latencies
+}
+
+// Latencies are the end-to-end latencies of the consumed messages.
+type Latencies []time.Duration
+
+// Percentile returns the latency below which p percent of the messages were
+// delivered, with the nearest rank method.
+func (l Latencies) Percentile(p float64) time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	sorted := append(Latencies(nil), l...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+const minPayloadSize = 8
+
+// Run executes the load test described by cfg.
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	if cfg.Publishers < 1 || cfg.Consumers < 1 || cfg.Channels < 1 {
+		return Report{}, errors.New("publishers, consumers and channels must be at least 1")
+	}
+	if cfg.PayloadSize < minPayloadSize {
+		return Report{}, fmt.Errorf("payload size must be at least %d bytes", minPayloadSize)
+	}
+	if cfg.Messages <= 0 && cfg.Duration <= 0 {
+		return Report{}, errors.New("either messages or duration must be positive")
+	}
+
+	target := cfg.Target
+	if target == "" {
+		srv, err := startBroker()
+		if err != nil {
+			return Report{}, err
+		}
+		defer srv.Close()
+		target = "http://" + srv.Addr
+	}
+
+	r := &runner{
+		cfg:    cfg,
+		target: target,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: cfg.Publishers + cfg.Consumers,
+			},
+		},
+	}
+	return r.run(ctx), nil
+}
+
+type runner struct {
+	cfg    Config
+	target string
+	client *http.Client
+	start  time.Time
+
+	published     atomic.Uint64
+	publishErrors atomic.Uint64
+	consumed      atomic.Uint64
+	consumeErrors atomic.Uint64
+	remaining     atomic.Int64 // messages left to publish when counted
+	publishing    atomic.Bool
+}
+
+func (r *runner) run(ctx context.Context) Report {
+	r.remaining.Store(int64(r.cfg.Messages))
+	r.publishing.Store(true)
+	r.start = time.Now()
+
+	pubCtx := ctx
+	if r.cfg.Messages <= 0 {
+		var cancel context.CancelFunc
+		pubCtx, cancel = context.WithTimeout(ctx, r.cfg.Duration)
+		defer cancel()
+	}
+
+	var publishers sync.WaitGroup
+	for i := range r.cfg.Publishers {
+		publishers.Add(1)
+		go func() {
+			defer publishers.Done()
+			r.publish(pubCtx, i)
+		}()
+	}
+
+	consumeCtx, stopConsumers := context.WithCancel(ctx)
+	defer stopConsumers()
+	var consumers sync.WaitGroup
+	latencies := make([]Latencies, r.cfg.Consumers)
+	lastConsumed := make([]time.Duration, r.cfg.Consumers)
+	for i := range r.cfg.Consumers {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			latencies[i], lastConsumed[i] = r.consume(consumeCtx, i)
+		}()
+	}
+
+	publishers.Wait()
+	r.publishing.Store(false)
+	publishElapsed := time.Since(r.start)
+
+	// Let consumers catch up with what was published
+	deadline := time.Now().Add(r.cfg.DrainWait)
+	for r.consumed.Load() < r.published.Load() && time.Now().Before(deadline) && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopConsumers()
+	consumers.Wait()
+
+	report := Report{
+		Elapsed:        time.Since(r.start),
+		PublishElapsed: publishElapsed,
+		ConsumeElapsed: slices.Max(lastConsumed),
+		Published:      r.published.Load(),
+		PublishErrors:  r.publishErrors.Load(),
+		Consumed:       r.consumed.Load(),
+		ConsumeErrors:  r.consumeErrors.Load(),
+	}
+	for _, l := range latencies {
+		report.Latencies = append(report.Latencies, l...)
+	}
+	return report
+}
+
+func (r *runner) channel(i int) string {
+	return fmt.Sprintf("bench-%d", i%r.cfg.Channels)
+}
+
+// publish pushes messages carrying their send time until the count or the
+// duration of the test is reached.
+func (r *runner) publish(ctx context.Context, id int) {
+	payload := make([]byte, r.cfg.PayloadSize)
+	var frame bytes.Buffer
+	for n := id; ctx.Err() == nil; n += r.cfg.Publishers {
+		if r.cfg.Messages > 0 && r.remaining.Add(-1) < 0 {
+			return
+		}
+
+		binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+		frame.Reset()
+		if err := pubsub.WriteFrame(&frame, r.channel(n), payload); err != nil {
+			r.publishErrors.Add(1)
+			continue
+		}
+
+		resp, err := r.client.Post(r.target+"/push", "application/octet-stream", &frame)
+		if err != nil {
+			r.publishErrors.Add(1)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			r.published.Add(1)
+		} else {
+			r.publishErrors.Add(1)
+		}
+	}
+}
+
+// consume pops messages from its share of the channels until stopped and
+// returns their latencies, and when it popped the last one since the start.
+func (r *runner) consume(ctx context.Context, id int) (Latencies, time.Duration) {
+	var latencies Latencies
+	var last time.Duration
+	for n := id; ctx.Err() == nil; n += r.cfg.Consumers {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, r.target+"/channels/"+r.channel(n)+"/pop", nil)
+		resp, err := r.client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				r.consumeErrors.Add(1)
+			}
+			continue
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusOK && err == nil && len(data) >= minPayloadSize:
+			sent := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+			latencies = append(latencies, time.Since(sent))
+			last = time.Since(r.start)
+			r.consumed.Add(1)
+		case resp.StatusCode == http.StatusNotFound:
+			// Empty or not yet created channel, wait for publishers
+			time.Sleep(time.Millisecond)
+		default:
+			r.consumeErrors.Add(1)
+		}
+	}
+	return latencies, last
+}
+
+// startBroker serves an in-process broker on a random local port.
+func startBroker() (*http.Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start in-process broker: %w", err)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	broker := pubsub.NewBroker()
+	channels := pubsub.NewChannelsHandler(broker, nil)
+	router.POST("/push", pubsub.NewPushHandler(broker).HandlePush)
+	router.GET("/channels/:name/pop", channels.HandlePop)
+
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: router}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+// Print writes the report as a human readable summary. Rates are over the
+// time spent publishing and consuming respectively.
+func (r Report) Print(w io.Writer) {
+	rate := func(n uint64, elapsed time.Duration) float64 {
+		if elapsed <= 0 {
+			return 0
+		}
+		return float64(n) / elapsed.Seconds()
+	}
+	errorRate := func(errs, ok uint64) float64 {
+		if errs+ok == 0 {
+			return 0
+		}
+		return 100 * float64(errs) / float64(errs+ok)
+	}
+
+	fmt.Fprintf(w, "Elapsed:    %v (publishing %v, consuming %v)\n", r.Elapsed.Round(time.Millisecond),
+		r.PublishElapsed.Round(time.Millisecond), r.ConsumeElapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Published:  %d (%.0f msg/s), %d errors (%.2f%%)\n",
+		r.Published, rate(r.Published, r.PublishElapsed), r.PublishErrors, errorRate(r.PublishErrors, r.Published))
+	fmt.Fprintf(w, "Consumed:   %d (%.0f msg/s), %d errors (%.2f%%)\n",
+		r.Consumed, rate(r.Consumed, r.ConsumeElapsed), r.ConsumeErrors, errorRate(r.ConsumeErrors, r.Consumed))
+	if lost := int64(r.Published) - int64(r.Consumed); lost > 0 {
+		fmt.Fprintf(w, "Unconsumed: %d\n", lost)
+	}
+	fmt.Fprintf(w, "Latency:    p50 %v, p99 %v, p999 %v\n",
+		r.Latencies.Percentile(50), r.Latencies.Percentile(99), r.Latencies.Percentile(99.9))
+}
@@ -0,0 +1,95 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencies_Percentile(t *testing.T) {
+	var l Latencies
+	for i := 1; i <= 1000; i++ {
+		l = append(l, time.Duration(1001-i)*time.Millisecond)
+	}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 500 * time.Millisecond},
+		{99, 990 * time.Millisecond},
+		{99.9, 999 * time.Millisecond},
+		{100, 1000 * time.Millisecond},
+		{0, 1 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := l.Percentile(tt.p); got != tt.want {
+			t.Errorf("Percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := Latencies(nil).Percentile(50); got != 0 {
+		t.Errorf("empty percentile = %v, want 0", got)
+	}
+}
+
+func TestRun_InProcess(t *testing.T) {
+	report, err := Run(context.Background(), Config{
+		Publishers:  3,
+		Consumers:   2,
+		Channels:    4,
+		PayloadSize: 64,
+		Messages:    300,
+		DrainWait:   5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Published != 300 || report.Consumed != 300 {
+		t.Errorf("expected 300 published and consumed, got %d and %d", report.Published, report.Consumed)
+	}
+	if report.PublishErrors != 0 || report.ConsumeErrors != 0 {
+		t.Errorf("expected no errors, got %d and %d", report.PublishErrors, report.ConsumeErrors)
+	}
+	if len(report.Latencies) != 300 {
+		t.Errorf("expected 300 latencies, got %d", len(report.Latencies))
+	}
+	if report.PublishElapsed <= 0 || report.ConsumeElapsed <= 0 || report.ConsumeElapsed > report.Elapsed {
+		t.Errorf("unexpected windows: publishing %v, consuming %v of %v", report.PublishElapsed, report.ConsumeElapsed, report.Elapsed)
+	}
+
+	var out bytes.Buffer
+	report.Print(&out)
+	for _, want := range []string{"Published:  300", "p999"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestReport_PrintRates(t *testing.T) {
+	report := Report{
+		Elapsed:        10 * time.Second, // Idle drain wait included
+		PublishElapsed: 2 * time.Second,
+		ConsumeElapsed: 4 * time.Second,
+		Published:      400,
+		Consumed:       400,
+	}
+	var out bytes.Buffer
+	report.Print(&out)
+	for _, want := range []string{"Published:  400 (200 msg/s)", "Consumed:   400 (100 msg/s)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	if _, err := Run(context.Background(), Config{Publishers: 1, Consumers: 1, Channels: 1, PayloadSize: 4, Messages: 1}); err == nil {
+		t.Error("expected an error for a payload smaller than the timestamp")
+	}
+	if _, err := Run(context.Background(), Config{Publishers: 1, Consumers: 1, Channels: 1, PayloadSize: 8}); err == nil {
+		t.Error("expected an error without messages nor duration")
+	}
+}
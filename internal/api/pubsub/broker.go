@@ -0,0 +1,259 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChannelStats holds the counters exposed for a channel.
+type ChannelStats struct {
+	Published atomic.Uint64
+	Popped    atomic.Uint64
+	Rejected  atomic.Uint64 // Publishes failing the channel schema
+}
+
+// Broker owns the named channels served by the pub/sub server.
+// Channels are created lazily on first publish, with the number of
+// partitions configured at that time.
+type Broker struct {
+	mu       sync.RWMutex
+	channels map[string]*Channel
+	schemas  map[string]*ChannelSchema
+	defaults atomic.Pointer[ChannelDefaults]
+	limits   atomic.Pointer[Limits]
+	now      func() time.Time
+	log      *ReplicationLog
+	readOnly atomic.Bool
+
+	// configSchemas are the channels with a schema from the configuration,
+	// and schemaOverrides the ones whose schema was changed through the API
+	configSchemas   map[string]bool
+	schemaOverrides map[string]bool
+}
+
+var (
+	ErrChannelFull       = errors.New("channel is full")
+	ErrNoReplyChannel    = errors.New("no request is awaiting this reply channel")
+	ErrDeadLetterChannel = errors.New("cannot publish to a dead letter channel")
+	ErrReadOnly          = errors.New("broker is a read-only follower")
+)
+
+// ReplyChannelPrefix starts the name of the ephemeral channels receiving
+// replies. They only exist while a request is waiting on them.
+const ReplyChannelPrefix = "_reply."
+
+// IsReplyChannel reports whether name is an ephemeral reply channel.
+func IsReplyChannel(name string) bool {
+	return strings.HasPrefix(name, ReplyChannelPrefix)
+}
+
+func NewBroker() *Broker {
+	b := &Broker{
+		channels:        make(map[string]*Channel),
+		schemas:         make(map[string]*ChannelSchema),
+		schemaOverrides: make(map[string]bool),
+		now:             time.Now,
+	}
+	b.SetDefaults(DefaultConfig().Channels)
+	b.SetLimits(DefaultLimits())
+	return b
+}
+
+// SetDefaults replaces the settings applied to every channel.
+func (b *Broker) SetDefaults(defaults ChannelDefaults) {
+	b.defaults.Store(&defaults)
+}
+
+// SetLimits sets the frame limits the broker checks names and decodes data
+// with, which should match the ones of the pushed frames.
+func (b *Broker) SetLimits(limits Limits) {
+	b.limits.Store(&limits)
+}
+
+// EnableReplicationLog records the changes of the channels in a log of at
+// most size entries and maxBytes of message data (0 for no byte bound),
+// which followers tail. It must be called before any channel is created.
+func (b *Broker) EnableReplicationLog(size int, maxBytes int64) {
+	b.log = NewReplicationLog(size, maxBytes)
+}
+
+// ReplicationLog returns the log enabled by EnableReplicationLog, if any.
+func (b *Broker) ReplicationLog() *ReplicationLog {
+	return b.log
+}
+
+// SetReadOnly makes Publish and pops fail with ErrReadOnly, while the broker
+// follows a primary.
+func (b *Broker) SetReadOnly(readOnly bool) {
+	b.readOnly.Store(readOnly)
+}
+
+func (b *Broker) ReadOnly() bool {
+	return b.readOnly.Load()
+}
+
+// Channel returns the channel with the given name, creating it if needed.
+func (b *Broker) Channel(name string) *Channel {
+	return b.channel(name, b.defaults.Load().Partitions)
+}
+
+// channel returns the channel with the given name, creating it with the given
+// number of partitions if needed.
+func (b *Broker) channel(name string, partitions int) *Channel {
+	b.mu.RLock()
+	ch, ok := b.channels[name]
+	b.mu.RUnlock()
+	if ok {
+		return ch
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch, ok := b.channels[name]; ok {
+		return ch
+	}
+	ch = NewChannel(name, partitions)
+	ch.log = b.log
+	b.channels[name] = ch
+	return ch
+}
+
+// Lookup returns the channel with the given name if it exists.
+func (b *Broker) Lookup(name string) (*Channel, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ch, ok := b.channels[name]
+	return ch, ok
+}
+
+// Channels returns all channels sorted by name.
+func (b *Broker) Channels() []*Channel {
+	b.mu.RLock()
+	list := make([]*Channel, 0, len(b.channels))
+	for _, ch := range b.channels {
+		list = append(list, ch)
+	}
+	b.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Publish routes a pushed frame to its channel and returns the sequence number
+// assigned to it. A frame whose MessageID was seen within the dedup window is
+// not enqueued again. It fails with ErrChannelFull when the channel already
+// holds the configured maximum depth, with a *SchemaError when the data does
+// not match the channel schema, and with ErrDeadLetterChannel for dead letter
+// channels.
+func (b *Broker) Publish(frame *Frame) (PublishResult, error) {
+	if b.ReadOnly() {
+		return PublishResult{}, ErrReadOnly
+	}
+	if IsDeadLetterChannel(frame.ChannelName) {
+		// Only failed deliveries go there, for ReplayDeadLetters to hand
+		// them back to their subscription
+		return PublishResult{}, ErrDeadLetterChannel
+	}
+	var ch *Channel
+	if IsReplyChannel(frame.ChannelName) {
+		// Late replies must not resurrect the channel of a finished request
+		var ok bool
+		if ch, ok = b.Lookup(frame.ChannelName); !ok {
+			return PublishResult{}, ErrNoReplyChannel
+		}
+	} else {
+		ch = b.Channel(frame.ChannelName)
+	}
+	if err := b.validate(ch, frame); err != nil {
+		return PublishResult{}, err
+	}
+	return ch.publish(frame, b.defaults.Load(), b.now())
+}
+
+// WaitFor removes and returns the oldest message of ch matching match,
+// waiting for it to be published until ctx is done.
+func (b *Broker) WaitFor(ctx context.Context, ch *Channel, match func(*Message) bool) (*Message, error) {
+	for {
+		// Take the notification before looking, so that a publish happening
+		// in between is not missed
+		published := ch.published()
+		if msg, ok := ch.removeFirst(match); ok {
+			return msg, nil
+		}
+		select {
+		case <-published:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// acquireReplyChannel returns the reply channel named name, creating it if
+// needed. It stays alive until every acquirer released it.
+func (b *Broker) acquireReplyChannel(name string) *Channel {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.channels[name]
+	if !ok {
+		ch = NewChannel(name, 1)
+		ch.log = b.log
+		b.channels[name] = ch
+	}
+	ch.waiters++
+	return ch
+}
+
+// releaseReplyChannel drops the reply channel once nobody waits on it
+// anymore, together with the replies nobody claimed.
+func (b *Broker) releaseReplyChannel(ch *Channel) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch.waiters--
+	if ch.waiters == 0 {
+		delete(b.channels, ch.Name)
+	}
+}
+
+// Pop dequeues a message from ch. When consumer is not empty it joins the
+// channel consumer group and only its assigned partitions are considered,
+// which keeps messages sharing a key processed in order by one consumer.
+// Nothing is popped from a read-only broker.
+func (b *Broker) Pop(ch *Channel, consumer string) (*Message, bool) {
+	return b.PopMatching(ch, consumer, nil)
+}
+
+// PopMatching is Pop limited to the messages matching filter, among the
+// first MaxFilterScan of each partition. The messages it skips stay queued
+// for the other consumers. A nil filter matches every message.
+func (b *Broker) PopMatching(ch *Channel, consumer string, filter *MessageFilter) (*Message, bool) {
+	if b.ReadOnly() {
+		return nil, false
+	}
+	var match func(*Message) bool
+	if filter != nil {
+		match = filter.Match
+	}
+	if consumer == "" {
+		return ch.pop(ch.allPartitions(), match)
+	}
+	timeout := time.Duration(b.defaults.Load().ConsumerTimeout)
+	return ch.pop(ch.assignedPartitions(consumer, b.now(), timeout), match)
+}
+
+// Consumers returns the partition assignments of the consumers of ch.
+func (b *Broker) Consumers(ch *Channel) []ConsumerAssignment {
+	return ch.consumers(b.now(), time.Duration(b.defaults.Load().ConsumerTimeout))
+}
+
+// Enqueue is Publish ignoring the depth limit errors. It lets a Broker be
+// used as a FrameSink.
+func (b *Broker) Enqueue(frame *Frame) {
+	b.Publish(frame)
+}
@@ -0,0 +1,113 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBroker_Enqueue(t *testing.T) {
+	b := NewBroker()
+	b.Enqueue(&Frame{ChannelName: "b", Data: []byte("first")})
+	b.Enqueue(&Frame{ChannelName: "a", Data: []byte("second")})
+	b.Enqueue(&Frame{ChannelName: "b", Data: []byte("third")})
+
+	channels := b.Channels()
+	if len(channels) != 2 {
+		t.Fatalf("expected 2 channels, got %d", len(channels))
+	}
+	if channels[0].Name != "a" || channels[1].Name != "b" {
+		t.Errorf("expected channels sorted by name, got %q, %q", channels[0].Name, channels[1].Name)
+	}
+
+	ch, ok := b.Lookup("b")
+	if !ok {
+		t.Fatal("expected channel b to exist")
+	}
+	if ch.Size() != 2 {
+		t.Errorf("expected size 2, got %d", ch.Size())
+	}
+	if got := ch.Stats.Published.Load(); got != 2 {
+		t.Errorf("expected 2 published, got %d", got)
+	}
+	if msg, _ := ch.Partitions[0].Dequeue(); string(msg.Data) != "first" || msg.Seq != 1 {
+		t.Errorf("expected 'first' with sequence 1, got '%s' with sequence %d", msg.Data, msg.Seq)
+	}
+
+	if _, ok := b.Lookup("missing"); ok {
+		t.Error("expected lookup of unknown channel to fail")
+	}
+}
+
+func TestBroker_MaxDepth(t *testing.T) {
+	b := NewBroker()
+	b.SetDefaults(ChannelDefaults{MaxDepth: 1})
+
+	if _, err := b.Publish(&Frame{ChannelName: "events", Data: []byte("first")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := b.Publish(&Frame{ChannelName: "events", Data: []byte("second")}); err != ErrChannelFull {
+		t.Errorf("error = %v, want %v", err, ErrChannelFull)
+	}
+
+	ch, _ := b.Lookup("events")
+	if got := ch.Stats.Published.Load(); got != 1 {
+		t.Errorf("expected 1 published, got %d", got)
+	}
+}
+
+func TestBroker_Dedup(t *testing.T) {
+	b := NewBroker()
+	b.SetDefaults(ChannelDefaults{DedupWindow: Duration(time.Minute)})
+	now := time.Unix(0, 0)
+	b.now = func() time.Time { return now }
+
+	publish := func(id string) PublishResult {
+		t.Helper()
+		result, err := b.Publish(&Frame{ChannelName: "trades", Data: []byte(id), MessageID: id})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result
+	}
+
+	if got := publish("a"); got != (PublishResult{Sequence: 1}) {
+		t.Errorf("first publish = %+v", got)
+	}
+	if got := publish("b"); got != (PublishResult{Sequence: 2}) {
+		t.Errorf("second publish = %+v", got)
+	}
+
+	// A retry within the window is a no-op returning the original sequence
+	now = now.Add(30 * time.Second)
+	if got := publish("a"); got != (PublishResult{Sequence: 1, Duplicate: true}) {
+		t.Errorf("retried publish = %+v", got)
+	}
+	ch, _ := b.Lookup("trades")
+	if ch.Size() != 2 {
+		t.Errorf("expected size 2, got %d", ch.Size())
+	}
+
+	// Once the window is over, the same ID is a new message
+	now = now.Add(time.Minute)
+	if got := publish("a"); got != (PublishResult{Sequence: 3}) {
+		t.Errorf("publish after window = %+v", got)
+	}
+
+	// Messages without ID are never deduplicated
+	b.Publish(&Frame{ChannelName: "trades", Data: []byte("x")})
+	b.Publish(&Frame{ChannelName: "trades", Data: []byte("x")})
+	if ch.Size() != 5 {
+		t.Errorf("expected size 5, got %d", ch.Size())
+	}
+}
+
+func TestBroker_DedupDisabled(t *testing.T) {
+	b := NewBroker()
+	b.SetDefaults(ChannelDefaults{})
+
+	b.Publish(&Frame{ChannelName: "trades", MessageID: "a"})
+	result, _ := b.Publish(&Frame{ChannelName: "trades", MessageID: "a"})
+	if result != (PublishResult{Sequence: 2}) {
+		t.Errorf("expected a new message without dedup window, got %+v", result)
+	}
+}
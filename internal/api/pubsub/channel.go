@@ -0,0 +1,407 @@
+package pubsub
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Message is a published message along with the metadata assigned by the broker.
+type Message struct {
+	Seq           uint64    `json:"seq"`           // Sequence number within the channel, starting at 1
+	ID            string    `json:"id,omitempty"`  // Optional producer supplied identifier
+	Key           string    `json:"key,omitempty"` // Optional ordering key, hashed to choose the partition
+	Partition     int       `json:"partition"`
+	ReplyTo       string    `json:"reply_to,omitempty"`       // Channel where the reply to a request is expected
+	CorrelationID string    `json:"correlation_id,omitempty"` // Ties a reply to its request
+	Time          time.Time `json:"time"`                     // When the message was published
+	Encoding      string    `json:"encoding,omitempty"`       // Compression of Data, empty when raw
+	DecodedLen    uint32    `json:"decoded_len,omitempty"`    // Length of Data once decompressed, 0 when unknown
+	Origin        string    `json:"origin,omitempty"`         // Channel of a dead letter
+	Error         string    `json:"error,omitempty"`          // Why a dead letter could not be delivered
+	Data          []byte    `json:"data"`
+}
+
+// Channel is a named stream of messages split into partitions. Messages
+// sharing a key always land in the same partition, which is consumed in
+// FIFO order.
+type Channel struct {
+	Name       string
+	Partitions []*Queue[*Message]
+	Stats      ChannelStats
+
+	// mu serializes publishes so that sequence numbers follow queue order
+	mu      sync.Mutex
+	lastSeq uint64
+	nextRR  int // partition for the next message without key
+	dedup   dedupWindow
+	notify  chan struct{} // closed and replaced on every publish
+	waiters int           // requests awaiting a reply, guarded by the broker lock
+	// retained keeps the published messages in sequence order, popped or not,
+	// and cursors the next offset each reading consumer is at
+	retained []*Message
+	cursors  map[string]cursor
+	// subscribers holds the messages each webhook subscription has yet to
+	// deliver, by subscription ID, and pending the copies of each message not
+	// handled by their subscription yet
+	subscribers map[string]*subscriberQueue
+	pending     map[*Message]int
+
+	nextPop atomic.Uint64 // partition where the next pop starts looking
+	group   consumerGroup
+	log     *ReplicationLog // nil when changes are not replicated
+}
+
+func NewChannel(name string, partitions int) *Channel {
+	ch := &Channel{
+		Name:        name,
+		Partitions:  make([]*Queue[*Message], max(partitions, 1)),
+		dedup:       dedupWindow{byID: make(map[string]dedupOrigin)},
+		group:       consumerGroup{lastSeen: make(map[string]time.Time)},
+		cursors:     make(map[string]cursor),
+		subscribers: make(map[string]*subscriberQueue),
+		pending:     make(map[*Message]int),
+		notify:      make(chan struct{}),
+	}
+	for i := range ch.Partitions {
+		ch.Partitions[i] = NewQueue[*Message]()
+	}
+	return ch
+}
+
+// Size returns the number of messages queued in all partitions.
+func (ch *Channel) Size() int {
+	size := 0
+	for _, q := range ch.Partitions {
+		size += q.Size()
+	}
+	return size
+}
+
+// partitionFor returns the partition of a message with the given key.
+// Messages without key are spread round-robin. Must be called with mu held.
+func (ch *Channel) partitionFor(key string) int {
+	if key == "" {
+		p := ch.nextRR
+		ch.nextRR = (ch.nextRR + 1) % len(ch.Partitions)
+		return p
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(ch.Partitions)))
+}
+
+// PublishResult describes the outcome of a publish.
+type PublishResult struct {
+	Sequence  uint64 `json:"sequence"`
+	Partition int    `json:"partition"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+// publish assigns the next sequence number to the message and enqueues it in
+// its partition. When id was already published within the dedup window,
+// nothing is enqueued and the original message is returned.
+func (ch *Channel) publish(frame *Frame, defaults *ChannelDefaults, now time.Time) (PublishResult, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	id := frame.MessageID
+	window := time.Duration(defaults.DedupWindow)
+	if id != "" && window > 0 {
+		ch.dedup.expire(now)
+		if orig, ok := ch.dedup.byID[id]; ok {
+			return PublishResult{Sequence: orig.seq, Partition: orig.partition, Duplicate: true}, nil
+		}
+	}
+
+	// Only publishes grow the channel and they are serialized by mu, so the
+	// depth cannot exceed the limit between this check and the enqueue.
+	if defaults.MaxDepth > 0 && ch.Size() >= defaults.MaxDepth {
+		return PublishResult{}, ErrChannelFull
+	}
+
+	msg := &Message{
+		Seq:           ch.lastSeq + 1,
+		ID:            id,
+		Key:           frame.Key,
+		Partition:     ch.partitionFor(frame.Key),
+		ReplyTo:       frame.ReplyTo,
+		CorrelationID: frame.CorrelationID,
+		Time:          now,
+		Encoding:      frame.Encoding,
+		DecodedLen:    frame.DecodedLen,
+		Data:          frame.Data,
+	}
+	ch.enqueue(msg)
+	ch.retain(msg, defaults, now)
+	ch.fanOut(msg, defaults)
+
+	if id != "" && window > 0 {
+		ch.dedup.add(id, msg, now.Add(window))
+	}
+	return PublishResult{Sequence: msg.Seq, Partition: msg.Partition}, nil
+}
+
+// enqueue appends msg to its partition. It is logged first, so that its
+// removal can never precede it in the replication log. Must be called with
+// mu held.
+func (ch *Channel) enqueue(msg *Message) {
+	ch.log.append(LogEntry{Op: OpPublish, Channel: ch.Name, Partitions: len(ch.Partitions), Message: msg})
+	ch.Partitions[msg.Partition].Enqueue(msg)
+	ch.lastSeq = msg.Seq
+	ch.Stats.Published.Add(1)
+	ch.wakeUp()
+}
+
+// replicate enqueues a message published on the primary at the given time.
+// Messages up to the last sequence are already there and skipped, which
+// makes replaying the log over a full sync harmless.
+func (ch *Channel) replicate(msg *Message, defaults *ChannelDefaults, at time.Time) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if msg.Seq <= ch.lastSeq {
+		return
+	}
+	msg.Partition %= len(ch.Partitions)
+	ch.enqueue(msg)
+	ch.retain(msg, defaults, at)
+	if window := time.Duration(defaults.DedupWindow); msg.ID != "" && window > 0 {
+		ch.dedup.add(msg.ID, msg, at.Add(window))
+	}
+}
+
+// restore enqueues a message loaded from a snapshot, without depth limit, or
+// only retains it when it was popped already. Messages without a sequence
+// number get the next one, and the IDs enter the dedup window again so that
+// retries across a restart are still detected. Keyed messages are hashed
+// again in case the number of partitions changed.
+func (ch *Channel) restore(msg *Message, queued bool, defaults *ChannelDefaults, now time.Time) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if msg.Key != "" {
+		msg.Partition = ch.partitionFor(msg.Key)
+	} else {
+		msg.Partition %= len(ch.Partitions)
+	}
+	if msg.Seq == 0 {
+		msg.Seq = ch.lastSeq + 1
+	}
+	lastSeq := ch.lastSeq
+	if queued {
+		ch.enqueue(msg)
+	}
+	ch.lastSeq = max(lastSeq, msg.Seq)
+	if window := time.Duration(defaults.DedupWindow); msg.ID != "" && window > 0 {
+		ch.dedup.add(msg.ID, msg, now.Add(window))
+	}
+	ch.retain(msg, defaults, now)
+}
+
+// wakeUp notifies the goroutines waiting for a publish. Must be called with
+// mu held.
+func (ch *Channel) wakeUp() {
+	close(ch.notify)
+	ch.notify = make(chan struct{})
+}
+
+// published returns a channel closed on the next publish.
+func (ch *Channel) published() <-chan struct{} {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.notify
+}
+
+// removeFirst removes the oldest message matching match from any partition.
+func (ch *Channel) removeFirst(match func(*Message) bool) (*Message, bool) {
+	for _, q := range ch.Partitions {
+		if msg, ok := q.RemoveFirst(match); ok {
+			ch.removed(msg)
+			return msg, true
+		}
+	}
+	return nil, false
+}
+
+// pop dequeues the oldest message of one of the given partitions, or the
+// oldest one matching match when it is not nil. Partitions are visited
+// round-robin so that a busy one does not starve the others.
+func (ch *Channel) pop(partitions []int, match func(*Message) bool) (*Message, bool) {
+	if len(partitions) == 0 {
+		return nil, false
+	}
+	start := int(ch.nextPop.Add(1) % uint64(len(partitions)))
+	for i := range partitions {
+		q := ch.Partitions[partitions[(start+i)%len(partitions)]]
+		var msg *Message
+		var ok bool
+		if match == nil {
+			msg, ok = q.Dequeue()
+		} else {
+			msg, ok = removeMatching(q, match)
+		}
+		if ok {
+			ch.removed(msg)
+			return msg, true
+		}
+	}
+	return nil, false
+}
+
+// MaxFilterScan bounds the messages of a partition a filtered pop looks at,
+// oldest first. Matching messages queued behind them are not seen until the
+// ones ahead are consumed.
+const MaxFilterScan = 1000
+
+// removeMatching removes the oldest message of q matching match among the
+// first MaxFilterScan. match runs on a copy of them without holding the
+// queue lock, so that costly filters block neither the publishers nor the
+// other consumers, then the match is removed by sequence. A match popped by
+// another consumer in between is skipped.
+func removeMatching(q *Queue[*Message], match func(*Message) bool) (*Message, bool) {
+	candidates := make([]*Message, 0, min(q.Size(), MaxFilterScan))
+	for msg := range q.All() {
+		if len(candidates) == MaxFilterScan {
+			break
+		}
+		candidates = append(candidates, msg)
+	}
+	for _, candidate := range candidates {
+		if !match(candidate) {
+			continue
+		}
+		seq := candidate.Seq
+		if msg, ok := q.RemoveFirst(func(msg *Message) bool { return msg.Seq == seq }); ok {
+			return msg, true
+		}
+	}
+	return nil, false
+}
+
+func (ch *Channel) removed(msg *Message) {
+	ch.Stats.Popped.Add(1)
+	ch.log.append(LogEntry{Op: OpRemove, Channel: ch.Name, Seq: msg.Seq})
+}
+
+// removeSeq removes the message with the given sequence, if still queued.
+func (ch *Channel) removeSeq(seq uint64) {
+	ch.removeFirst(func(msg *Message) bool { return msg.Seq == seq })
+}
+
+// dropSnapshotted removes the messages saved in a snapshot, given the largest
+// sequence written for each partition.
+func (ch *Channel) dropSnapshotted(last []uint64) {
+	for i, seq := range last {
+		for {
+			msg, ok := ch.Partitions[i].RemoveFirst(func(msg *Message) bool { return msg.Seq <= seq })
+			if !ok {
+				break
+			}
+			ch.log.append(LogEntry{Op: OpRemove, Channel: ch.Name, Seq: msg.Seq})
+		}
+	}
+}
+
+// allPartitions returns the index of every partition.
+func (ch *Channel) allPartitions() []int {
+	all := make([]int, len(ch.Partitions))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// dedupWindow remembers the message IDs published recently. Entries are
+// appended in expiry order since the window is the same for all of them.
+type dedupWindow struct {
+	byID    map[string]dedupOrigin
+	entries []dedupEntry
+}
+
+type dedupOrigin struct {
+	seq       uint64
+	partition int
+}
+
+type dedupEntry struct {
+	id      string
+	expires time.Time
+}
+
+func (d *dedupWindow) add(id string, msg *Message, expires time.Time) {
+	d.byID[id] = dedupOrigin{seq: msg.Seq, partition: msg.Partition}
+	d.entries = append(d.entries, dedupEntry{id: id, expires: expires})
+}
+
+// expire forgets the IDs whose window ended before now.
+func (d *dedupWindow) expire(now time.Time) {
+	n := 0
+	for n < len(d.entries) && !d.entries[n].expires.After(now) {
+		delete(d.byID, d.entries[n].id)
+		n++
+	}
+	if n > 0 {
+		d.entries = append(d.entries[:0], d.entries[n:]...)
+	}
+}
+
+// consumerGroup tracks the consumers of a channel to share its partitions
+// between them. A consumer which has not popped for the session timeout
+// leaves the group and its partitions are reassigned.
+type consumerGroup struct {
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+// ConsumerAssignment lists the partitions assigned to a consumer.
+type ConsumerAssignment struct {
+	Consumer   string `json:"consumer"`
+	Partitions []int  `json:"partitions"`
+}
+
+// join records consumer as alive and returns the assignments of the group.
+// An empty consumer only expires stale members.
+func (g *consumerGroup) join(consumer string, partitions int, now time.Time, timeout time.Duration) []ConsumerAssignment {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if consumer != "" {
+		g.lastSeen[consumer] = now
+	}
+	ids := make([]string, 0, len(g.lastSeen))
+	for id, seen := range g.lastSeen {
+		if timeout > 0 && now.Sub(seen) > timeout {
+			delete(g.lastSeen, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	// Partition p goes to the consumer of rank p modulo the group size
+	assignments := make([]ConsumerAssignment, len(ids))
+	for i, id := range ids {
+		assignments[i] = ConsumerAssignment{Consumer: id, Partitions: []int{}}
+	}
+	for p := 0; len(ids) > 0 && p < partitions; p++ {
+		a := &assignments[p%len(ids)]
+		a.Partitions = append(a.Partitions, p)
+	}
+	return assignments
+}
+
+// assignedPartitions returns the partitions consumer may pop from.
+func (ch *Channel) assignedPartitions(consumer string, now time.Time, timeout time.Duration) []int {
+	for _, a := range ch.group.join(consumer, len(ch.Partitions), now, timeout) {
+		if a.Consumer == consumer {
+			return a.Partitions
+		}
+	}
+	return nil
+}
+
+// consumers returns the current partition assignments of the channel.
+func (ch *Channel) consumers(now time.Time, timeout time.Duration) []ConsumerAssignment {
+	return ch.group.join("", len(ch.Partitions), now, timeout)
+}
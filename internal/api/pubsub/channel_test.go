@@ -0,0 +1,146 @@
+package pubsub
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestChannel_KeyOrdering(t *testing.T) {
+	b := NewBroker()
+	b.SetDefaults(ChannelDefaults{Partitions: 8})
+
+	// Messages sharing a key land in the same partition, in publish order
+	partitionOf := map[string]int{}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("SYM%d", i%5)
+		result, err := b.Publish(&Frame{ChannelName: "trades", Key: key, Data: []byte(fmt.Sprint(i))})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p, ok := partitionOf[key]; ok && p != result.Partition {
+			t.Fatalf("key %s moved from partition %d to %d", key, p, result.Partition)
+		}
+		partitionOf[key] = result.Partition
+	}
+
+	ch, _ := b.Lookup("trades")
+	last := map[string]uint64{}
+	for {
+		msg, ok := b.Pop(ch, "")
+		if !ok {
+			break
+		}
+		if msg.Seq <= last[msg.Key] {
+			t.Errorf("key %s: sequence %d popped after %d", msg.Key, msg.Seq, last[msg.Key])
+		}
+		last[msg.Key] = msg.Seq
+	}
+	if ch.Size() != 0 {
+		t.Errorf("expected all messages popped, %d left", ch.Size())
+	}
+}
+
+func TestChannel_RoundRobinWithoutKey(t *testing.T) {
+	ch := NewChannel("events", 3)
+	defaults := &ChannelDefaults{}
+	for i := 0; i < 6; i++ {
+		ch.publish(&Frame{ChannelName: "events"}, defaults, time.Now())
+	}
+	for i, q := range ch.Partitions {
+		if q.Size() != 2 {
+			t.Errorf("partition %d: expected size 2, got %d", i, q.Size())
+		}
+	}
+}
+
+func TestChannel_ConsumerAssignment(t *testing.T) {
+	b := NewBroker()
+	b.SetDefaults(ChannelDefaults{Partitions: 4, ConsumerTimeout: Duration(time.Minute)})
+	now := time.Unix(0, 0)
+	b.now = func() time.Time { return now }
+
+	ch := b.Channel("trades")
+	for p := 0; p < 4; p++ {
+		ch.Partitions[p].Enqueue(&Message{Partition: p})
+	}
+
+	// A single consumer owns every partition
+	if msg, ok := b.Pop(ch, "alpha"); !ok {
+		t.Fatal("expected a message for the only consumer")
+	} else if msg.Partition < 0 || msg.Partition > 3 {
+		t.Errorf("unexpected partition %d", msg.Partition)
+	}
+
+	// A second consumer splits them
+	b.Pop(ch, "beta")
+	got := b.Consumers(ch)
+	want := []ConsumerAssignment{
+		{Consumer: "alpha", Partitions: []int{0, 2}},
+		{Consumer: "beta", Partitions: []int{1, 3}},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("assignments = %v, want %v", got, want)
+	}
+
+	// Consumers only receive messages from their partitions
+	for i := 0; i < 2; i++ {
+		if msg, ok := b.Pop(ch, "alpha"); ok && msg.Partition%2 != 0 {
+			t.Errorf("alpha received a message from partition %d", msg.Partition)
+		}
+	}
+
+	// An idle consumer leaves the group
+	now = now.Add(2 * time.Minute)
+	b.Pop(ch, "beta")
+	got = b.Consumers(ch)
+	want = []ConsumerAssignment{{Consumer: "beta", Partitions: []int{0, 1, 2, 3}}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("assignments after timeout = %v, want %v", got, want)
+	}
+}
+
+func TestChannel_MoreConsumersThanPartitions(t *testing.T) {
+	ch := NewChannel("trades", 1)
+	ch.Partitions[0].Enqueue(&Message{})
+	now := time.Unix(0, 0)
+
+	ch.assignedPartitions("alpha", now, time.Minute)
+	if got := ch.assignedPartitions("beta", now, time.Minute); len(got) != 0 {
+		t.Errorf("expected no partition for beta, got %v", got)
+	}
+}
+
+func TestChannel_FilteredPop(t *testing.T) {
+	ch := NewChannel("events", 1)
+	defaults := &ChannelDefaults{}
+	for i := 0; i < MaxFilterScan+2; i++ {
+		ch.publish(&Frame{ChannelName: "events"}, defaults, time.Now())
+	}
+	q := ch.Partitions[0]
+
+	// The filter runs without the partition lock, it could not take it again
+	done := make(chan *Message, 1)
+	go func() {
+		msg, _ := ch.pop([]int{0}, func(msg *Message) bool { return q.Size() > 0 && msg.Seq == 3 })
+		done <- msg
+	}()
+	select {
+	case msg := <-done:
+		if msg == nil || msg.Seq != 3 {
+			t.Fatalf("expected message 3, got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("filtered pop held the partition lock while matching")
+	}
+
+	// Only the oldest MaxFilterScan messages are looked at
+	last := uint64(MaxFilterScan + 2)
+	if msg, ok := ch.pop([]int{0}, func(msg *Message) bool { return msg.Seq == last }); ok {
+		t.Errorf("expected message %d beyond the scan limit to be skipped, got %d", last, msg.Seq)
+	}
+	q.Dequeue()
+	if msg, ok := ch.pop([]int{0}, func(msg *Message) bool { return msg.Seq == last }); !ok || msg.Seq != last {
+		t.Errorf("expected message %d once within the scan limit, got %+v", last, msg)
+	}
+}
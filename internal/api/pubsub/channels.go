@@ -0,0 +1,367 @@
+package pubsub
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChannelsHandler exposes the channels of a Broker.
+type ChannelsHandler struct {
+	Broker *Broker
+	// Limiter is optional. When set, its counters are included in the stats.
+	Limiter *RateLimiter
+}
+
+func NewChannelsHandler(b *Broker, limiter *RateLimiter) *ChannelsHandler {
+	return &ChannelsHandler{Broker: b, Limiter: limiter}
+}
+
+// ChannelStatsResponse is the JSON representation of a channel's stats.
+type ChannelStatsResponse struct {
+	Name       string        `json:"name"`
+	Depth      int           `json:"depth"`
+	Partitions []int         `json:"partitions"` // Depth of each partition
+	Published  uint64        `json:"published"`
+	Popped     uint64        `json:"popped"`
+	Rejected   uint64        `json:"rejected,omitempty"`
+	RateLimit  *RateCounters `json:"rate_limit,omitempty"`
+}
+
+func (h *ChannelsHandler) stats(ch *Channel) ChannelStatsResponse {
+	resp := ChannelStatsResponse{
+		Name:       ch.Name,
+		Partitions: make([]int, len(ch.Partitions)),
+		Published:  ch.Stats.Published.Load(),
+		Popped:     ch.Stats.Popped.Load(),
+		Rejected:   ch.Stats.Rejected.Load(),
+	}
+	for i, q := range ch.Partitions {
+		resp.Partitions[i] = q.Size()
+		resp.Depth += resp.Partitions[i]
+	}
+	if h.Limiter != nil {
+		counters := h.Limiter.Counters(ch.Name)
+		resp.RateLimit = &counters
+	}
+	return resp
+}
+
+// lookup returns the channel with the given name. Reply channels belong to
+// the requests waiting on them and are not exposed.
+func (h *ChannelsHandler) lookup(name string) (*Channel, bool) {
+	if IsReplyChannel(name) {
+		return nil, false
+	}
+	return h.Broker.Lookup(name)
+}
+
+// HandleList returns the stats of every channel but the reply ones.
+func (h *ChannelsHandler) HandleList(c *gin.Context) {
+	channels := h.Broker.Channels()
+	list := make([]ChannelStatsResponse, 0, len(channels))
+	for _, ch := range channels {
+		if IsReplyChannel(ch.Name) {
+			continue
+		}
+		list = append(list, h.stats(ch))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// HandleStats returns the stats of the channel named in the URL.
+func (h *ChannelsHandler) HandleStats(c *gin.Context) {
+	ch, ok := h.lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+	c.JSON(http.StatusOK, h.stats(ch))
+}
+
+// HandleConsumers returns the partitions assigned to each consumer of the
+// channel named in the URL.
+func (h *ChannelsHandler) HandleConsumers(c *gin.Context) {
+	ch, ok := h.lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+	c.JSON(http.StatusOK, h.Broker.Consumers(ch))
+}
+
+// Headers describing a popped message.
+const (
+	SequenceHeader  = "X-Message-Sequence"
+	PartitionHeader = "X-Partition"
+)
+
+// HandlePop dequeues a message from the channel named in the URL. With the
+// consumer query parameter, the consumer joins the channel group and only
+// receives messages from its assigned partitions. With the filter query
+// parameter, only a message with a CSV row matching the filter expression is
+// popped, the others are left for other consumers. Compressed messages are
+// passed through if the Accept-Encoding header allows it.
+func (h *ChannelsHandler) HandlePop(c *gin.Context) {
+	if h.Broker.ReadOnly() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": ErrReadOnly.Error()})
+		return
+	}
+	ch, ok := h.lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+
+	var filter *MessageFilter
+	if expr := c.Query("filter"); expr != "" {
+		var err error
+		if filter, err = h.Broker.ParseFilter(ch.Name, expr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	msg, ok := h.Broker.PopMatching(ch, c.Query("consumer"), filter)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no messages in queue"})
+		return
+	}
+
+	c.Header(SequenceHeader, strconv.FormatUint(msg.Seq, 10))
+	c.Header(PartitionHeader, strconv.Itoa(msg.Partition))
+	if msg.ID != "" {
+		c.Header(MessageIDHeader, msg.ID)
+	}
+	if msg.Key != "" {
+		c.Header(PartitionKeyHeader, msg.Key)
+	}
+	if msg.ReplyTo != "" {
+		c.Header(ReplyToHeader, msg.ReplyTo)
+	}
+	if msg.CorrelationID != "" {
+		c.Header(CorrelationIDHeader, msg.CorrelationID)
+	}
+	writeMessageData(c, http.StatusOK, msg)
+}
+
+// Bounds of the n query parameter of a peek.
+const (
+	defaultPeekCount = 10
+	maxPeekCount     = 100
+)
+
+// HandlePeek returns the n oldest queued messages of the channel named in the
+// URL, in publish order, without removing them. Compressed messages are
+// decompressed unless the Accept-Encoding header allows their encoding.
+func (h *ChannelsHandler) HandlePeek(c *gin.Context) {
+	ch, ok := h.lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+	n := defaultPeekCount
+	if value := c.Query("n"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid n"})
+			return
+		}
+		n = min(n, maxPeekCount)
+	}
+	msgs, err := decodeMessages(c, h.Broker.Peek(ch, n))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, msgs)
+}
+
+// HandlePurge drops the queued messages of the channel named in the URL.
+func (h *ChannelsHandler) HandlePurge(c *gin.Context) {
+	ch, ok := h.lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+	purged, err := h.Broker.Purge(ch)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+// HandleReplayDeadLetters moves the messages of the dead letter channel
+// named in the URL back to the channels they come from.
+func (h *ChannelsHandler) HandleReplayDeadLetters(c *gin.Context) {
+	ch, ok := h.lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+	replayed, err := h.Broker.ReplayDeadLetters(ch)
+	switch {
+	case errors.Is(err, ErrNotDeadLetterChannel):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"replayed": replayed})
+	}
+}
+
+// Bounds of the limit query parameter of a replay.
+const (
+	defaultReplayLimit = 100
+	maxReplayLimit     = 1000
+)
+
+// HandleMessages replays the retained messages of the channel named in the
+// URL, without removing them. They are read from the from_offset query
+// parameter, from the since timestamp, or from the cursor of consumer, which
+// then moves past the returned messages. Compressed messages are decompressed
+// unless the Accept-Encoding header allows their encoding.
+func (h *ChannelsHandler) HandleMessages(c *gin.Context) {
+	ch, ok := h.lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+
+	limit := defaultReplayLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = min(n, maxReplayLimit)
+	}
+
+	var history History
+	from, since, consumer := c.Query("from_offset"), c.Query("since"), c.Query("consumer")
+	switch {
+	case from != "" && since != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from_offset and since are exclusive"})
+		return
+	case from != "":
+		offset, err := strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from_offset"})
+			return
+		}
+		history = h.Broker.Replay(ch, offset, limit)
+	case since != "":
+		t, err := parseTimestamp(since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		history = h.Broker.ReplaySince(ch, t, limit)
+	case consumer != "":
+		history = h.Broker.ReadCursor(ch, consumer, limit)
+	default:
+		history = h.Broker.Replay(ch, 0, limit)
+	}
+
+	var err error
+	if history.Messages, err = decodeMessages(c, history.Messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// HandleCursors returns the replay cursors of the channel named in the URL.
+func (h *ChannelsHandler) HandleCursors(c *gin.Context) {
+	ch, ok := h.lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+	c.JSON(http.StatusOK, h.Broker.Cursors(ch))
+}
+
+// SeekRequest moves a replay cursor to an offset or to a timestamp.
+type SeekRequest struct {
+	Offset *uint64 `json:"offset"`
+	Since  string  `json:"since"`
+}
+
+// HandleSeekCursor resets the replay cursor of the consumer named in the URL,
+// so that it reads the retained messages again, e.g. after a bug fix.
+func (h *ChannelsHandler) HandleSeekCursor(c *gin.Context) {
+	ch, ok := h.lookup(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel not found"})
+		return
+	}
+
+	var req SeekRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid seek request"})
+		return
+	}
+	consumer := c.Param("consumer")
+	switch {
+	case (req.Offset != nil) == (req.Since != ""):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expected either offset or since"})
+	case req.Offset != nil:
+		c.JSON(http.StatusOK, h.Broker.SeekCursor(ch, consumer, *req.Offset))
+	default:
+		t, err := parseTimestamp(req.Since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, h.Broker.SeekCursorTime(ch, consumer, t))
+	}
+}
+
+// parseTimestamp accepts RFC 3339 timestamps and Unix times in seconds.
+func parseTimestamp(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Time{}, errors.New("invalid timestamp, expected RFC 3339 or Unix seconds")
+}
+
+// HandleGetSchema returns the schema of the channel named in the URL.
+func (h *ChannelsHandler) HandleGetSchema(c *gin.Context) {
+	schema := h.Broker.Schema(c.Param("name"))
+	if schema == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "channel has no schema"})
+		return
+	}
+	c.JSON(http.StatusOK, schema.Spec)
+}
+
+// HandleSetSchema attaches a schema to the channel named in the URL. Later
+// publishes whose CSV rows fail it are rejected with 400 Bad Request.
+func (h *ChannelsHandler) HandleSetSchema(c *gin.Context) {
+	var spec SchemaSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schema"})
+		return
+	}
+	schema, err := spec.Build()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.Broker.SetSchema(c.Param("name"), schema)
+	c.JSON(http.StatusOK, schema.Spec)
+}
+
+// HandleDeleteSchema lets the channel named in the URL accept any payload.
+func (h *ChannelsHandler) HandleDeleteSchema(c *gin.Context) {
+	h.Broker.SetSchema(c.Param("name"), nil)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChannelsHandler_PopAndStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	b := NewBroker()
+	handler := NewChannelsHandler(b, NewRateLimiter(RateLimit{}, RateLimit{Rate: 1}))
+	push := NewPushHandler(b)
+	push.Limiter = handler.Limiter
+
+	r := gin.New()
+	r.POST("/push", push.HandlePush)
+	r.GET("/channels/:name/stats", handler.HandleStats)
+	r.GET("/channels/:name/pop", handler.HandlePop)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/push", buildFrameData("events", []byte("hello"))))
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/channels/events/pop", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Fatalf("expected 200 'hello', got %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get(SequenceHeader); got != "1" {
+		t.Errorf("expected sequence 1, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/channels/events/stats", nil))
+	want := `{"name":"events","depth":0,"partitions":[0],"published":1,"popped":1,"rate_limit":{"allowed":1,"throttled":1}}`
+	if w.Body.String() != want {
+		t.Errorf("expected stats %s, got %s", want, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/channels/unknown/pop", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestChannelsHandler_Messages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	b := retainingBroker()
+	handler := NewChannelsHandler(b, nil)
+	r := gin.New()
+	r.GET("/channels/:name/messages", handler.HandleMessages)
+	r.PUT("/channels/:name/cursors/:consumer", handler.HandleSeekCursor)
+
+	for _, data := range []string{"a", "b"} {
+		b.Publish(&Frame{ChannelName: "events", Data: []byte(data)})
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"from offset", "GET", "/channels/events/messages?from_offset=2", "", http.StatusOK, `"next_offset":3`},
+		{"since", "GET", "/channels/events/messages?since=2000-01-01T00:00:00Z", "", http.StatusOK, `"first_offset":1`},
+		{"invalid since", "GET", "/channels/events/messages?since=yesterday", "", http.StatusBadRequest, "invalid timestamp"},
+		{"both", "GET", "/channels/events/messages?from_offset=1&since=0", "", http.StatusBadRequest, "exclusive"},
+		{"unknown channel", "GET", "/channels/missing/messages", "", http.StatusNotFound, "channel not found"},
+		{"seek", "PUT", "/channels/events/cursors/billing", `{"offset":2}`, http.StatusOK, `{"consumer":"billing","offset":2}`},
+		{"cursor read", "GET", "/channels/events/messages?consumer=billing", "", http.StatusOK, `"next_offset":3`},
+		{"seek without target", "PUT", "/channels/events/cursors/billing", `{}`, http.StatusBadRequest, "either offset or since"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body %s does not contain %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestChannelsHandler_Peek(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b := NewBroker()
+	for _, data := range []string{"a", "b", "c"} {
+		b.Publish(&Frame{ChannelName: "events", Data: []byte(data), Key: "k"})
+	}
+	r := gin.New()
+	r.GET("/channels/:name/peek", NewChannelsHandler(b, nil).HandlePeek)
+
+	tests := []struct {
+		path     string
+		wantCode int
+		wantSeqs []uint64
+	}{
+		{"/channels/events/peek", http.StatusOK, []uint64{1, 2, 3}},
+		{"/channels/events/peek?n=2", http.StatusOK, []uint64{1, 2}},
+		{"/channels/events/peek?n=0", http.StatusBadRequest, nil},
+		{"/channels/missing/peek", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.wantCode, w.Code)
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		var messages []Message
+		json.Unmarshal(w.Body.Bytes(), &messages)
+		if len(messages) != len(tt.wantSeqs) {
+			t.Errorf("%s: expected %d messages, got %d", tt.path, len(tt.wantSeqs), len(messages))
+			continue
+		}
+		for i, msg := range messages {
+			if msg.Seq != tt.wantSeqs[i] || msg.Key != "k" {
+				t.Errorf("%s: unexpected message %d: %+v", tt.path, i, msg)
+			}
+		}
+	}
+
+	ch, _ := b.Lookup("events")
+	if ch.Size() != 3 || ch.Stats.Popped.Load() != 0 {
+		t.Errorf("expected peeks to leave the channel untouched, got depth %d", ch.Size())
+	}
+}
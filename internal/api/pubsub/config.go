@@ -0,0 +1,327 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Config holds the settings of a pub/sub broker. It is loaded from defaults,
+// then an optional YAML or TOML file, then LAB_PUBSUB_* environment variables.
+type Config struct {
+	Listen      ListenConfig      `yaml:"listen" toml:"listen"`
+	Limits      Limits            `yaml:"limits" toml:"limits"`
+	RateLimits  RateLimitConfig   `yaml:"rate_limits" toml:"rate_limits"`
+	Channels    ChannelDefaults   `yaml:"channels" toml:"channels"`
+	Persistence PersistenceConfig `yaml:"persistence" toml:"persistence"`
+	Replication ReplicationConfig `yaml:"replication" toml:"replication"`
+	Webhooks    WebhookConfig     `yaml:"webhooks" toml:"webhooks"`
+	// Schemas validates the payloads of the channels they are keyed by
+	Schemas map[string]SchemaSpec `yaml:"schemas" toml:"schemas"`
+}
+
+type ListenConfig struct {
+	Host string `yaml:"host" toml:"host"`
+	Port string `yaml:"port" toml:"port"`
+}
+
+// Limits bounds the size of pushed frames.
+type Limits struct {
+	MaxBody       int64  `yaml:"max_body" toml:"max_body"`               // Maximum request body, in bytes
+	MaxChannelLen uint8  `yaml:"max_channel_len" toml:"max_channel_len"` // Maximum channel name length
+	MaxData       uint32 `yaml:"max_data" toml:"max_data"`               // Maximum message data, in bytes
+}
+
+type RateLimitConfig struct {
+	Client  RateLimit `yaml:"client" toml:"client"`
+	Channel RateLimit `yaml:"channel" toml:"channel"`
+}
+
+// ChannelDefaults applies to every channel of the broker.
+type ChannelDefaults struct {
+	MaxDepth        int      `yaml:"max_depth" toml:"max_depth"`               // Maximum queued messages (0 = unbounded)
+	DedupWindow     Duration `yaml:"dedup_window" toml:"dedup_window"`         // How long message IDs are remembered (0 = disabled)
+	Partitions      int      `yaml:"partitions" toml:"partitions"`             // Partitions of newly created channels
+	ConsumerTimeout Duration `yaml:"consumer_timeout" toml:"consumer_timeout"` // Idle time before a consumer loses its partitions
+	Retention       Duration `yaml:"retention" toml:"retention"`               // How long published messages can be replayed (0 = disabled)
+	RetentionMax    int      `yaml:"retention_max" toml:"retention_max"`       // Maximum retained messages per channel (0 = unbounded)
+}
+
+type PersistenceConfig struct {
+	SnapshotPath    string   `yaml:"snapshot_path" toml:"snapshot_path"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// ReplicationConfig sets up primary/follower replication. These settings are
+// only read at startup.
+type ReplicationConfig struct {
+	Follow   string `yaml:"follow" toml:"follow"`       // URL of the primary to follow, empty on a primary
+	LogSize  int    `yaml:"log_size" toml:"log_size"`   // Changes kept for followers to tail (0 = disabled)
+	LogBytes int64  `yaml:"log_bytes" toml:"log_bytes"` // Message data kept in the log, in bytes (0 = unbounded)
+}
+
+// WebhookConfig restricts the addresses webhook subscriptions deliver to.
+type WebhookConfig struct {
+	// Loopback, private or link-local networks webhooks may reach, as CIDRs
+	// such as 10.0.0.0/8. Any other internal address is refused.
+	AllowedNetworks []string `yaml:"allowed_networks" toml:"allowed_networks"`
+}
+
+// Networks parses the allowed networks.
+func (w WebhookConfig) Networks() ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(w.AllowedNetworks))
+	for _, cidr := range w.AllowedNetworks {
+		network, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network.Masked())
+	}
+	return networks, nil
+}
+
+// Duration is a time.Duration written as "5s" or "1m30s" in config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// DefaultLimits returns the frame limits used when none are configured.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBody:       50 << 20, // 50 MiB
+		MaxChannelLen: 255,
+		MaxData:       50 << 20,
+	}
+}
+
+// DefaultConfig returns the configuration used when nothing is overridden.
+func DefaultConfig() Config {
+	return Config{
+		Listen: ListenConfig{Host: "localhost", Port: "8080"},
+		Limits: DefaultLimits(),
+		Channels: ChannelDefaults{
+			DedupWindow:     Duration(5 * time.Minute),
+			Partitions:      1,
+			ConsumerTimeout: Duration(30 * time.Second),
+			RetentionMax:    10000,
+		},
+		Persistence: PersistenceConfig{
+			ShutdownTimeout: Duration(5 * time.Second),
+		},
+		Replication: ReplicationConfig{LogBytes: 256 << 20}, // 256 MiB
+	}
+}
+
+// LoadConfigFile overrides c with the settings found in path. The format is
+// chosen from the extension: .yaml, .yml or .toml.
+func (c *Config) LoadConfigFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, c)
+	case ".toml":
+		err = toml.Unmarshal(content, c)
+	default:
+		return fmt.Errorf("unsupported config format %q (expected .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// envVars maps each supported environment variable to the setting it overrides.
+var envVars = []struct {
+	name string
+	set  func(c *Config, value string) error
+}{
+	{"LAB_PUBSUB_HOST", func(c *Config, v string) error {
+		c.Listen.Host = v
+		return nil
+	}},
+	{"LAB_PUBSUB_PORT", func(c *Config, v string) error {
+		c.Listen.Port = v
+		return nil
+	}},
+	{"LAB_PUBSUB_MAX_BODY", func(c *Config, v string) (err error) {
+		c.Limits.MaxBody, err = strconv.ParseInt(v, 10, 64)
+		return err
+	}},
+	{"LAB_PUBSUB_MAX_CHANNEL_LEN", func(c *Config, v string) error {
+		n, err := strconv.ParseUint(v, 10, 8)
+		c.Limits.MaxChannelLen = uint8(n)
+		return err
+	}},
+	{"LAB_PUBSUB_MAX_DATA", func(c *Config, v string) error {
+		n, err := strconv.ParseUint(v, 10, 32)
+		c.Limits.MaxData = uint32(n)
+		return err
+	}},
+	{"LAB_PUBSUB_CLIENT_RATE", func(c *Config, v string) (err error) {
+		c.RateLimits.Client.Rate, err = strconv.ParseFloat(v, 64)
+		return err
+	}},
+	{"LAB_PUBSUB_CLIENT_BURST", func(c *Config, v string) (err error) {
+		c.RateLimits.Client.Burst, err = strconv.Atoi(v)
+		return err
+	}},
+	{"LAB_PUBSUB_CHANNEL_RATE", func(c *Config, v string) (err error) {
+		c.RateLimits.Channel.Rate, err = strconv.ParseFloat(v, 64)
+		return err
+	}},
+	{"LAB_PUBSUB_CHANNEL_BURST", func(c *Config, v string) (err error) {
+		c.RateLimits.Channel.Burst, err = strconv.Atoi(v)
+		return err
+	}},
+	{"LAB_PUBSUB_MAX_DEPTH", func(c *Config, v string) (err error) {
+		c.Channels.MaxDepth, err = strconv.Atoi(v)
+		return err
+	}},
+	{"LAB_PUBSUB_DEDUP_WINDOW", func(c *Config, v string) error {
+		return c.Channels.DedupWindow.UnmarshalText([]byte(v))
+	}},
+	{"LAB_PUBSUB_PARTITIONS", func(c *Config, v string) (err error) {
+		c.Channels.Partitions, err = strconv.Atoi(v)
+		return err
+	}},
+	{"LAB_PUBSUB_CONSUMER_TIMEOUT", func(c *Config, v string) error {
+		return c.Channels.ConsumerTimeout.UnmarshalText([]byte(v))
+	}},
+	{"LAB_PUBSUB_RETENTION", func(c *Config, v string) error {
+		return c.Channels.Retention.UnmarshalText([]byte(v))
+	}},
+	{"LAB_PUBSUB_RETENTION_MAX", func(c *Config, v string) (err error) {
+		c.Channels.RetentionMax, err = strconv.Atoi(v)
+		return err
+	}},
+	{"LAB_PUBSUB_SNAPSHOT_PATH", func(c *Config, v string) error {
+		c.Persistence.SnapshotPath = v
+		return nil
+	}},
+	{"LAB_PUBSUB_SHUTDOWN_TIMEOUT", func(c *Config, v string) error {
+		return c.Persistence.ShutdownTimeout.UnmarshalText([]byte(v))
+	}},
+	{"LAB_PUBSUB_FOLLOW", func(c *Config, v string) error {
+		c.Replication.Follow = v
+		return nil
+	}},
+	{"LAB_PUBSUB_REPLICATION_LOG_SIZE", func(c *Config, v string) (err error) {
+		c.Replication.LogSize, err = strconv.Atoi(v)
+		return err
+	}},
+	{"LAB_PUBSUB_WEBHOOK_ALLOWED_NETWORKS", func(c *Config, v string) error {
+		c.Webhooks.AllowedNetworks = strings.Split(v, ",")
+		return nil
+	}},
+	{"LAB_PUBSUB_REPLICATION_LOG_BYTES", func(c *Config, v string) (err error) {
+		c.Replication.LogBytes, err = strconv.ParseInt(v, 10, 64)
+		return err
+	}},
+}
+
+// ApplyEnv overrides c with the LAB_PUBSUB_* variables returned by lookup,
+// usually os.LookupEnv.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, env := range envVars {
+		value, ok := lookup(env.name)
+		if !ok {
+			continue
+		}
+		if err := env.set(c, value); err != nil {
+			return fmt.Errorf("invalid %s=%q: %w", env.name, value, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Listen.Port == "" {
+		errs = append(errs, errors.New("listen.port is required"))
+	} else if _, err := strconv.ParseUint(c.Listen.Port, 10, 16); err != nil {
+		errs = append(errs, fmt.Errorf("listen.port %q is not a valid port", c.Listen.Port))
+	}
+	if c.Limits.MaxBody <= 0 {
+		errs = append(errs, errors.New("limits.max_body must be positive"))
+	}
+	if int(c.Limits.MaxChannelLen) < ReplyChannelNameLen {
+		errs = append(errs, fmt.Errorf("limits.max_channel_len must be at least %d, the length of reply channel names", ReplyChannelNameLen))
+	}
+	if c.Limits.MaxData == 0 {
+		errs = append(errs, errors.New("limits.max_data must be positive"))
+	} else if int64(c.Limits.MaxData) > c.Limits.MaxBody {
+		errs = append(errs, errors.New("limits.max_data cannot exceed limits.max_body"))
+	}
+	if c.RateLimits.Client.Rate < 0 || c.RateLimits.Client.Burst < 0 {
+		errs = append(errs, errors.New("rate_limits.client cannot be negative"))
+	}
+	if c.RateLimits.Channel.Rate < 0 || c.RateLimits.Channel.Burst < 0 {
+		errs = append(errs, errors.New("rate_limits.channel cannot be negative"))
+	}
+	if c.Channels.MaxDepth < 0 {
+		errs = append(errs, errors.New("channels.max_depth cannot be negative"))
+	}
+	if c.Channels.DedupWindow < 0 {
+		errs = append(errs, errors.New("channels.dedup_window cannot be negative"))
+	}
+	if c.Channels.Partitions < 1 {
+		errs = append(errs, errors.New("channels.partitions must be at least 1"))
+	}
+	if c.Channels.ConsumerTimeout <= 0 {
+		errs = append(errs, errors.New("channels.consumer_timeout must be positive"))
+	}
+	if c.Channels.Retention < 0 {
+		errs = append(errs, errors.New("channels.retention cannot be negative"))
+	}
+	if c.Channels.RetentionMax < 0 {
+		errs = append(errs, errors.New("channels.retention_max cannot be negative"))
+	}
+	if c.Persistence.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("persistence.shutdown_timeout must be positive"))
+	}
+	for channel, spec := range c.Schemas {
+		if _, err := spec.Build(); err != nil {
+			errs = append(errs, fmt.Errorf("schemas.%s: %w", channel, err))
+		}
+	}
+	if c.Replication.LogSize < 0 {
+		errs = append(errs, errors.New("replication.log_size cannot be negative"))
+	}
+	if c.Replication.LogBytes < 0 {
+		errs = append(errs, errors.New("replication.log_bytes cannot be negative"))
+	}
+	if _, err := c.Webhooks.Networks(); err != nil {
+		errs = append(errs, fmt.Errorf("webhooks.allowed_networks: %w", err))
+	}
+	if c.Replication.Follow != "" {
+		if u, err := url.Parse(c.Replication.Follow); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("replication.follow %q is not an http(s) URL", c.Replication.Follow))
+		}
+	}
+	return errors.Join(errs...)
+}
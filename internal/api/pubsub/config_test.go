@@ -0,0 +1,210 @@
+package pubsub
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "broker.yaml",
+			content: `
+listen:
+  port: "9090"
+limits:
+  max_data: 1024
+rate_limits:
+  client:
+    rate: 10
+    burst: 20
+channels:
+  max_depth: 100
+persistence:
+  snapshot_path: /tmp/broker.snapshot
+  shutdown_timeout: 30s
+schemas:
+  trades:
+    preset: stock
+  users:
+    columns:
+      - index: 0
+        name: Email
+        type: email
+        required: true
+`,
+		},
+		{
+			name: "toml",
+			file: "broker.toml",
+			content: `
+[listen]
+port = "9090"
+
+[limits]
+max_data = 1024
+
+[rate_limits.client]
+rate = 10.0
+burst = 20
+
+[channels]
+max_depth = 100
+
+[persistence]
+snapshot_path = "/tmp/broker.snapshot"
+shutdown_timeout = "30s"
+
+[schemas.trades]
+preset = "stock"
+
+[[schemas.users.columns]]
+index = 0
+name = "Email"
+type = "email"
+required = true
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			if err := cfg.LoadConfigFile(writeConfig(t, tt.file, tt.content)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Settings missing from the file keep their defaults
+			if cfg.Listen.Host != "localhost" {
+				t.Errorf("host = %q, want %q", cfg.Listen.Host, "localhost")
+			}
+			if cfg.Limits.MaxBody != 50<<20 {
+				t.Errorf("max_body = %d, want %d", cfg.Limits.MaxBody, 50<<20)
+			}
+
+			if cfg.Listen.Port != "9090" {
+				t.Errorf("port = %q, want %q", cfg.Listen.Port, "9090")
+			}
+			if cfg.Limits.MaxData != 1024 {
+				t.Errorf("max_data = %d, want 1024", cfg.Limits.MaxData)
+			}
+			if cfg.RateLimits.Client != (RateLimit{Rate: 10, Burst: 20}) {
+				t.Errorf("client rate limit = %+v", cfg.RateLimits.Client)
+			}
+			if cfg.Channels.MaxDepth != 100 {
+				t.Errorf("max_depth = %d, want 100", cfg.Channels.MaxDepth)
+			}
+			if cfg.Persistence.SnapshotPath != "/tmp/broker.snapshot" {
+				t.Errorf("snapshot_path = %q", cfg.Persistence.SnapshotPath)
+			}
+			if time.Duration(cfg.Persistence.ShutdownTimeout) != 30*time.Second {
+				t.Errorf("shutdown_timeout = %v, want 30s", time.Duration(cfg.Persistence.ShutdownTimeout))
+			}
+			if cfg.Schemas["trades"].Preset != "stock" {
+				t.Errorf("trades schema = %+v", cfg.Schemas["trades"])
+			}
+			if cols := cfg.Schemas["users"].Columns; len(cols) != 1 || cols[0].Type != "email" || !cols[0].Required {
+				t.Errorf("users schema columns = %+v", cols)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile_UnsupportedFormat(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.LoadConfigFile(writeConfig(t, "broker.json", "{}")); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"LAB_PUBSUB_PORT":             "7000",
+		"LAB_PUBSUB_MAX_CHANNEL_LEN":  "64",
+		"LAB_PUBSUB_CHANNEL_RATE":     "2.5",
+		"LAB_PUBSUB_SHUTDOWN_TIMEOUT": "1m",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cfg := DefaultConfig()
+	if err := cfg.ApplyEnv(lookup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Listen.Port != "7000" {
+		t.Errorf("port = %q, want %q", cfg.Listen.Port, "7000")
+	}
+	if cfg.Limits.MaxChannelLen != 64 {
+		t.Errorf("max_channel_len = %d, want 64", cfg.Limits.MaxChannelLen)
+	}
+	if cfg.RateLimits.Channel.Rate != 2.5 {
+		t.Errorf("channel rate = %v, want 2.5", cfg.RateLimits.Channel.Rate)
+	}
+	if time.Duration(cfg.Persistence.ShutdownTimeout) != time.Minute {
+		t.Errorf("shutdown_timeout = %v, want 1m", time.Duration(cfg.Persistence.ShutdownTimeout))
+	}
+
+	env = map[string]string{"LAB_PUBSUB_MAX_CHANNEL_LEN": "300"}
+	if err := cfg.ApplyEnv(lookup); err == nil || !strings.Contains(err.Error(), "LAB_PUBSUB_MAX_CHANNEL_LEN") {
+		t.Errorf("expected error naming the variable, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"invalid port", func(c *Config) { c.Listen.Port = "http" }, "listen.port"},
+		{"zero max body", func(c *Config) { c.Limits.MaxBody = 0 }, "limits.max_body"},
+		{"zero channel length", func(c *Config) { c.Limits.MaxChannelLen = 0 }, "limits.max_channel_len"},
+		{"channel length below reply names", func(c *Config) { c.Limits.MaxChannelLen = uint8(ReplyChannelNameLen - 1) }, "limits.max_channel_len"},
+		{"data above body", func(c *Config) { c.Limits.MaxData = 1 << 30 }, "limits.max_data"},
+		{"negative rate", func(c *Config) { c.RateLimits.Client.Rate = -1 }, "rate_limits.client"},
+		{"negative depth", func(c *Config) { c.Channels.MaxDepth = -1 }, "channels.max_depth"},
+		{"zero shutdown timeout", func(c *Config) { c.Persistence.ShutdownTimeout = 0 }, "persistence.shutdown_timeout"},
+		{"invalid schema", func(c *Config) { c.Schemas = map[string]SchemaSpec{"trades": {Preset: "bonds"}} }, "schemas.trades"},
+		{"invalid primary", func(c *Config) { c.Replication.Follow = "localhost:8080" }, "replication.follow"},
+		{"invalid webhook network", func(c *Config) { c.Webhooks.AllowedNetworks = []string{"10.0.0.1"} }, "webhooks.allowed_networks"},
+	}
+
+	cfg := DefaultConfig()
+	cfg.Limits.MaxChannelLen = uint8(ReplyChannelNameLen)
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected channel names as long as reply ones to be valid, got %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want mention of %q", err, tt.wantErr)
+			}
+		})
+	}
+}
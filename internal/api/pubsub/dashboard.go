@@ -0,0 +1,26 @@
+package pubsub
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// DashboardHandler serves the admin dashboard, a single page polling the
+// channel endpoints.
+type DashboardHandler struct {
+	Broker *Broker
+}
+
+func NewDashboardHandler(b *Broker) *DashboardHandler {
+	return &DashboardHandler{Broker: b}
+}
+
+// HandleIndex serves the dashboard page.
+func (h *DashboardHandler) HandleIndex(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", dashboardHTML)
+}
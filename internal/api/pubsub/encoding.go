@@ -0,0 +1,243 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// EncodingHeader flags the data of a pushed frame as compressed, with one of
+// the encodings below. The broker stores it compressed and decompresses it
+// on pop unless the consumer accepts the encoding.
+const EncodingHeader = "X-Frame-Encoding"
+
+// Encodings supported for frame data.
+const (
+	EncodingGzip   = "gzip"
+	EncodingSnappy = "snappy" // snappy block format, without stream framing
+	EncodingZstd   = "zstd"
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported frame encoding")
+	ErrDecodedTooLarge     = errors.New("decompressed data too large")
+)
+
+type codec struct {
+	compress   func(data []byte) ([]byte, error)
+	decompress func(data []byte, limit int64) ([]byte, error)
+}
+
+// codecs lists the available encodings.
+var codecs = map[string]codec{
+	EncodingGzip:   {compress: gzipCompress, decompress: gzipDecompress},
+	EncodingSnappy: {compress: snappyCompress, decompress: snappyDecompress},
+	EncodingZstd:   {compress: zstdCompress, decompress: zstdDecompress},
+}
+
+func lookupCodec(encoding string) (codec, error) {
+	c, ok := codecs[encoding]
+	if !ok {
+		return codec{}, fmt.Errorf("%w %q", ErrUnsupportedEncoding, encoding)
+	}
+	return c, nil
+}
+
+// Compress encodes data with the given encoding.
+func Compress(encoding string, data []byte) ([]byte, error) {
+	c, err := lookupCodec(encoding)
+	if err != nil {
+		return nil, err
+	}
+	return c.compress(data)
+}
+
+// Decompress decodes data, failing with ErrDecodedTooLarge beyond limit bytes.
+func Decompress(encoding string, data []byte, limit int64) ([]byte, error) {
+	c, err := lookupCodec(encoding)
+	if err != nil {
+		return nil, err
+	}
+	return c.decompress(data, limit)
+}
+
+func gzipCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gzipDecompress(data []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, limit)
+}
+
+func snappyCompress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+// snappyDecompress checks the length announced by the block before
+// allocating it.
+func snappyDecompress(data []byte, limit int64) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > limit {
+		return nil, ErrDecodedTooLarge
+	}
+	return snappy.Decode(nil, data)
+}
+
+// zstdEncoder is safe for concurrent EncodeAll calls.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+func zstdCompress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+// zstdDecompress streams the frames, so that a bomb stops at limit instead
+// of being decoded whole.
+func zstdDecompress(data []byte, limit int64) ([]byte, error) {
+	r, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, limit)
+}
+
+// readLimited reads r to the end, failing with ErrDecodedTooLarge beyond
+// limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	decoded, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > limit {
+		return nil, ErrDecodedTooLarge
+	}
+	return decoded, nil
+}
+
+// frameEncoding returns the encoding flagged by the EncodingHeader of the
+// request and the decoded length of data, after checking that data decodes
+// within the data limit. When it does not, the error response is written and
+// false is returned.
+func frameEncoding(c *gin.Context, data []byte, limit uint32) (string, uint32, bool) {
+	encoding := strings.ToLower(strings.TrimSpace(c.GetHeader(EncodingHeader)))
+	if encoding == "" || encoding == "identity" {
+		return "", 0, true
+	}
+	if _, err := lookupCodec(encoding); err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return "", 0, false
+	}
+	// Decoding once guards consumers against corrupt data and bombs
+	decoded, err := Decompress(encoding, data, int64(limit))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrDecodedTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, gin.H{"error": fmt.Sprintf("invalid %s data: %v", encoding, err)})
+		return "", 0, false
+	}
+	return encoding, uint32(len(decoded)), true
+}
+
+// decode returns the data of msg decompressed, bounded by the length
+// checked when it was pushed if known.
+func (msg *Message) decode() ([]byte, error) {
+	if msg.Encoding == "" {
+		return msg.Data, nil
+	}
+	limit := int64(math.MaxUint32)
+	if msg.DecodedLen > 0 {
+		limit = int64(msg.DecodedLen)
+	}
+	return Decompress(msg.Encoding, msg.Data, limit)
+}
+
+// writeMessageData responds with the data of msg. Compressed data is passed
+// through with a Content-Encoding header when the client accepts the
+// encoding, and decompressed otherwise.
+func writeMessageData(c *gin.Context, status int, msg *Message) {
+	// The body depends on the Accept-Encoding header, caches must know
+	c.Header("Vary", "Accept-Encoding")
+	data := msg.Data
+	if msg.Encoding != "" {
+		if acceptsEncoding(c.GetHeader("Accept-Encoding"), msg.Encoding) {
+			c.Header("Content-Encoding", msg.Encoding)
+		} else {
+			var err error
+			if data, err = msg.decode(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decompress message: " + err.Error()})
+				return
+			}
+		}
+	}
+	c.Data(status, "application/octet-stream", data)
+}
+
+// decodeMessages returns msgs with the data of the compressed messages
+// decompressed when the client does not accept their encoding, as
+// writeMessageData does for a single message. msgs are left untouched.
+func decodeMessages(c *gin.Context, msgs []*Message) ([]*Message, error) {
+	c.Header("Vary", "Accept-Encoding")
+	accept := c.GetHeader("Accept-Encoding")
+	decoded := make([]*Message, len(msgs))
+	for i, msg := range msgs {
+		decoded[i] = msg
+		if msg.Encoding == "" || acceptsEncoding(accept, msg.Encoding) {
+			continue
+		}
+		data, err := msg.decode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress message %d: %w", msg.Seq, err)
+		}
+		raw := *msg
+		raw.Data, raw.Encoding, raw.DecodedLen = data, "", 0
+		decoded[i] = &raw
+	}
+	return decoded, nil
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header value allows
+// encoding, ignoring the entries with a zero quality.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
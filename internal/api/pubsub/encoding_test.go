@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, GZIP;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"br, snappy", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, EncodingGzip); got != tt.want {
+			t.Errorf("acceptsEncoding(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	data := []byte(strings.Repeat("symbol,price\n", 100))
+	for _, encoding := range []string{EncodingGzip, EncodingSnappy, EncodingZstd} {
+		compressed, err := Compress(encoding, data)
+		if err != nil {
+			t.Fatalf("%s: compress failed: %v", encoding, err)
+		}
+		if _, err := Decompress(encoding, compressed, int64(len(data)-1)); !errors.Is(err, ErrDecodedTooLarge) {
+			t.Errorf("%s: error = %v, want %v", encoding, err, ErrDecodedTooLarge)
+		}
+		decoded, err := Decompress(encoding, compressed, int64(len(data)))
+		if err != nil || string(decoded) != string(data) {
+			t.Errorf("%s: round trip failed: %v", encoding, err)
+		}
+	}
+
+	if _, err := Compress("br", data); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("error = %v, want %v", err, ErrUnsupportedEncoding)
+	}
+}
+
+func TestHandlePush_Encoding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	b := NewBroker()
+	channels := NewChannelsHandler(b, nil)
+	r := gin.New()
+	r.POST("/push", NewPushHandler(b).HandlePush)
+	r.GET("/channels/:name/pop", channels.HandlePop)
+
+	data := strings.Repeat("2024-01-02,AAPL,185.64\n", 50)
+	compressed, _ := Compress(EncodingGzip, []byte(data))
+
+	push := func(encoding string, payload []byte) int {
+		req := httptest.NewRequest("POST", "/push", buildFrameData("events", payload))
+		req.Header.Set(EncodingHeader, encoding)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name     string
+		encoding string
+		payload  []byte
+		want     int
+	}{
+		{"gzip", EncodingGzip, compressed, http.StatusCreated},
+		{"raw", "identity", []byte("raw"), http.StatusCreated},
+		{"corrupt", EncodingGzip, []byte("not gzip"), http.StatusBadRequest},
+		{"unavailable", "br", compressed, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		if got := push(tt.encoding, tt.payload); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Compressed messages are stored as pushed
+	ch, _ := b.Lookup("events")
+	if msg := ch.Partitions[0].Values()[0]; msg.Encoding != EncodingGzip || len(msg.Data) != len(compressed) || msg.DecodedLen != uint32(len(data)) {
+		t.Errorf("expected the gzip data to be stored compressed with its decoded length, got %q with %d bytes, %d decoded",
+			msg.Encoding, len(msg.Data), msg.DecodedLen)
+	}
+
+	pop := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/channels/events/pop", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := pop("gzip, deflate")
+	if w.Header().Get("Content-Encoding") != EncodingGzip || w.Body.String() != string(compressed) {
+		t.Errorf("expected the gzip data to be passed through, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", got)
+	}
+
+	push(EncodingGzip, compressed)
+	pop("") // raw message
+	w = pop("")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != data {
+		t.Errorf("expected the data to be decompressed, got %d bytes", w.Body.Len())
+	}
+}
+
+func TestChannelsHandler_PeekEncoding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	b := NewBroker()
+	r := gin.New()
+	r.GET("/channels/:name/peek", NewChannelsHandler(b, nil).HandlePeek)
+
+	data := strings.Repeat("2024-01-02,AAPL,185.64\n", 50)
+	compressed, _ := Compress(EncodingZstd, []byte(data))
+	b.Publish(&Frame{ChannelName: "events", Data: compressed, Encoding: EncodingZstd})
+
+	peek := func(acceptEncoding string) Message {
+		req := httptest.NewRequest("GET", "/channels/events/peek", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var msgs []Message
+		if err := json.Unmarshal(w.Body.Bytes(), &msgs); err != nil || len(msgs) != 1 {
+			t.Fatalf("unexpected peek response %d: %s", w.Code, w.Body.String())
+		}
+		return msgs[0]
+	}
+
+	if msg := peek("zstd"); msg.Encoding != EncodingZstd || string(msg.Data) != string(compressed) {
+		t.Errorf("expected the zstd data to be passed through, got %q", msg.Encoding)
+	}
+	if msg := peek(""); msg.Encoding != "" || string(msg.Data) != data {
+		t.Errorf("expected the data to be decompressed, got %q with %d bytes", msg.Encoding, len(msg.Data))
+	}
+	ch, _ := b.Lookup("events")
+	if msg := ch.Partitions[0].Values()[0]; msg.Encoding != EncodingZstd {
+		t.Error("expected the queued message to stay compressed")
+	}
+}
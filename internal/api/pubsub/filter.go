@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/csv"
+	"sync/atomic"
+
+	"github.com/forgeronvirtuel/lab-golang/internal/largedataset"
+)
+
+// MessageFilter selects the messages whose CSV payload has a row matching a
+// largedataset filter expression, such as "Price > 100 AND Symbol = 'AAPL'".
+type MessageFilter struct {
+	Expr      string
+	set       *largedataset.FilterSet
+	separator rune
+	limits    *atomic.Pointer[Limits] // Limits of the broker, bounding the decompressed payloads
+}
+
+// ParseFilter parses a filter over the messages of channel. Columns are
+// referred to by index, or by name when the channel has a schema. The filter
+// keeps the column names and separator of the schema at the time it is
+// parsed.
+func (b *Broker) ParseFilter(channel, expr string) (*MessageFilter, error) {
+	var header []string
+	separator := ','
+	if schema := b.Schema(channel); schema != nil {
+		header = schema.Header()
+		separator = schema.Separator
+	}
+	set, err := largedataset.NewFilterSet([]string{expr}, header)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageFilter{Expr: expr, set: set, separator: separator, limits: &b.limits}, nil
+}
+
+// Match reports whether a row of the payload of msg matches the filter.
+// Payloads which cannot be decoded within the broker data limit or parsed
+// never match.
+func (f *MessageFilter) Match(msg *Message) bool {
+	data := msg.Data
+	if msg.Encoding != "" {
+		var err error
+		if data, err = Decompress(msg.Encoding, msg.Data, int64(f.limits.Load().MaxData)); err != nil {
+			return false
+		}
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = f.separator
+	r.FieldsPerRecord = -1
+	for {
+		record, err := r.Read()
+		if err != nil {
+			// End of the payload or malformed row
+			return false
+		}
+		if ok, err := f.set.Evaluate(record); err == nil && ok {
+			return true
+		}
+	}
+}
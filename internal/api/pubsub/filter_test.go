@@ -0,0 +1,127 @@
+package pubsub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMessageFilter_Match(t *testing.T) {
+	b := NewBroker()
+	schema, _ := SchemaSpec{
+		Separator: ";",
+		Columns: []ColumnSpec{
+			{Index: 0, Name: "Symbol", Type: "string"},
+			{Index: 2, Name: "Price", Type: "float"},
+		},
+	}.Build()
+	b.SetSchema("trades", schema)
+	gzipped, _ := Compress(EncodingGzip, []byte("AAPL;x;150"))
+
+	tests := []struct {
+		name    string
+		channel string
+		expr    string
+		msg     *Message
+		want    bool
+	}{
+		{"named columns", "trades", "Price > 100 AND Symbol = 'AAPL'", &Message{Data: []byte("AAPL;x;150")}, true},
+		{"no match", "trades", "Price > 100 AND Symbol = 'AAPL'", &Message{Data: []byte("AAPL;x;50")}, false},
+		{"any row", "trades", "Symbol = 'MSFT'", &Message{Data: []byte("AAPL;x;1\nMSFT;x;2")}, true},
+		{"compressed", "trades", "Price >= 150", &Message{Data: gzipped, Encoding: EncodingGzip}, true},
+		{"missing column", "trades", "Price > 1", &Message{Data: []byte("AAPL")}, false},
+		{"index without schema", "events", "1 contains err", &Message{Data: []byte("42,error,x")}, true},
+		{"malformed row", "events", "0 = a", &Message{Data: []byte(`"a`)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := b.ParseFilter(tt.channel, tt.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := filter.Match(tt.msg); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := b.ParseFilter("events", "Price > 100"); err == nil {
+		t.Error("expected an error for a column name without schema")
+	}
+	filter, _ := b.ParseFilter("trades", "Price >= 150")
+	b.SetLimits(Limits{MaxBody: 5, MaxChannelLen: 255, MaxData: 5})
+	if filter.Match(&Message{Data: gzipped, Encoding: EncodingGzip}) {
+		t.Error("expected a payload decoding past the data limit not to match")
+	}
+	if _, err := b.ParseFilter("trades", "Volume > 100"); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
+
+func TestChannelsHandler_FilteredPop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b := NewBroker()
+	for _, data := range []string{"AAPL,90", "MSFT,200", "AAPL,120", "AAPL,130"} {
+		b.Publish(&Frame{ChannelName: "trades", Data: []byte(data)})
+	}
+	r := gin.New()
+	r.GET("/channels/:name/pop", NewChannelsHandler(b, nil).HandlePop)
+
+	pop := func(filter string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/channels/trades/pop?filter="+url.QueryEscape(filter), nil))
+		return w
+	}
+
+	for _, want := range []string{"AAPL,120", "AAPL,130"} {
+		w := pop("1 > 100 AND 0 = AAPL")
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Fatalf("expected %q, got %d %q", want, w.Code, w.Body.String())
+		}
+	}
+	if w := pop("1 > 100 AND 0 = AAPL"); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without match, got %d", w.Code)
+	}
+	if w := pop("Price > 100"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid filter, got %d", w.Code)
+	}
+
+	// Skipped messages are left for unfiltered consumers
+	ch, _ := b.Lookup("trades")
+	if ch.Size() != 2 {
+		t.Fatalf("expected 2 messages left, got %d", ch.Size())
+	}
+	if w := pop(""); w.Body.String() != "AAPL,90" {
+		t.Errorf("expected the oldest skipped message, got %q", w.Body.String())
+	}
+}
+
+func TestDispatcher_Filter(t *testing.T) {
+	b := NewBroker()
+	d := newTestDispatcher(t, b)
+	rec := newReceiver(t, func(r *http.Request, body []byte) int { return http.StatusOK })
+
+	if err := d.Subscribe(&Subscription{Channel: "trades", URL: rec.URL, Filter: "Price > 1"}); err == nil {
+		t.Error("expected an error for a column name without schema")
+	}
+	sub := &Subscription{Channel: "trades", URL: rec.URL, Filter: "1 >= 100"}
+	if err := d.Subscribe(sub); err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	for _, data := range []string{"AAPL,90", "MSFT,200", "AAPL,100"} {
+		b.Publish(&Frame{ChannelName: "trades", Data: []byte(data)})
+	}
+
+	waitFor(t, "deliveries", func() bool { return sub.Stats.Delivered.Load() == 2 })
+	if got := rec.received(); len(got) != 2 || got[0] != "MSFT,200" || got[1] != "AAPL,100" {
+		t.Errorf("unexpected deliveries: %q", got)
+	}
+	ch, _ := b.Lookup("trades")
+	waitFor(t, "skipped message acknowledged", func() bool { return ch.Size() == 0 })
+	if sub.response().Filter != "1 >= 100" {
+		t.Errorf("expected the filter in the response, got %+v", sub.response())
+	}
+}
@@ -7,22 +7,6 @@ import (
 	"io"
 )
 
-type Channel struct {
-	Name string
-	Q    *Queue[[]byte]
-}
-
-func NewChannel(name string, q *Queue[[]byte]) *Channel {
-	return &Channel{
-		Name: name,
-		Q:    q,
-	}
-}
-
-func (ch *Channel) Queue() *Queue[[]byte] {
-	return ch.Q
-}
-
 var (
 	ErrChannelTooLarge = errors.New("channel too large")
 	ErrDataTooLarge    = errors.New("data too large")
@@ -32,6 +16,22 @@ type Frame struct {
 	ChannelName string
 	DataLen     uint32
 	Data        []byte
+	// MessageID is an optional producer supplied identifier used to
+	// deduplicate retried pushes. It is not part of the binary header.
+	MessageID string
+	// Key is an optional ordering key choosing the channel partition.
+	// It is not part of the binary header.
+	Key string
+	// ReplyTo and CorrelationID route the reply to a request. They are not
+	// part of the binary header.
+	ReplyTo       string
+	CorrelationID string
+	// Encoding names the compression of Data, empty when raw. It is not part
+	// of the binary header.
+	Encoding string
+	// DecodedLen is the length of Data once decompressed, as checked on
+	// push, 0 when unknown.
+	DecodedLen uint32
 }
 
 // ReadFrameHeader reads channel and data length from the provided reader. Does not read the actual data.
@@ -75,3 +75,24 @@ func ReadFrameHeader(r io.Reader, maxChannelLen uint8, maxDataLen uint32) (Frame
 		DataLen:     dataLen,
 	}, br, nil
 }
+
+// WriteFrame writes a complete frame (header and data) using the format read
+// by ReadFrameHeader.
+func WriteFrame(w io.Writer, channel string, data []byte) error {
+	if len(channel) == 0 || len(channel) > 255 {
+		return ErrChannelTooLarge
+	}
+	if uint64(len(data)) > uint64(^uint32(0)) {
+		return ErrDataTooLarge
+	}
+
+	header := make([]byte, 0, 1+len(channel)+4)
+	header = append(header, uint8(len(channel)))
+	header = append(header, channel...)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
@@ -317,3 +317,33 @@ func TestReadFrameHeader_BoundaryValues(t *testing.T) {
 		}
 	})
 }
+
+func TestWriteFrame_RoundTrip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := WriteFrame(buf, "events", []byte("payload")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	frame, reader, err := ReadFrameHeader(buf, 255, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if frame.ChannelName != "events" {
+		t.Errorf("channel = %q, want %q", frame.ChannelName, "events")
+	}
+	data := make([]byte, frame.DataLen)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "payload" {
+		t.Errorf("data = %q, want %q", data, "payload")
+	}
+}
+
+func TestWriteFrame_InvalidChannel(t *testing.T) {
+	for _, channel := range []string{"", string(make([]byte, 256))} {
+		if err := WriteFrame(io.Discard, channel, nil); !errors.Is(err, ErrChannelTooLarge) {
+			t.Errorf("channel length %d: error = %v, want %v", len(channel), err, ErrChannelTooLarge)
+		}
+	}
+}
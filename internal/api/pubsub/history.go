@@ -0,0 +1,177 @@
+package pubsub
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// cursor is the next offset of a reading consumer and when it last moved.
+type cursor struct {
+	offset uint64
+	used   time.Time
+}
+
+// retain appends msg to the retained log of the channel, which keeps
+// published messages readable after they were popped, then drops the
+// messages beyond the retention limits. Reply and dead letter channels are
+// not replayed and retain nothing. Must be called with mu held.
+func (ch *Channel) retain(msg *Message, defaults *ChannelDefaults, now time.Time) {
+	if defaults.Retention <= 0 || IsReplyChannel(ch.Name) || IsDeadLetterChannel(ch.Name) {
+		ch.retained = nil
+		return
+	}
+	// Snapshots are restored partition after partition, out of sequence order
+	i := len(ch.retained)
+	for i > 0 && ch.retained[i-1].Seq > msg.Seq {
+		i--
+	}
+	ch.retained = slices.Insert(ch.retained, i, msg)
+	ch.trimRetained(defaults, now)
+}
+
+// trimRetained drops the retained messages older than the retention period
+// or in excess of the retention count. Must be called with mu held.
+func (ch *Channel) trimRetained(defaults *ChannelDefaults, now time.Time) {
+	drop := 0
+	if defaults.RetentionMax > 0 && len(ch.retained) > defaults.RetentionMax {
+		drop = len(ch.retained) - defaults.RetentionMax
+	}
+	oldest := now.Add(-time.Duration(defaults.Retention))
+	for drop < len(ch.retained) && ch.retained[drop].Time.Before(oldest) {
+		drop++
+	}
+	if drop > 0 {
+		// Clear the dropped messages so that they can be collected, then
+		// reslice: the next append that outgrows the array copies only the
+		// messages left, which keeps trimming amortized O(1) per publish
+		clear(ch.retained[:drop])
+		ch.retained = ch.retained[drop:]
+	}
+}
+
+// History is a page of the retained log of a channel.
+type History struct {
+	Messages    []*Message `json:"messages"`
+	FirstOffset uint64     `json:"first_offset"` // Oldest retained offset, 0 when nothing is retained
+	NextOffset  uint64     `json:"next_offset"`  // Offset to read from to continue
+}
+
+// history returns at most limit retained messages with an offset, that is
+// a sequence number, of at least from. Must be called with mu held.
+func (ch *Channel) history(from uint64, limit int) History {
+	h := History{Messages: []*Message{}}
+	if len(ch.retained) == 0 {
+		h.NextOffset = max(from, ch.lastSeq+1)
+		return h
+	}
+	h.FirstOffset = ch.retained[0].Seq
+
+	i := sort.Search(len(ch.retained), func(i int) bool {
+		return ch.retained[i].Seq >= from
+	})
+	end := min(i+limit, len(ch.retained))
+	h.Messages = append(h.Messages, ch.retained[i:end]...)
+	if end > i {
+		h.NextOffset = h.Messages[len(h.Messages)-1].Seq + 1
+	} else {
+		h.NextOffset = max(from, ch.lastSeq+1)
+	}
+	return h
+}
+
+// offsetAt returns the offset of the first retained message published at or
+// after t, or the next offset if there is none. Must be called with mu held.
+func (ch *Channel) offsetAt(t time.Time) uint64 {
+	i := sort.Search(len(ch.retained), func(i int) bool {
+		return !ch.retained[i].Time.Before(t)
+	})
+	if i < len(ch.retained) {
+		return ch.retained[i].Seq
+	}
+	return ch.lastSeq + 1
+}
+
+// Replay returns at most limit retained messages of ch from offset from.
+func (b *Broker) Replay(ch *Channel, from uint64, limit int) History {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.trimRetained(b.defaults.Load(), b.now())
+	return ch.history(from, limit)
+}
+
+// ReplaySince returns at most limit retained messages of ch published at or
+// after t.
+func (b *Broker) ReplaySince(ch *Channel, t time.Time, limit int) History {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.trimRetained(b.defaults.Load(), b.now())
+	return ch.history(ch.offsetAt(t), limit)
+}
+
+// ReadCursor returns at most limit retained messages from the cursor of
+// consumer, then moves the cursor past them. The cursor of a new consumer
+// starts at the oldest retained message.
+func (b *Broker) ReadCursor(ch *Channel, consumer string, limit int) History {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.trimRetained(b.defaults.Load(), b.now())
+	ch.pruneCursors(b.defaults.Load(), b.now())
+	h := ch.history(ch.cursors[consumer].offset, limit)
+	ch.cursors[consumer] = cursor{offset: h.NextOffset, used: b.now()}
+	return h
+}
+
+// CursorPosition is the offset a consumer reads next.
+type CursorPosition struct {
+	Consumer string `json:"consumer"`
+	Offset   uint64 `json:"offset"`
+}
+
+// SeekCursor moves the cursor of consumer to offset, so that it reads the
+// retained messages again from there.
+func (b *Broker) SeekCursor(ch *Channel, consumer string, offset uint64) CursorPosition {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.pruneCursors(b.defaults.Load(), b.now())
+	ch.cursors[consumer] = cursor{offset: offset, used: b.now()}
+	return CursorPosition{Consumer: consumer, Offset: offset}
+}
+
+// SeekCursorTime moves the cursor of consumer to the first retained message
+// published at or after t.
+func (b *Broker) SeekCursorTime(ch *Channel, consumer string, t time.Time) CursorPosition {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.pruneCursors(b.defaults.Load(), b.now())
+	offset := ch.offsetAt(t)
+	ch.cursors[consumer] = cursor{offset: offset, used: b.now()}
+	return CursorPosition{Consumer: consumer, Offset: offset}
+}
+
+// Cursors returns the cursors of ch sorted by consumer.
+func (b *Broker) Cursors(ch *Channel) []CursorPosition {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.pruneCursors(b.defaults.Load(), b.now())
+	list := make([]CursorPosition, 0, len(ch.cursors))
+	for consumer, c := range ch.cursors {
+		list = append(list, CursorPosition{Consumer: consumer, Offset: c.offset})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Consumer < list[j].Consumer
+	})
+	return list
+}
+
+// pruneCursors drops the cursors which did not move for longer than the
+// retention period. Every message they had yet to read has expired since, so
+// starting over from the oldest retained message is the same. Must be called
+// with mu held.
+func (ch *Channel) pruneCursors(defaults *ChannelDefaults, now time.Time) {
+	for consumer, c := range ch.cursors {
+		if now.Sub(c.used) > time.Duration(defaults.Retention) {
+			delete(ch.cursors, consumer)
+		}
+	}
+}
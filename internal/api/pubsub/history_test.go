@@ -0,0 +1,221 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+// retainingBroker returns a broker retaining published messages for an hour.
+func retainingBroker() *Broker {
+	b := NewBroker()
+	defaults := DefaultConfig().Channels
+	defaults.Retention = Duration(time.Hour)
+	b.SetDefaults(defaults)
+	return b
+}
+
+func publishAll(t *testing.T, b *Broker, channel string, advance func(time.Duration), data ...string) {
+	t.Helper()
+	for _, d := range data {
+		if _, err := b.Publish(&Frame{ChannelName: channel, Data: []byte(d)}); err != nil {
+			t.Fatalf("publish %q: %v", d, err)
+		}
+		advance(time.Minute)
+	}
+}
+
+func historyData(h History) []string {
+	data := make([]string, len(h.Messages))
+	for i, msg := range h.Messages {
+		data[i] = string(msg.Data)
+	}
+	return data
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBroker_Replay(t *testing.T) {
+	b := retainingBroker()
+	clock, advance := fakeClock(time.Unix(1000, 0))
+	b.now = clock
+	publishAll(t, b, "events", advance, "a", "b", "c", "d")
+	ch, _ := b.Lookup("events")
+
+	// Popped messages remain readable
+	b.Pop(ch, "")
+	b.Pop(ch, "")
+
+	tests := []struct {
+		name string
+		got  History
+		want []string
+		next uint64
+	}{
+		{"from start", b.Replay(ch, 0, 10), []string{"a", "b", "c", "d"}, 5},
+		{"from offset", b.Replay(ch, 3, 10), []string{"c", "d"}, 5},
+		{"limited", b.Replay(ch, 2, 1), []string{"b"}, 3},
+		{"past the end", b.Replay(ch, 9, 10), []string{}, 9},
+		{"since", b.ReplaySince(ch, time.Unix(1000, 0).Add(90*time.Second), 10), []string{"c", "d"}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := historyData(tt.got); !equalStrings(got, tt.want) {
+				t.Errorf("messages = %v, want %v", got, tt.want)
+			}
+			if tt.got.NextOffset != tt.next {
+				t.Errorf("next offset = %d, want %d", tt.got.NextOffset, tt.next)
+			}
+		})
+	}
+}
+
+func TestBroker_RetentionLimits(t *testing.T) {
+	b := NewBroker()
+	clock, advance := fakeClock(time.Unix(1000, 0))
+	b.now = clock
+	b.SetDefaults(ChannelDefaults{Partitions: 1, Retention: Duration(3 * time.Minute), RetentionMax: 2})
+
+	publishAll(t, b, "events", advance, "a", "b", "c")
+	ch, _ := b.Lookup("events")
+	h := b.Replay(ch, 0, 10)
+	if got := historyData(h); !equalStrings(got, []string{"b", "c"}) || h.FirstOffset != 2 {
+		t.Errorf("count limit: got %v from %d", got, h.FirstOffset)
+	}
+
+	advance(2 * time.Minute)
+	if got := historyData(b.Replay(ch, 0, 10)); !equalStrings(got, []string{"c"}) {
+		t.Errorf("age limit: got %v", got)
+	}
+
+	b.SetDefaults(ChannelDefaults{Partitions: 1})
+	publishAll(t, b, "events", advance, "d")
+	if h := b.Replay(ch, 0, 10); len(h.Messages) != 0 || h.NextOffset != 5 {
+		t.Errorf("disabled retention: got %v, next %d", historyData(h), h.NextOffset)
+	}
+}
+
+func TestBroker_Cursor(t *testing.T) {
+	b := retainingBroker()
+	clock, advance := fakeClock(time.Unix(1000, 0))
+	b.now = clock
+	publishAll(t, b, "events", advance, "a", "b", "c")
+	ch, _ := b.Lookup("events")
+
+	if got := historyData(b.ReadCursor(ch, "billing", 2)); !equalStrings(got, []string{"a", "b"}) {
+		t.Errorf("first read = %v", got)
+	}
+	if got := historyData(b.ReadCursor(ch, "billing", 2)); !equalStrings(got, []string{"c"}) {
+		t.Errorf("second read = %v", got)
+	}
+	if got := historyData(b.ReadCursor(ch, "billing", 2)); len(got) != 0 {
+		t.Errorf("read at the end = %v", got)
+	}
+
+	// Seek back to reprocess
+	if pos := b.SeekCursor(ch, "billing", 2); pos.Offset != 2 {
+		t.Errorf("seek offset = %d", pos.Offset)
+	}
+	if got := historyData(b.ReadCursor(ch, "billing", 10)); !equalStrings(got, []string{"b", "c"}) {
+		t.Errorf("read after seek = %v", got)
+	}
+	if pos := b.SeekCursorTime(ch, "billing", time.Unix(1000, 0).Add(2*time.Minute)); pos.Offset != 3 {
+		t.Errorf("seek time offset = %d, want 3", pos.Offset)
+	}
+
+	cursors := b.Cursors(ch)
+	if len(cursors) != 1 || cursors[0] != (CursorPosition{Consumer: "billing", Offset: 3}) {
+		t.Errorf("cursors = %v", cursors)
+	}
+}
+
+func TestBroker_RetentionSkipsReplyAndDeadLetters(t *testing.T) {
+	b := retainingBroker()
+	replies := b.acquireReplyChannel(newReplyChannelName())
+	defer b.releaseReplyChannel(replies)
+	b.Channel(DeadLetterPrefix+"events").deadLetter(&Message{Data: []byte("x")}, "events", nil)
+	for _, name := range []string{replies.Name, "events"} {
+		if _, err := b.Publish(&Frame{ChannelName: name, Data: []byte("x")}); err != nil {
+			t.Fatalf("publish to %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{replies.Name, DeadLetterPrefix + "events", "events"} {
+		ch, _ := b.Lookup(name)
+		want := 0
+		if name == "events" {
+			want = 1
+		}
+		if got := len(b.Replay(ch, 0, 10).Messages); got != want {
+			t.Errorf("%s: expected %d retained messages, got %d", name, want, got)
+		}
+	}
+}
+
+func TestBroker_PruneCursors(t *testing.T) {
+	b := retainingBroker()
+	clock, advance := fakeClock(time.Unix(1000, 0))
+	b.now = clock
+	publishAll(t, b, "events", advance, "a")
+	ch, _ := b.Lookup("events")
+
+	b.ReadCursor(ch, "one-off", 10)
+	advance(30 * time.Minute)
+	b.ReadCursor(ch, "billing", 10)
+	advance(45 * time.Minute)
+
+	cursors := b.Cursors(ch)
+	if len(cursors) != 1 || cursors[0].Consumer != "billing" {
+		t.Errorf("expected only the billing cursor to be kept, got %v", cursors)
+	}
+}
+
+func TestReplicaSnapshot_Retained(t *testing.T) {
+	primary := retainingBroker()
+	primary.EnableReplicationLog(10, 0)
+	publishAll(t, primary, "events", func(time.Duration) {}, "a", "b")
+	events, _ := primary.Lookup("events")
+	primary.Pop(events, "")
+
+	follower := retainingBroker()
+	follower.loadReplica(primary.ReplicaSnapshot())
+	ch, _ := follower.Lookup("events")
+	if got := historyData(follower.Replay(ch, 0, 10)); !equalStrings(got, []string{"a", "b"}) {
+		t.Errorf("expected the popped message to stay retained, got %v", got)
+	}
+}
+
+func TestBroker_RetentionMax(t *testing.T) {
+	b := NewBroker()
+	defaults := DefaultConfig().Channels
+	defaults.Retention = Duration(time.Hour)
+	defaults.RetentionMax = 100
+	b.SetDefaults(defaults)
+
+	const published = 10000
+	for i := 0; i < published; i++ {
+		if _, err := b.Publish(&Frame{ChannelName: "events", Data: []byte("x")}); err != nil {
+			t.Fatalf("publish %d: %v", i, err)
+		}
+	}
+	ch, _ := b.Lookup("events")
+
+	h := b.Replay(ch, 0, published)
+	if len(h.Messages) != 100 || h.FirstOffset != published-99 || h.NextOffset != published+1 {
+		t.Errorf("expected offsets %d to %d, got %d messages from %d to %d",
+			published-99, published, len(h.Messages), h.FirstOffset, h.NextOffset-1)
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if c := cap(ch.retained); c > 4*defaults.RetentionMax {
+		t.Errorf("retained capacity %d grew past the retention limit of %d", c, defaults.RetentionMax)
+	}
+}
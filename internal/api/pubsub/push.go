@@ -1,45 +1,177 @@
 package pubsub
 
 import (
+	"errors"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 
+	"github.com/forgeronvirtuel/lab-golang/internal/largedataset"
 	"github.com/gin-gonic/gin"
 )
 
+// FrameSink receives the frames accepted by a PushHandler.
+// Both *Queue[*Frame] and *Broker implement it.
+type FrameSink interface {
+	Enqueue(frame *Frame)
+}
+
+// Publisher is implemented by sinks which assign sequence numbers and may
+// refuse a frame, such as a Broker. PushHandler prefers it over Enqueue.
+type Publisher interface {
+	Publish(frame *Frame) (PublishResult, error)
+}
+
 type PushHandler struct {
-	Queue *Queue[*Frame]
+	Queue FrameSink
+	// Limiter is optional. When set, pushes exceeding the per client or per
+	// channel limits are rejected with 429 Too Many Requests.
+	Limiter *RateLimiter
+
+	limits   atomic.Pointer[Limits]
+	draining atomic.Bool
 }
 
-func NewPushHandler(q *Queue[*Frame]) *PushHandler {
-	return &PushHandler{Queue: q}
+func NewPushHandler(q FrameSink) *PushHandler {
+	h := &PushHandler{Queue: q}
+	h.SetLimits(DefaultLimits())
+	return h
 }
 
-const maxBody = int64(50 << 20) // 50 MiB
+// maxChannelLen is the largest channel name a frame can carry.
 const maxChannelLen = uint8(255)
 
+// SetLimits replaces the frame limits. It is safe to call while requests are
+// being served, which allows reloading the configuration.
+func (h *PushHandler) SetLimits(limits Limits) {
+	h.limits.Store(&limits)
+}
+
+// MessageIDHeader carries an optional identifier making retried pushes
+// idempotent within the channel dedup window.
+const MessageIDHeader = "X-Message-ID"
+
+// PartitionKeyHeader carries an optional ordering key. Messages sharing a key
+// go to the same partition and are consumed in order.
+const PartitionKeyHeader = "X-Partition-Key"
+
+// ReplyToHeader and CorrelationIDHeader route the reply to a request. They
+// are set by the request endpoint and must be echoed by responders.
+const (
+	ReplyToHeader       = "X-Reply-To"
+	CorrelationIDHeader = "X-Correlation-ID"
+)
+
+// Drain makes the handler reject new pushes with 503 Service Unavailable,
+// so that the broker can be shut down without accepting more messages.
+func (h *PushHandler) Drain() {
+	h.draining.Store(true)
+}
+
 // HandlePush processes incoming push requests and enqueues messages.
 func (h *PushHandler) HandlePush(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "broker is shutting down"})
+		return
+	}
 
 	// Read the header
-	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody)
-	frame, br, err := ReadFrameHeader(c.Request.Body, maxChannelLen, 50<<20)
+	limits := h.limits.Load()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxBody)
+	frame, br, err := ReadFrameHeader(c.Request.Body, limits.MaxChannelLen, limits.MaxData)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Apply rate limits before reading the payload
+	if !h.allow(c, frame.ChannelName) {
+		return
+	}
+
 	// Read the message data
 	data := make([]byte, frame.DataLen)
-	if _, err := br.Read(data); err != nil {
+	if _, err := io.ReadFull(br, data); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read message data"})
 		return
 	}
 
+	encoding, decodedLen, ok := frameEncoding(c, data, limits.MaxData)
+	if !ok {
+		return
+	}
+
 	frame.Data = data
+	frame.Encoding = encoding
+	frame.DecodedLen = decodedLen
+	frame.MessageID = c.GetHeader(MessageIDHeader)
+	frame.Key = c.GetHeader(PartitionKeyHeader)
+	frame.ReplyTo = c.GetHeader(ReplyToHeader)
+	frame.CorrelationID = c.GetHeader(CorrelationIDHeader)
 
 	// Enqueue the message
-	h.Queue.Enqueue(&frame)
+	p, ok := h.Queue.(Publisher)
+	if !ok {
+		h.Queue.Enqueue(&frame)
+		c.Status(http.StatusCreated)
+		return
+	}
+
+	result, err := p.Publish(&frame)
+	if err != nil {
+		publishError(c, err)
+		return
+	}
 
-	// Respond with success
-	c.Status(http.StatusCreated)
+	// Respond with success, a duplicate is acknowledged without being created
+	status := http.StatusCreated
+	if result.Duplicate {
+		status = http.StatusOK
+	}
+	c.JSON(status, result)
+}
+
+// allow applies the rate limits to a push to channel. When the push is
+// throttled, the 429 response is written and false is returned.
+func (h *PushHandler) allow(c *gin.Context, channel string) bool {
+	if h.Limiter == nil {
+		return true
+	}
+	// Producers are told apart by IP, a header they choose could not be trusted
+	ok, wait := h.Limiter.Allow(c.ClientIP(), channel)
+	if !ok {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+	}
+	return ok
+}
+
+// publishError writes the response for an error returned by Publish. Schema
+// violations detail the offending row and column.
+func publishError(c *gin.Context, err error) {
+	var schemaErr *SchemaError
+	if errors.As(err, &schemaErr) {
+		resp := gin.H{"error": err.Error(), "row": schemaErr.Row}
+		var validationErr *largedataset.ValidationError
+		if errors.As(err, &validationErr) {
+			resp["column"] = validationErr.Column
+			resp["column_name"] = validationErr.ColName
+			resp["value"] = validationErr.Value
+			resp["expected"] = validationErr.Expected
+		}
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	status := http.StatusServiceUnavailable
+	switch {
+	case errors.Is(err, ErrNoReplyChannel):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrDeadLetterChannel):
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
 }
@@ -191,3 +191,168 @@ func TestHandlePush_MaxBodySize(t *testing.T) {
 		t.Errorf("expected queue size 0, got %d", queue.Size())
 	}
 }
+
+func TestHandlePush_RateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	queue := NewQueue[*Frame]()
+	handler := NewPushHandler(queue)
+	handler.Limiter = NewRateLimiter(RateLimit{Rate: 1, Burst: 1}, RateLimit{})
+
+	r := gin.New()
+	r.POST("/push", handler.HandlePush)
+
+	push := func(ip, clientID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/push", buildFrameData("test", []byte("msg")))
+		req.RemoteAddr = ip + ":40000"
+		req.Header.Set("X-Client-ID", clientID)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := push("10.0.0.1", "producer"); w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	// A new client ID header does not give the same IP a fresh bucket
+	w := push("10.0.0.1", "renamed")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After 1, got %q", got)
+	}
+
+	if w := push("10.0.0.2", "producer"); w.Code != http.StatusCreated {
+		t.Errorf("expected status %d for another client, got %d", http.StatusCreated, w.Code)
+	}
+
+	if queue.Size() != 2 {
+		t.Errorf("expected queue size 2, got %d", queue.Size())
+	}
+}
+
+func TestHandlePush_Draining(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	queue := NewQueue[*Frame]()
+	handler := NewPushHandler(queue)
+	handler.Drain()
+
+	r := gin.New()
+	r.POST("/push", handler.HandlePush)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/push", buildFrameData("test", []byte("msg"))))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if queue.Size() != 0 {
+		t.Errorf("expected queue size 0, got %d", queue.Size())
+	}
+}
+
+func TestHandlePush_SetLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	queue := NewQueue[*Frame]()
+	handler := NewPushHandler(queue)
+	handler.SetLimits(Limits{MaxBody: 1024, MaxChannelLen: 4, MaxData: 8})
+
+	r := gin.New()
+	r.POST("/push", handler.HandlePush)
+
+	tests := []struct {
+		name           string
+		channel        string
+		message        []byte
+		expectedStatus int
+	}{
+		{"within limits", "test", []byte("12345678"), http.StatusCreated},
+		{"channel too long", "events", []byte("msg"), http.StatusBadRequest},
+		{"data too large", "test", []byte("123456789"), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("POST", "/push", buildFrameData(tt.channel, tt.message)))
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandlePush_ChannelFull(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	broker := NewBroker()
+	broker.SetDefaults(ChannelDefaults{MaxDepth: 1})
+	handler := NewPushHandler(broker)
+
+	r := gin.New()
+	r.POST("/push", handler.HandlePush)
+
+	codes := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/push", buildFrameData("test", []byte("msg"))))
+		codes = append(codes, w.Code)
+	}
+
+	if codes[0] != http.StatusCreated || codes[1] != http.StatusServiceUnavailable {
+		t.Errorf("expected statuses [201 503], got %v", codes)
+	}
+}
+
+func TestHandlePush_DeadLetterChannel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	broker := NewBroker()
+	r := gin.New()
+	r.POST("/push", NewPushHandler(broker).HandlePush)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/push", buildFrameData(DeadLetterPrefix+"sub1", []byte("forged"))))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+	if _, ok := broker.Lookup(DeadLetterPrefix + "sub1"); ok {
+		t.Error("expected no dead letter channel to be created")
+	}
+}
+
+func TestHandlePush_Idempotent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	broker := NewBroker()
+	handler := NewPushHandler(broker)
+
+	r := gin.New()
+	r.POST("/push", handler.HandlePush)
+
+	push := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/push", buildFrameData("trades", []byte("AAPL,100")))
+		req.Header.Set(MessageIDHeader, id)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := push("trade-1")
+	if w.Code != http.StatusCreated || w.Body.String() != `{"sequence":1,"partition":0}` {
+		t.Errorf("expected 201 for sequence 1, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = push("trade-1")
+	if w.Code != http.StatusOK || w.Body.String() != `{"sequence":1,"partition":0,"duplicate":true}` {
+		t.Errorf("expected 200 duplicate of sequence 1, got %d %s", w.Code, w.Body.String())
+	}
+
+	if ch, _ := broker.Lookup("trades"); ch.Size() != 1 {
+		t.Errorf("expected queue size 1, got %d", ch.Size())
+	}
+}
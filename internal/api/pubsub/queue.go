@@ -1,11 +1,18 @@
 package pubsub
 
+import (
+	"iter"
+	"slices"
+	"sync"
+)
+
 type node[T any] struct {
 	value T
 	next  *node[T]
 }
 
 type Queue[T any] struct {
+	mu   sync.Mutex
 	head *node[T]
 	tail *node[T]
 	size int
@@ -16,6 +23,12 @@ func NewQueue[T any]() *Queue[T] {
 }
 
 func (q *Queue[T]) Enqueue(value T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.enqueue(value)
+}
+
+func (q *Queue[T]) enqueue(value T) {
 	newNode := &node[T]{value: value}
 	if q.tail != nil {
 		q.tail.next = newNode
@@ -26,7 +39,32 @@ func (q *Queue[T]) Enqueue(value T) {
 	q.size++
 }
 
+// EnqueueBounded enqueues value unless the queue already holds limit
+// elements. A limit of zero or less means unbounded.
+func (q *Queue[T]) EnqueueBounded(value T, limit int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if limit > 0 && q.size >= limit {
+		return false
+	}
+	q.enqueue(value)
+	return true
+}
+
+// Requeue puts value back at the front of the queue, to be dequeued next.
+func (q *Queue[T]) Requeue(value T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.head = &node[T]{value: value, next: q.head}
+	if q.tail == nil {
+		q.tail = q.head
+	}
+	q.size++
+}
+
 func (q *Queue[T]) Dequeue() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	if q.head == nil {
 		var zero T
 		return zero, false
@@ -40,10 +78,57 @@ func (q *Queue[T]) Dequeue() (T, bool) {
 	return value, true
 }
 
+// RemoveFirst removes and returns the oldest element for which match returns
+// true, leaving the other elements in place.
+func (q *Queue[T]) RemoveFirst(match func(T) bool) (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var prev *node[T]
+	for n := q.head; n != nil; prev, n = n, n.next {
+		if !match(n.value) {
+			continue
+		}
+		if prev == nil {
+			q.head = n.next
+		} else {
+			prev.next = n.next
+		}
+		if q.tail == n {
+			q.tail = prev
+		}
+		q.size--
+		return n.value, true
+	}
+	var zero T
+	return zero, false
+}
+
+// All returns an iterator over the elements, oldest first, which leaves them
+// in the queue. The queue is locked until the iteration ends, so the loop
+// body must not call its methods.
+func (q *Queue[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for n := q.head; n != nil; n = n.next {
+			if !yield(n.value) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns a copy of the elements, oldest first.
+func (q *Queue[T]) Values() []T {
+	return slices.Collect(q.All())
+}
+
 func (q *Queue[T]) Size() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	return q.size
 }
 
 func (q *Queue[T]) IsEmpty() bool {
-	return q.size == 0
+	return q.Size() == 0
 }
@@ -107,3 +107,89 @@ func TestSize(t *testing.T) {
 		t.Errorf("expected size 0 after dequeuing all items, got %d", q.Size())
 	}
 }
+
+func TestEnqueueBounded(t *testing.T) {
+	q := NewQueue[[]byte]()
+	if !q.EnqueueBounded([]byte("first"), 2) {
+		t.Errorf("expected enqueue below limit to succeed")
+	}
+	if !q.EnqueueBounded([]byte("second"), 2) {
+		t.Errorf("expected enqueue below limit to succeed")
+	}
+	if q.EnqueueBounded([]byte("third"), 2) {
+		t.Errorf("expected enqueue at limit to fail")
+	}
+	if q.Size() != 2 {
+		t.Errorf("expected size 2, got %d", q.Size())
+	}
+	if !q.EnqueueBounded([]byte("third"), 0) {
+		t.Errorf("expected enqueue without limit to succeed")
+	}
+}
+
+func TestRemoveFirst(t *testing.T) {
+	q := NewQueue[[]byte]()
+	for _, v := range []string{"a1", "b1", "a2", "b2"} {
+		q.Enqueue([]byte(v))
+	}
+	startsWith := func(prefix byte) func([]byte) bool {
+		return func(v []byte) bool { return v[0] == prefix }
+	}
+
+	value, ok := q.RemoveFirst(startsWith('b'))
+	if !ok || string(value) != "b1" {
+		t.Errorf("expected 'b1', got '%s'", value)
+	}
+	value, ok = q.RemoveFirst(startsWith('b'))
+	if !ok || string(value) != "b2" {
+		t.Errorf("expected 'b2', got '%s'", value)
+	}
+	if _, ok := q.RemoveFirst(startsWith('c')); ok {
+		t.Errorf("expected no match")
+	}
+	if q.Size() != 2 {
+		t.Errorf("expected size 2, got %d", q.Size())
+	}
+
+	// Removing the tail keeps enqueue working
+	q.Enqueue([]byte("a3"))
+	for _, want := range []string{"a1", "a2", "a3"} {
+		value, _ := q.Dequeue()
+		if string(value) != want {
+			t.Errorf("expected '%s', got '%s'", want, value)
+		}
+	}
+	if !q.IsEmpty() {
+		t.Errorf("expected empty queue")
+	}
+}
+
+func TestQueue_All(t *testing.T) {
+	q := NewQueue[int]()
+	for i := 1; i <= 5; i++ {
+		q.Enqueue(i)
+	}
+
+	var got []int
+	for v := range q.All() {
+		if v == 4 {
+			break
+		}
+		got = append(got, v)
+	}
+	if len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("expected 1, 2, 3, got %v", got)
+	}
+	if q.Size() != 5 {
+		t.Errorf("expected iteration to leave 5 elements, got %d", q.Size())
+	}
+
+	// The lock is released after an early break
+	q.Dequeue()
+	if values := q.Values(); len(values) != 4 || values[0] != 2 {
+		t.Errorf("expected 2 to 5, got %v", values)
+	}
+	for range NewQueue[int]().All() {
+		t.Error("expected no element in an empty queue")
+	}
+}
@@ -0,0 +1,213 @@
+package pubsub
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a token bucket: Rate tokens are refilled per second,
+// up to Burst tokens. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate" toml:"rate"`
+	Burst int     `yaml:"burst" toml:"burst"`
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+// capacity returns the bucket size, defaulting to one second worth of tokens.
+func (l RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// RateCounters holds the rate limiting decisions taken for a channel.
+type RateCounters struct {
+	Allowed   uint64 `json:"allowed"`
+	Throttled uint64 `json:"throttled"`
+}
+
+// channelCounters are the counters of a channel with the time of its last push.
+type channelCounters struct {
+	RateCounters
+	last time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last call and returns the bucket level.
+func (b *bucket) refill(limit RateLimit, now time.Time) float64 {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(limit.capacity(), b.tokens+elapsed*limit.Rate)
+		b.last = now
+	}
+	return b.tokens
+}
+
+// wait returns how long it takes for the bucket to hold one token.
+func (b *bucket) wait(limit RateLimit) time.Duration {
+	missing := 1 - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / limit.Rate * float64(time.Second))
+}
+
+// idleBucketTTL is how often buckets which have refilled completely are
+// dropped, along with the counters of the channels gone without pushes since.
+const idleBucketTTL = time.Minute
+
+// RateLimiter applies token bucket limits keyed by client identity and by
+// channel. A push must be allowed by both buckets to proceed.
+type RateLimiter struct {
+	mu         sync.Mutex
+	perClient  RateLimit
+	perChannel RateLimit
+	clients    map[string]*bucket
+	channels   map[string]*bucket
+	counters   map[string]*channelCounters
+	lastPrune  time.Time
+	now        func() time.Time
+	// exists reports whether a channel still exists, nil to keep the
+	// counters of every channel
+	exists func(channel string) bool
+}
+
+func NewRateLimiter(perClient, perChannel RateLimit) *RateLimiter {
+	return &RateLimiter{
+		perClient:  perClient,
+		perChannel: perChannel,
+		clients:    make(map[string]*bucket),
+		channels:   make(map[string]*bucket),
+		counters:   make(map[string]*channelCounters),
+		now:        time.Now,
+	}
+}
+
+// Allow reports whether client may push to channel. When it may not, the
+// returned duration is how long the caller should wait before retrying.
+func (l *RateLimiter) Allow(client, channel string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	counters, ok := l.counters[channel]
+	if !ok {
+		counters = &channelCounters{}
+		l.counters[channel] = counters
+	}
+	counters.last = now
+
+	var wait time.Duration
+	clientBucket := l.bucket(l.clients, l.perClient, client, now)
+	channelBucket := l.bucket(l.channels, l.perChannel, channel, now)
+	for _, b := range []struct {
+		bucket *bucket
+		limit  RateLimit
+	}{
+		{clientBucket, l.perClient},
+		{channelBucket, l.perChannel},
+	} {
+		if b.bucket != nil && b.bucket.refill(b.limit, now) < 1 {
+			wait = max(wait, b.bucket.wait(b.limit))
+		}
+	}
+
+	if wait > 0 {
+		counters.Throttled++
+		return false, wait
+	}
+
+	// Only consume tokens once both buckets agreed
+	if clientBucket != nil {
+		clientBucket.tokens--
+	}
+	if channelBucket != nil {
+		channelBucket.tokens--
+	}
+	counters.Allowed++
+	return true, 0
+}
+
+// SetLimits replaces the limits applied to subsequent pushes. Existing
+// buckets keep their tokens, capped to the new burst size on next refill.
+func (l *RateLimiter) SetLimits(perClient, perChannel RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perClient = perClient
+	l.perChannel = perChannel
+}
+
+// SetChannelLookup lets the counters of the channels for which exists returns
+// false be pruned once idle. Channel names come from the clients, so that
+// the counters of rejected or deleted channels do not accumulate.
+func (l *RateLimiter) SetChannelLookup(exists func(channel string) bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.exists = exists
+}
+
+// Counters returns the decisions taken so far for channel.
+func (l *RateLimiter) Counters(channel string) RateCounters {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.counters[channel]; ok {
+		return c.RateCounters
+	}
+	return RateCounters{}
+}
+
+// bucket returns the bucket for key, or nil when limit is disabled.
+func (l *RateLimiter) bucket(buckets map[string]*bucket, limit RateLimit, key string, now time.Time) *bucket {
+	if !limit.enabled() {
+		return nil
+	}
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.capacity(), last: now}
+		buckets[key] = b
+	}
+	return b
+}
+
+// prune drops buckets that are full again, so that one-off clients do not
+// accumulate in memory. A full bucket behaves exactly like a new one. The
+// counters of idle channels go too once the channels do not exist anymore.
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < idleBucketTTL {
+		return
+	}
+	l.lastPrune = now
+
+	for _, set := range []struct {
+		buckets map[string]*bucket
+		limit   RateLimit
+	}{
+		{l.clients, l.perClient},
+		{l.channels, l.perChannel},
+	} {
+		for key, b := range set.buckets {
+			if !set.limit.enabled() || b.refill(set.limit, now) >= set.limit.capacity() {
+				delete(set.buckets, key)
+			}
+		}
+	}
+	if l.exists == nil {
+		return
+	}
+	for channel, c := range l.counters {
+		if now.Sub(c.last) >= idleBucketTTL && !l.exists(channel) {
+			delete(l.counters, channel)
+		}
+	}
+}
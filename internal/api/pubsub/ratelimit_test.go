@@ -0,0 +1,136 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock returns a controllable time source for the rate limiter.
+func fakeClock(start time.Time) (func() time.Time, func(time.Duration)) {
+	now := start
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiter_Burst(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 1, Burst: 3}, RateLimit{})
+	clock, _ := fakeClock(time.Unix(0, 0))
+	l.now = clock
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("client", "events"); !ok {
+			t.Fatalf("push %d: expected allowed within burst", i+1)
+		}
+	}
+
+	ok, wait := l.Allow("client", "events")
+	if ok {
+		t.Fatal("expected push beyond burst to be throttled")
+	}
+	if wait != time.Second {
+		t.Errorf("wait = %v, want %v", wait, time.Second)
+	}
+
+	// Another client has its own bucket
+	if ok, _ := l.Allow("other", "events"); !ok {
+		t.Error("expected other client to be allowed")
+	}
+}
+
+func TestRateLimiter_Refill(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 2, Burst: 1}, RateLimit{})
+	clock, advance := fakeClock(time.Unix(0, 0))
+	l.now = clock
+
+	if ok, _ := l.Allow("client", "events"); !ok {
+		t.Fatal("expected first push to be allowed")
+	}
+	if ok, _ := l.Allow("client", "events"); ok {
+		t.Fatal("expected second push to be throttled")
+	}
+
+	advance(500 * time.Millisecond)
+	if ok, _ := l.Allow("client", "events"); !ok {
+		t.Error("expected push to be allowed after refill")
+	}
+}
+
+func TestRateLimiter_PerChannel(t *testing.T) {
+	l := NewRateLimiter(RateLimit{}, RateLimit{Rate: 1, Burst: 2})
+	clock, _ := fakeClock(time.Unix(0, 0))
+	l.now = clock
+
+	l.Allow("a", "events")
+	l.Allow("b", "events")
+	if ok, _ := l.Allow("c", "events"); ok {
+		t.Error("expected channel limit to apply across clients")
+	}
+	if ok, _ := l.Allow("c", "other"); !ok {
+		t.Error("expected other channel to be allowed")
+	}
+
+	got := l.Counters("events")
+	if got.Allowed != 2 || got.Throttled != 1 {
+		t.Errorf("counters = %+v, want 2 allowed and 1 throttled", got)
+	}
+}
+
+func TestRateLimiter_ThrottledDoesNotConsume(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 1, Burst: 1}, RateLimit{Rate: 1, Burst: 1})
+	clock, advance := fakeClock(time.Unix(0, 0))
+	l.now = clock
+
+	l.Allow("a", "events")
+	// Client b is throttled by the channel, its own bucket must stay full
+	if ok, _ := l.Allow("b", "events"); ok {
+		t.Fatal("expected channel limit to throttle client b")
+	}
+	if ok, _ := l.Allow("b", "other"); !ok {
+		t.Error("expected client b to still have a token")
+	}
+
+	advance(time.Second)
+	if ok, _ := l.Allow("a", "events"); !ok {
+		t.Error("expected push to be allowed after refill")
+	}
+}
+
+func TestRateLimiter_PruneIdleBuckets(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 10}, RateLimit{})
+	clock, advance := fakeClock(time.Unix(0, 0))
+	l.now = clock
+
+	l.Allow("one-off", "events")
+	advance(2 * idleBucketTTL)
+	l.Allow("client", "events")
+
+	if _, ok := l.clients["one-off"]; ok {
+		t.Error("expected idle bucket to be pruned")
+	}
+}
+
+func TestRateLimiter_PruneIdleCounters(t *testing.T) {
+	l := NewRateLimiter(RateLimit{}, RateLimit{Rate: 10})
+	clock, advance := fakeClock(time.Unix(0, 0))
+	l.now = clock
+	b := NewBroker()
+	b.Channel("quiet")
+	l.SetChannelLookup(func(channel string) bool {
+		_, ok := b.Lookup(channel)
+		return ok
+	})
+
+	l.Allow("client", "typo")
+	l.Allow("client", "quiet")
+	advance(2 * idleBucketTTL)
+	l.Allow("client", "events")
+
+	if _, ok := l.counters["typo"]; ok {
+		t.Error("expected counters of an idle unknown channel to be pruned")
+	}
+	if got := l.Counters("quiet"); got.Allowed != 1 {
+		t.Errorf("expected the counters of an existing idle channel kept, got %+v", got)
+	}
+	if got := l.Counters("events"); got.Allowed != 1 {
+		t.Errorf("expected 1 allowed push on events, got %+v", got)
+	}
+}
@@ -0,0 +1,412 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReplicaSnapshot is a copy of the channels of a primary, taken once its log
+// reached Index. Followers load it, then replay the log from Index.
+type ReplicaSnapshot struct {
+	Epoch    string           `json:"epoch"` // Epoch of the log Index belongs to
+	Index    uint64           `json:"index"`
+	Channels []ReplicaChannel `json:"channels"`
+}
+
+type ReplicaChannel struct {
+	Name       string     `json:"name"`
+	Partitions int        `json:"partitions"`
+	LastSeq    uint64     `json:"last_seq"`
+	Messages   []*Message `json:"messages"`
+	Retained   []*Message `json:"retained,omitempty"` // Retained messages, popped or not
+}
+
+// ReplicaSnapshot copies the queued and retained messages of every channel
+// but the reply ones, without removing them. Changes racing with the copy are
+// also in the log after Index, and replaying them over the copy is harmless.
+func (b *Broker) ReplicaSnapshot() ReplicaSnapshot {
+	snap := ReplicaSnapshot{Epoch: b.log.Epoch(), Index: b.log.Head(), Channels: []ReplicaChannel{}}
+	for _, ch := range b.Channels() {
+		if IsReplyChannel(ch.Name) {
+			continue
+		}
+		rc := ReplicaChannel{Name: ch.Name, Partitions: len(ch.Partitions), Messages: []*Message{}}
+		ch.mu.Lock()
+		rc.LastSeq = ch.lastSeq
+		for _, q := range ch.Partitions {
+			rc.Messages = append(rc.Messages, q.Values()...)
+		}
+		rc.Retained = slices.Clone(ch.retained)
+		ch.mu.Unlock()
+		snap.Channels = append(snap.Channels, rc)
+	}
+	return snap
+}
+
+// loadReplica replaces the channels with the ones of snap.
+func (b *Broker) loadReplica(snap ReplicaSnapshot) {
+	channels := make(map[string]*Channel, len(snap.Channels))
+	for _, rc := range snap.Channels {
+		ch := NewChannel(rc.Name, rc.Partitions)
+		ch.log = b.log
+		for _, msg := range rc.Messages {
+			msg.Partition %= len(ch.Partitions)
+			ch.Partitions[msg.Partition].Enqueue(msg)
+		}
+		ch.lastSeq = rc.LastSeq
+		ch.retained = rc.Retained
+		channels[rc.Name] = ch
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, ch := range b.channels {
+		if IsReplyChannel(name) {
+			channels[name] = ch
+		}
+	}
+	b.channels = channels
+}
+
+// applyEntry replays a change of the primary.
+func (b *Broker) applyEntry(e LogEntry) error {
+	switch e.Op {
+	case OpPublish:
+		// Requesters wait on the primary, which holds their reply channels
+		if IsReplyChannel(e.Channel) {
+			return nil
+		}
+		if e.Message == nil {
+			return fmt.Errorf("log entry %d: publish without message", e.Index)
+		}
+		b.channel(e.Channel, e.Partitions).replicate(e.Message, b.defaults.Load(), e.Time)
+	case OpRemove:
+		if ch, ok := b.Lookup(e.Channel); ok {
+			ch.removeSeq(e.Seq)
+		}
+	default:
+		return fmt.Errorf("log entry %d: unknown operation %q", e.Index, e.Op)
+	}
+	return nil
+}
+
+// ReplicationStatus describes the replication state of a broker.
+type ReplicationStatus struct {
+	Role         string     `json:"role"` // "primary" or "follower"
+	Index        uint64     `json:"index"`
+	Primary      string     `json:"primary,omitempty"`
+	Applied      uint64     `json:"applied,omitempty"` // Latest primary entry applied
+	PrimaryIndex uint64     `json:"primary_index,omitempty"`
+	LagEntries   uint64     `json:"lag_entries"`
+	LagSeconds   float64    `json:"lag_seconds"`
+	LastContact  *time.Time `json:"last_contact,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// Follower keeps a read-only broker in sync with a primary. It loads a full
+// snapshot of the primary, then tails its replication log, until promoted.
+type Follower struct {
+	Broker  *Broker
+	Primary string // Base URL of the primary, such as http://localhost:8080
+	Client  *http.Client
+	// RetryInterval is waited after a failed sync or tail.
+	RetryInterval time.Duration
+	// PollWait is how long the primary holds a tail request without news.
+	PollWait time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu          sync.Mutex
+	promoted    bool
+	epoch       string // of the primary log being tailed
+	applied     uint64
+	head        uint64
+	appliedAt   time.Time // when the latest applied entry was written
+	lastContact time.Time
+	lastErr     error
+}
+
+func NewFollower(b *Broker, primary string) *Follower {
+	b.SetReadOnly(true)
+	return &Follower{
+		Broker:        b,
+		Primary:       strings.TrimSuffix(primary, "/"),
+		Client:        &http.Client{Timeout: time.Minute},
+		RetryInterval: time.Second,
+		PollWait:      10 * time.Second,
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start follows the primary in the background until ctx is done or the
+// follower is promoted.
+func (f *Follower) Start(ctx context.Context) {
+	f.done = make(chan struct{})
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer close(f.done)
+		defer cancel()
+		go func() {
+			select {
+			case <-f.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		f.run(ctx)
+	}()
+}
+
+func (f *Follower) run(ctx context.Context) {
+	synced := false
+	for ctx.Err() == nil {
+		var err error
+		if !synced {
+			err = f.sync(ctx)
+			synced = err == nil
+		} else if err = f.tail(ctx); errors.Is(err, ErrLogTruncated) {
+			// Fell behind the primary log, start over from a snapshot
+			synced = false
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.lastErr = err
+		f.mu.Unlock()
+		if err != nil {
+			select {
+			case <-time.After(f.RetryInterval):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// Promote stops following the primary and makes the broker writable.
+func (f *Follower) Promote() {
+	f.stopOnce.Do(func() { close(f.stop) })
+	if f.done != nil {
+		<-f.done
+	}
+	f.mu.Lock()
+	f.promoted = true
+	f.mu.Unlock()
+	f.Broker.SetReadOnly(false)
+}
+
+// Promoted reports whether Promote was called.
+func (f *Follower) Promoted() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.promoted
+}
+
+// get sends a GET request to the primary and decodes its JSON response.
+func (f *Follower) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.Primary+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(v)
+	case http.StatusGone:
+		return ErrLogTruncated
+	default:
+		return fmt.Errorf("primary returned %s for %s", resp.Status, path)
+	}
+}
+
+func (f *Follower) sync(ctx context.Context) error {
+	var snap ReplicaSnapshot
+	if err := f.get(ctx, "/replication/snapshot", &snap); err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+	f.Broker.loadReplica(snap)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.epoch, f.applied, f.head = snap.Epoch, snap.Index, snap.Index
+	f.lastContact = time.Now()
+	f.appliedAt = f.lastContact
+	return nil
+}
+
+// LogResponse is a page of the replication log.
+type LogResponse struct {
+	Epoch   string     `json:"epoch"`
+	Head    uint64     `json:"head"`
+	Entries []LogEntry `json:"entries"`
+}
+
+func (f *Follower) tail(ctx context.Context) error {
+	f.mu.Lock()
+	query := url.Values{
+		"epoch": {f.epoch},
+		"after": {strconv.FormatUint(f.applied, 10)},
+		"wait":  {f.PollWait.String()},
+	}
+	f.mu.Unlock()
+
+	var page LogResponse
+	if err := f.get(ctx, "/replication/log?"+query.Encode(), &page); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastContact = time.Now()
+	if page.Epoch != f.epoch {
+		return ErrLogTruncated
+	}
+	f.head = page.Head
+	for _, e := range page.Entries {
+		if err := f.Broker.applyEntry(e); err != nil {
+			return err
+		}
+		f.applied = e.Index
+		f.appliedAt = e.Time
+	}
+	return nil
+}
+
+// Status returns the progress of the follower. The lag in seconds is the age
+// of the latest applied entry while entries remain to apply.
+func (f *Follower) Status() ReplicationStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := ReplicationStatus{
+		Role:         "follower",
+		Primary:      f.Primary,
+		Applied:      f.applied,
+		PrimaryIndex: f.head,
+	}
+	if f.promoted {
+		status.Role = "primary"
+	}
+	if log := f.Broker.log; log != nil {
+		status.Index = log.Head()
+	}
+	if f.head > f.applied {
+		status.LagEntries = f.head - f.applied
+		status.LagSeconds = time.Since(f.appliedAt).Seconds()
+	}
+	if !f.lastContact.IsZero() {
+		contact := f.lastContact
+		status.LastContact = &contact
+	}
+	if f.lastErr != nil {
+		status.LastError = f.lastErr.Error()
+	}
+	return status
+}
+
+// Bounds of a tail request.
+const (
+	maxLogPage = 1000
+	maxLogWait = 30 * time.Second
+)
+
+// ReplicationHandler serves the replication log to followers and the
+// replication state to operators.
+type ReplicationHandler struct {
+	Broker *Broker
+	// Follower is nil on a primary.
+	Follower *Follower
+}
+
+func NewReplicationHandler(b *Broker, f *Follower) *ReplicationHandler {
+	return &ReplicationHandler{Broker: b, Follower: f}
+}
+
+// HandleLog returns the log entries following the after query parameter.
+// With wait, the request is held until an entry arrives or wait elapses.
+// When the epoch query parameter is not the one of the log, the primary
+// restarted since and 410 Gone is returned for the follower to sync again.
+func (h *ReplicationHandler) HandleLog(c *gin.Context) {
+	log := h.Broker.ReplicationLog()
+	if log == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "replication log disabled"})
+		return
+	}
+
+	after, err := strconv.ParseUint(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid after index"})
+		return
+	}
+	if epoch := c.Query("epoch"); (epoch != "" && epoch != log.Epoch()) || after > log.Head() {
+		c.JSON(http.StatusGone, gin.H{"error": ErrLogTruncated.Error()})
+		return
+	}
+	if value := c.Query("wait"); value != "" {
+		wait, err := time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wait duration"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), min(wait, maxLogWait))
+		log.Wait(ctx, after)
+		cancel()
+	}
+
+	entries, head, err := log.Since(after, maxLogPage)
+	if err != nil {
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, LogResponse{Epoch: log.Epoch(), Head: head, Entries: entries})
+}
+
+// HandleSnapshot returns a copy of the channels to start following from.
+func (h *ReplicationHandler) HandleSnapshot(c *gin.Context) {
+	if h.Broker.ReplicationLog() == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "replication log disabled"})
+		return
+	}
+	c.JSON(http.StatusOK, h.Broker.ReplicaSnapshot())
+}
+
+// HandleStatus returns the role of the broker and its replication lag.
+func (h *ReplicationHandler) HandleStatus(c *gin.Context) {
+	if h.Follower != nil {
+		c.JSON(http.StatusOK, h.Follower.Status())
+		return
+	}
+	status := ReplicationStatus{Role: "primary"}
+	if log := h.Broker.ReplicationLog(); log != nil {
+		status.Index = log.Head()
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// HandlePromote turns a follower into a primary accepting pushes and pops.
+func (h *ReplicationHandler) HandlePromote(c *gin.Context) {
+	if h.Follower == nil || h.Follower.Promoted() {
+		c.JSON(http.StatusConflict, gin.H{"error": "broker is already a primary"})
+		return
+	}
+	h.Follower.Promote()
+	c.JSON(http.StatusOK, h.Follower.Status())
+}
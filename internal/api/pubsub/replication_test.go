@@ -0,0 +1,218 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newReplicatedBroker serves a broker with the push, pop and replication
+// routes, as two brokers running on localhost.
+func newReplicatedBroker(t *testing.T, b *Broker, f *Follower) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(replicationRouter(b, f))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func replicationRouter(b *Broker, f *Follower) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	channels := NewChannelsHandler(b, nil)
+	replication := NewReplicationHandler(b, f)
+	r.POST("/push", NewPushHandler(b).HandlePush)
+	r.GET("/channels/:name/pop", channels.HandlePop)
+	r.GET("/replication/log", replication.HandleLog)
+	r.GET("/replication/snapshot", replication.HandleSnapshot)
+	r.GET("/replication/status", replication.HandleStatus)
+	r.POST("/replication/promote", replication.HandlePromote)
+	return r
+}
+
+func push(t *testing.T, srv *httptest.Server, channel, data string) int {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/push", "application/octet-stream", buildFrameData(channel, []byte(data)))
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func pop(t *testing.T, srv *httptest.Server, channel string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/channels/" + channel + "/pop")
+	if err != nil {
+		t.Fatalf("pop failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	return resp.StatusCode, body.String()
+}
+
+// waitFor polls cond until it holds or a few seconds elapsed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func depth(b *Broker, channel string) int {
+	if ch, ok := b.Lookup(channel); ok {
+		return ch.Size()
+	}
+	return -1
+}
+
+func TestReplication(t *testing.T) {
+	primary := NewBroker()
+	primary.EnableReplicationLog(100, 0)
+	primarySrv := newReplicatedBroker(t, primary, nil)
+
+	// Messages published before the follower starts come from the snapshot
+	push(t, primarySrv, "events", "first")
+	push(t, primarySrv, "events", "second")
+
+	follower := NewBroker()
+	follower.EnableReplicationLog(100, 0)
+	f := NewFollower(follower, primarySrv.URL)
+	f.PollWait = 50 * time.Millisecond
+	f.RetryInterval = 10 * time.Millisecond
+	followerSrv := newReplicatedBroker(t, follower, f)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Start(ctx)
+
+	waitFor(t, "snapshot", func() bool { return depth(follower, "events") == 2 })
+
+	// Later changes come from the log
+	push(t, primarySrv, "events", "third")
+	push(t, primarySrv, "jobs", "job")
+	if code, data := pop(t, primarySrv, "events"); code != http.StatusOK || data != "first" {
+		t.Fatalf("pop from primary: %d %q", code, data)
+	}
+	waitFor(t, "log replay", func() bool {
+		return depth(follower, "events") == 2 && depth(follower, "jobs") == 1
+	})
+	waitFor(t, "caught up", func() bool {
+		s := f.Status()
+		return s.Applied == primary.ReplicationLog().Head() && s.LagEntries == 0
+	})
+
+	// The follower is read-only until promoted
+	if code := push(t, followerSrv, "events", "rejected"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected push to follower to fail with 503, got %d", code)
+	}
+	if code, _ := pop(t, followerSrv, "events"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected pop from follower to fail with 503, got %d", code)
+	}
+
+	resp, err := http.Post(followerSrv.URL+"/replication/promote", "", nil)
+	if err != nil {
+		t.Fatalf("promote failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected promote to succeed, got %d", resp.StatusCode)
+	}
+	if s := f.Status(); s.Role != "primary" {
+		t.Errorf("expected role primary after promotion, got %q", s.Role)
+	}
+
+	// The promoted follower continues the sequence where the primary was
+	if code, data := pop(t, followerSrv, "events"); code != http.StatusOK || data != "second" {
+		t.Errorf("pop from promoted follower: %d %q", code, data)
+	}
+	if code := push(t, followerSrv, "events", "fourth"); code != http.StatusCreated {
+		t.Errorf("expected push to promoted follower to succeed, got %d", code)
+	}
+	ch, _ := follower.Lookup("events")
+	if ch.lastSeq != 4 {
+		t.Errorf("expected last sequence 4, got %d", ch.lastSeq)
+	}
+
+	// Further changes of the old primary are not followed anymore
+	push(t, primarySrv, "jobs", "ignored")
+	time.Sleep(20 * time.Millisecond)
+	if depth(follower, "jobs") != 1 {
+		t.Errorf("promoted follower still applies the old primary log")
+	}
+}
+
+func TestReplication_TruncatedLog(t *testing.T) {
+	primary := NewBroker()
+	primary.EnableReplicationLog(2, 0)
+	primarySrv := newReplicatedBroker(t, primary, nil)
+
+	resp, err := http.Get(primarySrv.URL + "/replication/log?after=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected empty log to be readable, got %d", resp.StatusCode)
+	}
+
+	for _, data := range []string{"a", "b", "c"} {
+		push(t, primarySrv, "events", data)
+	}
+	resp, err = http.Get(primarySrv.URL + "/replication/log?after=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("expected status 410 for truncated entries, got %d", resp.StatusCode)
+	}
+}
+
+func TestReplication_PrimaryRestart(t *testing.T) {
+	primary := NewBroker()
+	primary.EnableReplicationLog(100, 0)
+	var router atomic.Pointer[gin.Engine]
+	router.Store(replicationRouter(primary, nil))
+	primarySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.Load().ServeHTTP(w, r)
+	}))
+	t.Cleanup(primarySrv.Close)
+
+	for _, data := range []string{"a", "b", "c"} {
+		push(t, primarySrv, "events", data)
+	}
+	follower := NewBroker()
+	f := NewFollower(follower, primarySrv.URL)
+	f.PollWait = 20 * time.Millisecond
+	f.RetryInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f.Start(ctx)
+	waitFor(t, "sync", func() bool { return f.Status().Applied == 3 })
+
+	// The restarted primary starts a new log, already past the follower index
+	restarted := NewBroker()
+	restarted.EnableReplicationLog(100, 0)
+	for _, data := range []string{"1", "2", "3", "4"} {
+		restarted.Publish(&Frame{ChannelName: "jobs", Data: []byte(data)})
+	}
+	router.Store(replicationRouter(restarted, nil))
+
+	waitFor(t, "resync", func() bool { return depth(follower, "jobs") == 4 })
+	if depth(follower, "events") != -1 {
+		t.Errorf("expected the channels of the previous primary to be dropped")
+	}
+	if s := f.Status(); s.Applied != 4 {
+		t.Errorf("expected index 4 of the new log applied, got %d", s.Applied)
+	}
+}
@@ -0,0 +1,150 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// LogOp is the kind of change recorded in the replication log.
+type LogOp string
+
+const (
+	OpPublish LogOp = "publish" // A message was enqueued
+	OpRemove  LogOp = "remove"  // A message was popped
+)
+
+// LogEntry is one change of a channel, as replayed by followers.
+type LogEntry struct {
+	Index      uint64    `json:"index"`
+	Time       time.Time `json:"time"`
+	Op         LogOp     `json:"op"`
+	Channel    string    `json:"channel"`
+	Partitions int       `json:"partitions,omitempty"` // Partitions of the channel, for publishes
+	Message    *Message  `json:"message,omitempty"`    // Published message
+	Seq        uint64    `json:"seq,omitempty"`        // Sequence of the removed message
+}
+
+// ErrLogTruncated is returned when the entries asked for were already
+// dropped from the log, or belong to another log such as the one of the
+// primary before it restarted. The follower must then sync from a full
+// snapshot.
+var ErrLogTruncated = errors.New("replication log truncated")
+
+// ReplicationLog keeps the latest changes of the broker channels in a ring
+// buffer, so that followers can tail them. Indexes start at 1 and are only
+// meaningful within the epoch of the log, which is drawn anew each time the
+// broker starts.
+type ReplicationLog struct {
+	epoch    string
+	mu       sync.Mutex
+	entries  []LogEntry
+	oldest   uint64        // index of the oldest entry held
+	next     uint64        // index of the next entry
+	bytes    int64         // data of the published messages held
+	maxBytes int64         // 0 when only the number of entries is bounded
+	notify   chan struct{} // closed and replaced on every append
+	now      func() time.Time
+}
+
+// NewReplicationLog returns a log holding at most size entries and, unless
+// maxBytes is 0, at most maxBytes of published message data. The latest
+// entry is always kept.
+func NewReplicationLog(size int, maxBytes int64) *ReplicationLog {
+	var epoch [8]byte
+	rand.Read(epoch[:])
+	return &ReplicationLog{
+		epoch:    hex.EncodeToString(epoch[:]),
+		entries:  make([]LogEntry, max(size, 1)),
+		oldest:   1,
+		next:     1,
+		maxBytes: maxBytes,
+		notify:   make(chan struct{}),
+		now:      time.Now,
+	}
+}
+
+// entrySize returns the bytes of message data e keeps alive.
+func entrySize(e *LogEntry) int64 {
+	if e.Message == nil {
+		return 0
+	}
+	return int64(len(e.Message.Data))
+}
+
+// append records e, dropping the oldest entries once the log is full.
+// It is a no-op on a nil log so that channels without replication skip it.
+func (l *ReplicationLog) append(e LogEntry) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.next-l.oldest == uint64(len(l.entries)) {
+		l.dropOldest()
+	}
+	e.Index = l.next
+	e.Time = l.now()
+	l.entries[e.Index%uint64(len(l.entries))] = e
+	l.bytes += entrySize(&e)
+	l.next++
+	for l.maxBytes > 0 && l.bytes > l.maxBytes && l.oldest < e.Index {
+		l.dropOldest()
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// dropOldest releases the oldest entry. Must be called with mu held.
+func (l *ReplicationLog) dropOldest() {
+	e := &l.entries[l.oldest%uint64(len(l.entries))]
+	l.bytes -= entrySize(e)
+	*e = LogEntry{}
+	l.oldest++
+}
+
+// Epoch identifies this log among the ones the broker had across restarts.
+func (l *ReplicationLog) Epoch() string {
+	return l.epoch
+}
+
+// Head returns the index of the latest entry, 0 when the log is empty.
+func (l *ReplicationLog) Head() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next - 1
+}
+
+// Since returns at most limit entries following index after, along with the
+// head of the log. An index beyond the head comes from another log.
+func (l *ReplicationLog) Since(after uint64, limit int) ([]LogEntry, uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	head := l.next - 1
+	if after > head || after+1 < l.oldest {
+		return nil, head, ErrLogTruncated
+	}
+	n := min(head-after, uint64(limit))
+	entries := make([]LogEntry, 0, n)
+	for i := after + 1; i <= after+n; i++ {
+		entries = append(entries, l.entries[i%uint64(len(l.entries))])
+	}
+	return entries, head, nil
+}
+
+// Wait blocks until an entry follows index after or ctx is done.
+func (l *ReplicationLog) Wait(ctx context.Context, after uint64) {
+	l.mu.Lock()
+	notify, ready := l.notify, l.next-1 > after
+	l.mu.Unlock()
+	if ready {
+		return
+	}
+	select {
+	case <-notify:
+	case <-ctx.Done():
+	}
+}
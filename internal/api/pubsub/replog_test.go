@@ -0,0 +1,92 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReplicationLog_Since(t *testing.T) {
+	l := NewReplicationLog(3, 0)
+	if entries, head, err := l.Since(0, 10); err != nil || head != 0 || len(entries) != 0 {
+		t.Fatalf("empty log: got %d entries, head %d, err %v", len(entries), head, err)
+	}
+
+	for seq := uint64(1); seq <= 5; seq++ {
+		l.append(LogEntry{Op: OpRemove, Channel: "events", Seq: seq})
+	}
+
+	entries, head, err := l.Since(2, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if head != 5 || len(entries) != 3 {
+		t.Fatalf("expected 3 entries up to 5, got %d up to %d", len(entries), head)
+	}
+	for i, e := range entries {
+		if e.Index != uint64(i+3) || e.Seq != e.Index {
+			t.Errorf("entry %d: index %d, seq %d", i, e.Index, e.Seq)
+		}
+	}
+
+	if entries, _, _ := l.Since(2, 1); len(entries) != 1 || entries[0].Index != 3 {
+		t.Errorf("expected the page to be limited to entry 3, got %v", entries)
+	}
+	if _, _, err := l.Since(1, 10); err != ErrLogTruncated {
+		t.Errorf("error = %v, want %v", err, ErrLogTruncated)
+	}
+	// An index beyond the head was read from another log
+	if _, _, err := l.Since(6, 10); err != ErrLogTruncated {
+		t.Errorf("error = %v, want %v", err, ErrLogTruncated)
+	}
+}
+
+func TestReplicationLog_Wait(t *testing.T) {
+	l := NewReplicationLog(10, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	l.Wait(ctx, 0)
+	if ctx.Err() == nil {
+		t.Fatal("Wait returned before any entry")
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		l.append(LogEntry{Op: OpRemove, Channel: "events", Seq: 1})
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l.Wait(ctx, 0)
+	if ctx.Err() != nil || l.Head() != 1 {
+		t.Errorf("Wait did not return on append")
+	}
+}
+
+func TestReplicationLog_MaxBytes(t *testing.T) {
+	l := NewReplicationLog(100, 10)
+	for seq := uint64(1); seq <= 4; seq++ {
+		l.append(LogEntry{Op: OpPublish, Channel: "events", Message: &Message{Seq: seq, Data: []byte("abcd")}})
+	}
+	l.append(LogEntry{Op: OpRemove, Channel: "events", Seq: 1})
+
+	entries, head, err := l.Since(2, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if head != 5 || len(entries) != 3 || entries[0].Message.Seq != 3 {
+		t.Fatalf("expected entries 3 to 5, got %d up to %d", len(entries), head)
+	}
+	if l.bytes != 8 {
+		t.Errorf("bytes = %d, want 8", l.bytes)
+	}
+	if _, _, err := l.Since(1, 10); err != ErrLogTruncated {
+		t.Errorf("error = %v, want %v", err, ErrLogTruncated)
+	}
+
+	// The latest entry is kept even when larger than the bound
+	l.append(LogEntry{Op: OpPublish, Channel: "events", Message: &Message{Seq: 5, Data: make([]byte, 20)}})
+	if entries, _, err := l.Since(5, 10); err != nil || len(entries) != 1 || entries[0].Index != 6 {
+		t.Errorf("expected only entry 6, got %v, %v", entries, err)
+	}
+}
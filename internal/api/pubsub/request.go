@@ -0,0 +1,153 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds of the timeout query parameter of a request.
+const (
+	DefaultRequestTimeout = 30 * time.Second
+	MaxRequestTimeout     = 5 * time.Minute
+)
+
+// RequestHandler publishes requests and blocks until their reply arrives.
+// Requests share the limits, rate limiter and drain state of a PushHandler.
+type RequestHandler struct {
+	Broker *Broker
+	Push   *PushHandler
+}
+
+func NewRequestHandler(b *Broker, push *PushHandler) *RequestHandler {
+	return &RequestHandler{Broker: b, Push: push}
+}
+
+// HandleRequest publishes the raw request body to the channel named in the
+// URL, with a reply channel and a correlation ID, then returns the first
+// reply carrying that correlation ID. Responders pop the request and push
+// their reply to the X-Reply-To channel with the same X-Correlation-ID.
+func (h *RequestHandler) HandleRequest(c *gin.Context) {
+	if h.Push.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "broker is shutting down"})
+		return
+	}
+
+	timeout, err := requestTimeout(c.Query("timeout"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel := c.Param("channel")
+	limits := h.Push.limits.Load()
+	if channel == "" || len(channel) > int(limits.MaxChannelLen) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrChannelTooLarge.Error()})
+		return
+	}
+	if IsReplyChannel(channel) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot send a request to a reply channel"})
+		return
+	}
+	if !h.Push.allow(c, channel) {
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(limits.MaxData)+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read message data"})
+		return
+	}
+	if len(data) > int(limits.MaxData) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": ErrDataTooLarge.Error()})
+		return
+	}
+
+	encoding, decodedLen, ok := frameEncoding(c, data, limits.MaxData)
+	if !ok {
+		return
+	}
+
+	// The reply channel must exist before the request can be popped
+	replies := h.Broker.acquireReplyChannel(newReplyChannelName())
+	defer h.Broker.releaseReplyChannel(replies)
+
+	correlationID := newCorrelationID()
+	frame := Frame{
+		ChannelName:   channel,
+		DataLen:       uint32(len(data)),
+		Data:          data,
+		MessageID:     c.GetHeader(MessageIDHeader),
+		Key:           c.GetHeader(PartitionKeyHeader),
+		ReplyTo:       replies.Name,
+		CorrelationID: correlationID,
+		Encoding:      encoding,
+		DecodedLen:    decodedLen,
+	}
+	if _, err := h.Broker.Publish(&frame); err != nil {
+		publishError(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	reply, err := h.Broker.WaitFor(ctx, replies, func(msg *Message) bool {
+		return msg.CorrelationID == correlationID
+	})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.Header(CorrelationIDHeader, correlationID)
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "no reply before timeout"})
+		}
+		// Otherwise the client went away and nobody reads the response
+		return
+	}
+
+	c.Header(CorrelationIDHeader, correlationID)
+	writeMessageData(c, http.StatusOK, reply)
+}
+
+// requestTimeout parses the timeout query parameter, either a duration such
+// as "1m30s" or a number of seconds.
+func requestTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return DefaultRequestTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.ParseFloat(value, 64)
+		if convErr != nil {
+			return 0, errors.New("invalid timeout, expected a duration such as 10s")
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, errors.New("timeout must be positive")
+	}
+	return min(timeout, MaxRequestTimeout), nil
+}
+
+// ReplyChannelNameLen is the length of every reply channel name, which the
+// channel name limit must allow for replies to be pushed.
+const ReplyChannelNameLen = len(ReplyChannelPrefix) + 32
+
+// newReplyChannelName returns a random reply channel name, so that only the
+// responders given the name can reply to a request.
+func newReplyChannelName() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return ReplyChannelPrefix + hex.EncodeToString(b[:])
+}
+
+func newCorrelationID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
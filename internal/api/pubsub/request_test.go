@@ -0,0 +1,217 @@
+package pubsub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newRequestRouter(b *Broker) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	push := NewPushHandler(b)
+	r := gin.New()
+	r.POST("/push", push.HandlePush)
+	r.POST("/request/:channel", NewRequestHandler(b, push).HandleRequest)
+	return r
+}
+
+// sendRequest runs a request in the background and returns its response
+// once it completes.
+func sendRequest(r *gin.Engine, url, body string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		done <- w
+	}()
+	return done
+}
+
+// popRequest waits for a request to be published to channel and pops it.
+func popRequest(t *testing.T, b *Broker, channel string) *Message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if ch, ok := b.Lookup(channel); ok {
+			if msg, ok := b.Pop(ch, ""); ok {
+				return msg
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("no request published to %s", channel)
+	return nil
+}
+
+func pushReply(r *gin.Engine, replyTo, correlationID, data string) int {
+	req := httptest.NewRequest(http.MethodPost, "/push", buildFrameData(replyTo, []byte(data)))
+	req.Header.Set(CorrelationIDHeader, correlationID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestHandleRequestReply(t *testing.T) {
+	b := NewBroker()
+	r := newRequestRouter(b)
+
+	done := sendRequest(r, "/request/jobs?timeout=5s", "2+2")
+	msg := popRequest(t, b, "jobs")
+	if string(msg.Data) != "2+2" {
+		t.Errorf("expected request data 2+2, got %q", msg.Data)
+	}
+	if !IsReplyChannel(msg.ReplyTo) || strings.Contains(msg.ReplyTo, "worker-1") || msg.CorrelationID == "" {
+		t.Fatalf("unexpected reply routing: %q %q", msg.ReplyTo, msg.CorrelationID)
+	}
+
+	// A reply to another request is not returned
+	if code := pushReply(r, msg.ReplyTo, "other", "5"); code != http.StatusCreated {
+		t.Fatalf("expected status 201 for the other reply, got %d", code)
+	}
+	if code := pushReply(r, msg.ReplyTo, msg.CorrelationID, "4"); code != http.StatusCreated {
+		t.Fatalf("expected status 201 for the reply, got %d", code)
+	}
+
+	w := <-done
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "4" {
+		t.Errorf("expected reply 4, got %q", w.Body.String())
+	}
+	if got := w.Header().Get(CorrelationIDHeader); got != msg.CorrelationID {
+		t.Errorf("expected correlation ID %q, got %q", msg.CorrelationID, got)
+	}
+
+	// The reply channel is dropped with the unclaimed reply
+	if _, ok := b.Lookup(msg.ReplyTo); ok {
+		t.Errorf("reply channel should be removed after the request")
+	}
+}
+
+func TestHandleRequestReply_ChannelLimit(t *testing.T) {
+	b := NewBroker()
+	limits := DefaultLimits()
+	limits.MaxChannelLen = uint8(ReplyChannelNameLen)
+	b.SetLimits(limits)
+	gin.SetMode(gin.TestMode)
+	push := NewPushHandler(b)
+	push.SetLimits(limits)
+	r := gin.New()
+	r.POST("/push", push.HandlePush)
+	r.POST("/request/:channel", NewRequestHandler(b, push).HandleRequest)
+
+	// The smallest valid channel name limit still fits the reply channels
+	done := sendRequest(r, "/request/jobs?timeout=5s", "ping")
+	msg := popRequest(t, b, "jobs")
+	if len(msg.ReplyTo) != ReplyChannelNameLen {
+		t.Errorf("expected a reply channel name of %d bytes, got %q", ReplyChannelNameLen, msg.ReplyTo)
+	}
+	if code := pushReply(r, msg.ReplyTo, msg.CorrelationID, "pong"); code != http.StatusCreated {
+		t.Fatalf("expected status 201 for the reply, got %d", code)
+	}
+	if w := <-done; w.Code != http.StatusOK || w.Body.String() != "pong" {
+		t.Errorf("expected reply pong, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleRequestTimeout(t *testing.T) {
+	b := NewBroker()
+	r := newRequestRouter(b)
+
+	done := sendRequest(r, "/request/jobs?timeout=20ms", "slow")
+	msg := popRequest(t, b, "jobs")
+
+	w := <-done
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status 504, got %d", w.Code)
+	}
+
+	// A late reply does not recreate the reply channel
+	if code := pushReply(r, msg.ReplyTo, msg.CorrelationID, "late"); code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a late reply, got %d", code)
+	}
+	if _, ok := b.Lookup(msg.ReplyTo); ok {
+		t.Errorf("late reply should not recreate the reply channel")
+	}
+}
+
+func TestHandleRequestInvalid(t *testing.T) {
+	r := newRequestRouter(NewBroker())
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"invalid timeout", "/request/jobs?timeout=soon"},
+		{"negative timeout", "/request/jobs?timeout=-1"},
+		{"reply channel", "/request/" + ReplyChannelPrefix + "worker-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := <-sendRequest(r, tt.url, "data")
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", DefaultRequestTimeout},
+		{"1500ms", 1500 * time.Millisecond},
+		{"2", 2 * time.Second},
+		{"1h", MaxRequestTimeout},
+	}
+	for _, tt := range tests {
+		got, err := requestTimeout(tt.value)
+		if err != nil {
+			t.Fatalf("requestTimeout(%q): %v", tt.value, err)
+		}
+		if got != tt.want {
+			t.Errorf("requestTimeout(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestReplyChannelsHidden(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b := NewBroker()
+	b.EnableReplicationLog(16, 0)
+	replies := b.acquireReplyChannel(newReplyChannelName())
+	defer b.releaseReplyChannel(replies)
+	if _, err := b.Publish(&Frame{ChannelName: replies.Name, Data: []byte("4")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.log.Head() == 0 {
+		t.Error("expected the reply to be in the replication log")
+	}
+
+	handler := NewChannelsHandler(b, nil)
+	r := gin.New()
+	r.GET("/channels", handler.HandleList)
+	r.GET("/channels/:name/pop", handler.HandlePop)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/channels/"+replies.Name+"/pop", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 popping a reply channel, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/channels", nil))
+	if strings.Contains(w.Body.String(), replies.Name) {
+		t.Errorf("expected reply channel to be hidden, got %s", w.Body.String())
+	}
+	if replies.Size() != 1 {
+		t.Errorf("expected the reply to stay queued, got %d", replies.Size())
+	}
+}
@@ -0,0 +1,233 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/forgeronvirtuel/lab-golang/internal/largedataset"
+)
+
+// SchemaSpec describes the CSV rows accepted by a channel, in config files
+// and in the schema endpoint. Either a preset or columns are given.
+type SchemaSpec struct {
+	Preset        string       `json:"preset,omitempty" yaml:"preset" toml:"preset"` // "stock" for the generated stock data
+	Separator     string       `json:"separator,omitempty" yaml:"separator" toml:"separator"`
+	MinColumns    int          `json:"min_columns,omitempty" yaml:"min_columns" toml:"min_columns"`
+	StrictColumns bool         `json:"strict_columns,omitempty" yaml:"strict_columns" toml:"strict_columns"`
+	Columns       []ColumnSpec `json:"columns,omitempty" yaml:"columns" toml:"columns"`
+}
+
+// ColumnSpec is the serializable form of a largedataset.ColumnDef.
+type ColumnSpec struct {
+	Index         int      `json:"index" yaml:"index" toml:"index"`
+	Name          string   `json:"name" yaml:"name" toml:"name"`
+	Type          string   `json:"type" yaml:"type" toml:"type"` // string, int, float, bool, date, datetime, email or regex
+	Required      bool     `json:"required,omitempty" yaml:"required" toml:"required"`
+	MinLength     int      `json:"min_length,omitempty" yaml:"min_length" toml:"min_length"`
+	MaxLength     int      `json:"max_length,omitempty" yaml:"max_length" toml:"max_length"`
+	Min           *float64 `json:"min,omitempty" yaml:"min" toml:"min"`
+	Max           *float64 `json:"max,omitempty" yaml:"max" toml:"max"`
+	Pattern       string   `json:"pattern,omitempty" yaml:"pattern" toml:"pattern"`
+	DateFormat    string   `json:"date_format,omitempty" yaml:"date_format" toml:"date_format"`
+	AllowedValues []string `json:"allowed_values,omitempty" yaml:"allowed_values" toml:"allowed_values"`
+}
+
+// ChannelSchema validates the payloads published to a channel. Payloads are
+// CSV rows, without header, each checked with CSVSchema.ValidateRecord.
+type ChannelSchema struct {
+	Spec      SchemaSpec
+	CSV       *largedataset.CSVSchema
+	Separator rune
+}
+
+// Build checks the spec and returns the schema it describes.
+func (s SchemaSpec) Build() (*ChannelSchema, error) {
+	schema := &ChannelSchema{Spec: s, Separator: ','}
+	if s.Separator != "" {
+		r, size := utf8.DecodeRuneInString(s.Separator)
+		if size != len(s.Separator) || r == '"' || r == '\n' {
+			return nil, fmt.Errorf("invalid separator %q", s.Separator)
+		}
+		schema.Separator = r
+	}
+
+	switch {
+	case s.Preset == "stock" && len(s.Columns) == 0:
+		schema.CSV = largedataset.NewStockDataSchema()
+		return schema, nil
+	case s.Preset != "":
+		return nil, fmt.Errorf("unknown preset %q (expected stock, without columns)", s.Preset)
+	case len(s.Columns) == 0:
+		return nil, errors.New("schema needs a preset or columns")
+	}
+
+	schema.CSV = &largedataset.CSVSchema{MinColumns: s.MinColumns, StrictColumns: s.StrictColumns}
+	for _, col := range s.Columns {
+		t, err := largedataset.ParseColumnType(col.Type)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", col.Name, err)
+		}
+		if col.Index < 0 {
+			return nil, fmt.Errorf("column %q: negative index", col.Name)
+		}
+		if t == largedataset.TypeRegex && col.Pattern == "" {
+			return nil, fmt.Errorf("column %q: regex pattern not specified", col.Name)
+		}
+		var re *regexp.Regexp
+		if col.Pattern != "" {
+			if re, err = regexp.Compile(col.Pattern); err != nil {
+				return nil, fmt.Errorf("column %q: invalid pattern: %w", col.Name, err)
+			}
+		}
+		schema.CSV.Columns = append(schema.CSV.Columns, largedataset.ColumnDef{
+			Index:       col.Index,
+			Name:        col.Name,
+			Type:        t,
+			Required:    col.Required,
+			MinLength:   col.MinLength,
+			MaxLength:   col.MaxLength,
+			Min:         col.Min,
+			Max:         col.Max,
+			Pattern:     col.Pattern,
+			Regex:       re,
+			DateFormat:  col.DateFormat,
+			AllowedVals: col.AllowedValues,
+		})
+	}
+	return schema, nil
+}
+
+// Header returns the column names of the schema, indexed by column position.
+// Positions without a column definition have an empty name.
+func (s *ChannelSchema) Header() []string {
+	size := 0
+	for _, col := range s.CSV.Columns {
+		size = max(size, col.Index+1)
+	}
+	header := make([]string, size)
+	for _, col := range s.CSV.Columns {
+		header[col.Index] = col.Name
+	}
+	return header
+}
+
+// SchemaError reports the first invalid row of a payload. Row starts at 1.
+type SchemaError struct {
+	Row int
+	Err error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks every CSV row of data against the schema.
+func (s *ChannelSchema) Validate(data []byte) error {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = s.Separator
+	r.FieldsPerRecord = -1
+	rows := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		rows++
+		if err != nil {
+			return &SchemaError{Row: rows, Err: err}
+		}
+		if err := s.CSV.ValidateRecord(record); err != nil {
+			return &SchemaError{Row: rows, Err: err}
+		}
+	}
+	if rows == 0 {
+		return &SchemaError{Row: 1, Err: errors.New("expected at least one CSV row")}
+	}
+	return nil
+}
+
+// SetSchema makes channel reject the publishes failing schema, or accept any
+// payload again when schema is nil. The schema of channel is not changed by
+// ConfigureSchemas anymore.
+func (b *Broker) SetSchema(channel string, schema *ChannelSchema) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.schemaOverrides[channel] = true
+	if schema == nil {
+		delete(b.schemas, channel)
+		return
+	}
+	b.schemas[channel] = schema
+}
+
+// ConfigureSchemas replaces the schemas of the configuration, keyed by
+// channel. Channels which are not in specs anymore lose the schema the
+// previous configuration gave them, and the ones set through SetSchema keep
+// theirs. Nothing changes if a spec is invalid.
+func (b *Broker) ConfigureSchemas(specs map[string]SchemaSpec) error {
+	schemas := make(map[string]*ChannelSchema, len(specs))
+	for channel, spec := range specs {
+		schema, err := spec.Build()
+		if err != nil {
+			return fmt.Errorf("schema of %s: %w", channel, err)
+		}
+		schemas[channel] = schema
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for channel := range b.configSchemas {
+		if _, ok := schemas[channel]; !ok && !b.schemaOverrides[channel] {
+			delete(b.schemas, channel)
+		}
+	}
+	b.configSchemas = make(map[string]bool, len(schemas))
+	for channel, schema := range schemas {
+		b.configSchemas[channel] = true
+		if !b.schemaOverrides[channel] {
+			b.schemas[channel] = schema
+		}
+	}
+	return nil
+}
+
+// Schema returns the schema of channel, nil if it has none.
+func (b *Broker) Schema(channel string) *ChannelSchema {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.schemas[channel]
+}
+
+// validate checks a frame published to ch against the channel schema.
+func (b *Broker) validate(ch *Channel, frame *Frame) error {
+	schema := b.Schema(ch.Name)
+	if schema == nil {
+		return nil
+	}
+	data := frame.Data
+	if frame.Encoding != "" {
+		// The length checked on push bounds the data, or the data limit
+		limit := int64(b.limits.Load().MaxData)
+		if frame.DecodedLen > 0 {
+			limit = int64(frame.DecodedLen)
+		}
+		var err error
+		if data, err = Decompress(frame.Encoding, frame.Data, limit); err != nil {
+			return err
+		}
+	}
+	if err := schema.Validate(data); err != nil {
+		ch.Stats.Rejected.Add(1)
+		return err
+	}
+	return nil
+}
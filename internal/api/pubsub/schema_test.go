@@ -0,0 +1,223 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/forgeronvirtuel/lab-golang/internal/largedataset"
+	"github.com/gin-gonic/gin"
+)
+
+const validTrade = "1,2024-01-02 09:30:00,AAPL,NASDAQ,Technology,Buy,Limit,100,185.5,18550,184,186,187,183,1000000,2.9e12,29.5,0.5,1.2,199.6,164.1,0.8"
+
+func TestSchemaSpec_Build(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    SchemaSpec
+		wantErr string
+	}{
+		{"preset", SchemaSpec{Preset: "stock"}, ""},
+		{"columns", SchemaSpec{Separator: ";", Columns: []ColumnSpec{{Index: 0, Name: "Age", Type: "int"}}}, ""},
+		{"unknown preset", SchemaSpec{Preset: "bonds"}, "unknown preset"},
+		{"empty", SchemaSpec{}, "preset or columns"},
+		{"unknown type", SchemaSpec{Columns: []ColumnSpec{{Name: "Age", Type: "decimal"}}}, "unknown column type"},
+		{"long separator", SchemaSpec{Separator: ";;", Columns: []ColumnSpec{{Type: "int"}}}, "invalid separator"},
+		{"regex", SchemaSpec{Columns: []ColumnSpec{{Name: "Code", Type: "regex", Pattern: "^[A-Z]+$"}}}, ""},
+		{"regex without pattern", SchemaSpec{Columns: []ColumnSpec{{Name: "Code", Type: "regex"}}}, "pattern not specified"},
+		{"invalid pattern", SchemaSpec{Columns: []ColumnSpec{{Name: "Code", Type: "regex", Pattern: "("}}}, "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.spec.Build()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want mention of %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBroker_ConfigureSchemas(t *testing.T) {
+	b := NewBroker()
+	intSchema := SchemaSpec{Columns: []ColumnSpec{{Index: 0, Name: "Age", Type: "int"}}}
+	if err := b.ConfigureSchemas(map[string]SchemaSpec{"users": intSchema, "trades": {Preset: "stock"}, "orders": {Preset: "stock"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	api, _ := SchemaSpec{Columns: []ColumnSpec{{Index: 0, Name: "Name", Type: "string"}}}.Build()
+	b.SetSchema("users", api)
+	b.SetSchema("orders", nil)
+
+	// Reloading keeps the API changes and drops the schemas removed from the file
+	if err := b.ConfigureSchemas(map[string]SchemaSpec{"users": intSchema, "orders": {Preset: "stock"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Schema("users") != api {
+		t.Error("expected the schema set through the API to survive the reload")
+	}
+	if b.Schema("orders") != nil {
+		t.Error("expected the schema removed through the API to stay removed")
+	}
+	if b.Schema("trades") != nil {
+		t.Error("expected the schema removed from the configuration to be dropped")
+	}
+
+	// An invalid configuration changes nothing
+	err := b.ConfigureSchemas(map[string]SchemaSpec{"logs": {Preset: "stock"}, "bad": {Preset: "bonds"}})
+	if err == nil || !strings.Contains(err.Error(), "unknown preset") {
+		t.Errorf("error = %v, want unknown preset", err)
+	}
+	if b.Schema("logs") != nil || b.Schema("users") != api {
+		t.Error("expected an invalid configuration to leave the schemas untouched")
+	}
+}
+
+func TestChannelSchema_Validate(t *testing.T) {
+	min := 18.0
+	schema, err := SchemaSpec{
+		Separator: ";",
+		Columns: []ColumnSpec{
+			{Index: 0, Name: "Email", Type: "email", Required: true},
+			{Index: 1, Name: "Age", Type: "int", Min: &min},
+		},
+	}.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantRow int
+	}{
+		{"valid rows", "a@example.com;20\nb@example.com;\n", 0},
+		{"invalid second row", "a@example.com;20\nb@example.com;12\n", 2},
+		{"missing column", "not an email;30", 1},
+		{"empty payload", "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate([]byte(tt.data))
+			if tt.wantRow == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var schemaErr *SchemaError
+			if !errors.As(err, &schemaErr) || schemaErr.Row != tt.wantRow {
+				t.Errorf("error = %v, want row %d", err, tt.wantRow)
+			}
+		})
+	}
+}
+
+func TestHandlePush_Schema(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	b := NewBroker()
+	schema, _ := SchemaSpec{Preset: "stock"}.Build()
+	b.SetSchema("trades", schema)
+	r := gin.New()
+	r.POST("/push", NewPushHandler(b).HandlePush)
+
+	push := func(data []byte, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/push", buildFrameData("trades", data))
+		req.Header.Set(EncodingHeader, encoding)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := push([]byte(validTrade), ""); w.Code != http.StatusCreated {
+		t.Fatalf("expected valid trade to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+	compressed, _ := Compress(EncodingGzip, []byte(validTrade))
+	if w := push(compressed, EncodingGzip); w.Code != http.StatusCreated {
+		t.Fatalf("expected compressed valid trade to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+
+	negativePrice := strings.Replace(validTrade, ",185.5,", ",-3,", 1)
+	w := push([]byte(validTrade+"\n"+negativePrice), "")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+	var resp struct {
+		Row        int    `json:"row"`
+		Column     int    `json:"column"`
+		ColumnName string `json:"column_name"`
+		Value      string `json:"value"`
+		Expected   string `json:"expected"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Row != 2 || resp.Column != 8 || resp.ColumnName != "Price" || resp.Value != "-3" || resp.Expected == "" {
+		t.Errorf("unexpected error details: %s", w.Body.String())
+	}
+
+	ch, _ := b.Lookup("trades")
+	if ch.Size() != 2 || ch.Stats.Rejected.Load() != 1 {
+		t.Errorf("expected 2 queued and 1 rejected, got %d and %d", ch.Size(), ch.Stats.Rejected.Load())
+	}
+
+	// Other channels are not validated
+	if _, err := b.Publish(&Frame{ChannelName: "events", Data: []byte("anything")}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	var validationErr *largedataset.ValidationError
+	if _, err := b.Publish(&Frame{ChannelName: "trades", Data: []byte(negativePrice)}); !errors.As(err, &validationErr) {
+		t.Errorf("error = %v, want a validation error", err)
+	}
+	// Decompression stops at the length checked on push, or the data limit
+	if _, err := b.Publish(&Frame{ChannelName: "trades", Data: compressed, Encoding: EncodingGzip, DecodedLen: 10}); !errors.Is(err, ErrDecodedTooLarge) {
+		t.Errorf("error = %v, want ErrDecodedTooLarge beyond the decoded length", err)
+	}
+	limits := DefaultLimits()
+	limits.MaxData = 10
+	b.SetLimits(limits)
+	if _, err := b.Publish(&Frame{ChannelName: "trades", Data: compressed, Encoding: EncodingGzip}); !errors.Is(err, ErrDecodedTooLarge) {
+		t.Errorf("error = %v, want ErrDecodedTooLarge beyond the data limit", err)
+	}
+}
+
+func TestChannelsHandler_Schema(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b := NewBroker()
+	h := NewChannelsHandler(b, nil)
+	r := gin.New()
+	r.GET("/channels/:name/schema", h.HandleGetSchema)
+	r.PUT("/channels/:name/schema", h.HandleSetSchema)
+	r.DELETE("/channels/:name/schema", h.HandleDeleteSchema)
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, "/channels/users/schema", strings.NewReader(body)))
+		return w
+	}
+
+	if w := serve("GET", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without schema, got %d", w.Code)
+	}
+	if w := serve("PUT", `{"columns":[{"index":0,"name":"Age","type":"money"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid schema, got %d", w.Code)
+	}
+	if w := serve("PUT", `{"columns":[{"index":0,"name":"Age","type":"int","required":true}]}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d %s", w.Code, w.Body.String())
+	}
+	if _, err := b.Publish(&Frame{ChannelName: "users", Data: []byte("old")}); err == nil {
+		t.Error("expected the schema to apply to publishes")
+	}
+	if w := serve("GET", ""); !strings.Contains(w.Body.String(), `"type":"int"`) {
+		t.Errorf("unexpected schema: %s", w.Body.String())
+	}
+	if w := serve("DELETE", ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	if _, err := b.Publish(&Frame{ChannelName: "users", Data: []byte("old")}); err != nil {
+		t.Errorf("unexpected error after removing the schema: %v", err)
+	}
+}
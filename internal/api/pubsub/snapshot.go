@@ -0,0 +1,158 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// Snapshots start with snapshotMagic and a version byte, so that the format
+// can change without older snapshots being misread.
+const (
+	snapshotMagic   = "PSNAP"
+	snapshotVersion = 1
+)
+
+// snapshotRecord is one message of a snapshot, queued or only retained.
+type snapshotRecord struct {
+	Channel string
+	Message Message
+	Popped  bool
+}
+
+// WriteSnapshot writes the messages of every channel but the reply ones into
+// w as a gob stream of records after the header, in channel name order and
+// FIFO order within each partition, followed by the retained messages popped
+// already. The messages are left queued: SaveSnapshotFile only drops them
+// once the snapshot is safely on disk. Only queued messages are counted.
+func (b *Broker) WriteSnapshot(w io.Writer) (int, error) {
+	count, _, err := b.writeSnapshot(w)
+	return count, err
+}
+
+// writeSnapshot is WriteSnapshot, also returning the largest sequence written
+// for each partition of each channel.
+func (b *Broker) writeSnapshot(w io.Writer) (int, map[*Channel][]uint64, error) {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+	enc := gob.NewEncoder(bw)
+	count := 0
+	written := make(map[*Channel][]uint64)
+	for _, ch := range b.Channels() {
+		// Requesters do not survive a restart, neither do their replies
+		if IsReplyChannel(ch.Name) {
+			continue
+		}
+		last := make([]uint64, len(ch.Partitions))
+		queued := make(map[uint64]bool)
+		for i, q := range ch.Partitions {
+			for _, msg := range q.Values() {
+				if err := enc.Encode(snapshotRecord{Channel: ch.Name, Message: *msg}); err != nil {
+					return count, nil, fmt.Errorf("failed to write channel %q: %w", ch.Name, err)
+				}
+				last[i] = max(last[i], msg.Seq)
+				queued[msg.Seq] = true
+				count++
+			}
+		}
+		written[ch] = last
+
+		ch.mu.Lock()
+		retained := slices.Clone(ch.retained)
+		ch.mu.Unlock()
+		for _, msg := range retained {
+			if queued[msg.Seq] {
+				continue
+			}
+			if err := enc.Encode(snapshotRecord{Channel: ch.Name, Message: *msg, Popped: true}); err != nil {
+				return count, nil, fmt.Errorf("failed to write channel %q: %w", ch.Name, err)
+			}
+		}
+	}
+	return count, written, bw.Flush()
+}
+
+// LoadSnapshot enqueues the messages read from r into their channels.
+func (b *Broker) LoadSnapshot(r io.Reader) (int, error) {
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, []byte(snapshotMagic)) {
+		return 0, errors.New("not a broker snapshot")
+	}
+	if version := header[len(snapshotMagic)]; version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	return b.loadRecords(bufio.NewReader(r))
+}
+
+// loadRecords restores a gob stream of records.
+func (b *Broker) loadRecords(r io.Reader) (int, error) {
+	dec := gob.NewDecoder(r)
+	count := 0
+	for {
+		var rec snapshotRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return count, nil
+			}
+			return count, fmt.Errorf("failed to read message %d: %w", count+1, err)
+		}
+		b.Channel(rec.Channel).restore(&rec.Message, !rec.Popped, b.defaults.Load(), b.now())
+		if !rec.Popped {
+			count++
+		}
+	}
+}
+
+// SaveSnapshotFile writes the snapshot to path atomically, through a
+// temporary file renamed once complete, then drops the messages it holds
+// from their channels. On error, every message is still queued.
+func (b *Broker) SaveSnapshotFile(path string) (int, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	count, written, err := b.writeSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return count, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return count, err
+	}
+	for ch, last := range written {
+		ch.dropSnapshotted(last)
+	}
+	return count, nil
+}
+
+// LoadSnapshotFile restores the snapshot stored at path, if any. The file is
+// kept as the only durable copy of the messages until SaveSnapshotFile
+// replaces it: after a crash, the messages are restored again rather than
+// lost, including those popped in the meantime.
+func (b *Broker) LoadSnapshotFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	return b.LoadSnapshot(f)
+}
@@ -0,0 +1,238 @@
+package pubsub
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	b := NewBroker()
+	b.Enqueue(&Frame{ChannelName: "events", Data: []byte("first")})
+	b.Enqueue(&Frame{ChannelName: "trades", Data: []byte("AAPL,100")})
+	b.Enqueue(&Frame{ChannelName: "events", Data: []byte("second")})
+	b.Enqueue(&Frame{ChannelName: "events", Data: []byte{}})
+
+	buf := new(bytes.Buffer)
+	written, err := b.WriteSnapshot(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written != 4 {
+		t.Errorf("expected 4 messages written, got %d", written)
+	}
+
+	restored := NewBroker()
+	loaded, err := restored.LoadSnapshot(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded != 4 {
+		t.Errorf("expected 4 messages loaded, got %d", loaded)
+	}
+
+	events, _ := restored.Lookup("events")
+	for _, want := range []string{"first", "second", ""} {
+		msg, ok := events.Partitions[0].Dequeue()
+		if !ok || string(msg.Data) != want {
+			t.Errorf("expected %q, got %+v (ok=%v)", want, msg, ok)
+		}
+	}
+	trades, _ := restored.Lookup("trades")
+	if msg, _ := trades.Partitions[0].Dequeue(); string(msg.Data) != "AAPL,100" {
+		t.Errorf("expected 'AAPL,100', got %q", msg.Data)
+	}
+}
+
+func TestSnapshot_Truncated(t *testing.T) {
+	b := NewBroker()
+	b.Enqueue(&Frame{ChannelName: "events", Data: []byte("payload")})
+	buf := new(bytes.Buffer)
+	b.WriteSnapshot(buf)
+	truncated := buf.Bytes()[:buf.Len()-2]
+
+	if _, err := NewBroker().LoadSnapshot(bytes.NewReader(truncated)); err == nil {
+		t.Error("expected error for truncated snapshot")
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broker.snapshot")
+
+	// A missing snapshot is not an error
+	if n, err := NewBroker().LoadSnapshotFile(path); err != nil || n != 0 {
+		t.Fatalf("expected nothing loaded, got %d, %v", n, err)
+	}
+
+	b := NewBroker()
+	b.Enqueue(&Frame{ChannelName: "events", Data: []byte("kept")})
+	if _, err := b.SaveSnapshotFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if events, _ := b.Lookup("events"); events.Size() != 0 {
+		t.Errorf("expected saved messages to be dropped, %d left", events.Size())
+	}
+
+	restored := NewBroker()
+	if n, err := restored.LoadSnapshotFile(path); err != nil || n != 1 {
+		t.Fatalf("expected 1 message loaded, got %d, %v", n, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected snapshot to be kept once loaded: %v", err)
+	}
+
+	// Saving again replaces the snapshot with the messages still queued
+	events, _ := restored.Lookup("events")
+	if _, ok := restored.Pop(events, ""); !ok {
+		t.Fatal("expected the restored message to be popped")
+	}
+	if _, err := restored.SaveSnapshotFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := NewBroker().LoadSnapshotFile(path); err != nil || n != 0 {
+		t.Errorf("expected the popped message not to be restored, got %d, %v", n, err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestSnapshot_FailureKeepsMessages(t *testing.T) {
+	b := NewBroker()
+	b.Enqueue(&Frame{ChannelName: "events", Data: []byte("first")})
+	b.Enqueue(&Frame{ChannelName: "events", Data: []byte("second")})
+
+	if _, err := b.WriteSnapshot(failingWriter{}); err == nil {
+		t.Fatal("expected write error")
+	}
+	path := filepath.Join(t.TempDir(), "missing", "broker.snapshot")
+	if _, err := b.SaveSnapshotFile(path); err == nil {
+		t.Fatal("expected error for a missing directory")
+	}
+	if events, _ := b.Lookup("events"); events.Size() != 2 {
+		t.Errorf("expected both messages still queued, got %d", events.Size())
+	}
+}
+
+func TestSnapshot_KeepsSequences(t *testing.T) {
+	b := NewBroker()
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("first")})
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("second")})
+	events, _ := b.Lookup("events")
+	events.Partitions[0].Dequeue() // The snapshot starts at sequence 2
+
+	buf := new(bytes.Buffer)
+	if _, err := b.WriteSnapshot(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored := NewBroker()
+	if _, err := restored.LoadSnapshot(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ch, _ := restored.Lookup("events")
+	if msg, _ := ch.Partitions[0].Dequeue(); msg.Seq != 2 || string(msg.Data) != "second" {
+		t.Errorf("expected message 2, got %+v", msg)
+	}
+	if result, _ := restored.Publish(&Frame{ChannelName: "events"}); result.Sequence != 3 {
+		t.Errorf("expected sequence 3 after the restored ones, got %d", result.Sequence)
+	}
+}
+
+func TestSnapshot_RestoresDedupWindow(t *testing.T) {
+	b := NewBroker()
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("once"), MessageID: "order-1"})
+	buf := new(bytes.Buffer)
+	if _, err := b.WriteSnapshot(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored := NewBroker()
+	if _, err := restored.LoadSnapshot(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, _ := restored.Publish(&Frame{ChannelName: "events", Data: []byte("once"), MessageID: "order-1"})
+	if !result.Duplicate || result.Sequence != 1 {
+		t.Errorf("expected the retry to be detected as a duplicate of 1, got %+v", result)
+	}
+}
+
+func TestSnapshot_KeepsRetained(t *testing.T) {
+	b := retainingBroker()
+	publishAll(t, b, "events", func(time.Duration) {}, "a", "b")
+	events, _ := b.Lookup("events")
+	b.Pop(events, "")
+
+	buf := new(bytes.Buffer)
+	if n, err := b.WriteSnapshot(buf); err != nil || n != 1 {
+		t.Fatalf("expected 1 message written, got %d, %v", n, err)
+	}
+	restored := retainingBroker()
+	if n, err := restored.LoadSnapshot(buf); err != nil || n != 1 {
+		t.Fatalf("expected 1 message loaded, got %d, %v", n, err)
+	}
+	ch, _ := restored.Lookup("events")
+	if ch.Size() != 1 {
+		t.Errorf("expected 1 queued message, got %d", ch.Size())
+	}
+	if got := historyData(restored.Replay(ch, 0, 10)); !equalStrings(got, []string{"a", "b"}) {
+		t.Errorf("expected the popped message to stay retained, got %v", got)
+	}
+}
+
+func TestSnapshot_KeepsPartitions(t *testing.T) {
+	b := NewBroker()
+	b.SetDefaults(ChannelDefaults{Partitions: 4})
+	for _, key := range []string{"AAPL", "MSFT", "AAPL", ""} {
+		b.Publish(&Frame{ChannelName: "trades", Key: key, Data: []byte(key)})
+	}
+	before, _ := b.Lookup("trades")
+	want := make([]int, 4)
+	for i, q := range before.Partitions {
+		want[i] = q.Size()
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := b.WriteSnapshot(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored := NewBroker()
+	restored.SetDefaults(ChannelDefaults{Partitions: 4})
+	if _, err := restored.LoadSnapshot(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	after, _ := restored.Lookup("trades")
+	for i, q := range after.Partitions {
+		if q.Size() != want[i] {
+			t.Errorf("partition %d: expected size %d, got %d", i, want[i], q.Size())
+		}
+	}
+
+	// Sequence numbers continue after the restored ones
+	result, _ := restored.Publish(&Frame{ChannelName: "trades"})
+	if result.Sequence != 5 {
+		t.Errorf("expected sequence 5, got %d", result.Sequence)
+	}
+}
+
+func TestSnapshot_NotASnapshot(t *testing.T) {
+	frames := new(bytes.Buffer)
+	WriteFrame(frames, "events", []byte("first"))
+	if _, err := NewBroker().LoadSnapshot(frames); err == nil || !strings.Contains(err.Error(), "not a broker snapshot") {
+		t.Errorf("expected error for a file without snapshot header, got %v", err)
+	}
+}
+
+func TestSnapshot_UnsupportedVersion(t *testing.T) {
+	snapshot := bytes.NewBufferString(snapshotMagic + "\x09")
+	if _, err := NewBroker().LoadSnapshot(snapshot); err == nil || !strings.Contains(err.Error(), "version 9") {
+		t.Errorf("expected unsupported version error, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package pubsub
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SubscriptionsHandler manages the webhook subscriptions of a Dispatcher.
+type SubscriptionsHandler struct {
+	Dispatcher *Dispatcher
+}
+
+func NewSubscriptionsHandler(d *Dispatcher) *SubscriptionsHandler {
+	return &SubscriptionsHandler{Dispatcher: d}
+}
+
+// SubscribeRequest is the body of a subscription creation.
+type SubscribeRequest struct {
+	Channel     string `json:"channel"`
+	URL         string `json:"url"`
+	Secret      string `json:"secret"`
+	Concurrency int    `json:"concurrency"`
+	MaxAttempts int    `json:"max_attempts"`
+	Filter      string `json:"filter"`
+}
+
+// HandleCreate registers a webhook and starts delivering the messages of its
+// channel to it.
+func (h *SubscriptionsHandler) HandleCreate(c *gin.Context) {
+	var req SubscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subscription"})
+		return
+	}
+	sub := &Subscription{
+		Channel:     req.Channel,
+		URL:         req.URL,
+		Secret:      req.Secret,
+		Concurrency: req.Concurrency,
+		MaxAttempts: req.MaxAttempts,
+		Filter:      req.Filter,
+	}
+	if err := h.Dispatcher.Subscribe(sub); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, sub.response())
+}
+
+// HandleList returns every subscription with its delivery counters.
+func (h *SubscriptionsHandler) HandleList(c *gin.Context) {
+	subs := h.Dispatcher.Subscriptions()
+	list := make([]SubscriptionResponse, 0, len(subs))
+	for _, sub := range subs {
+		list = append(list, sub.response())
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// HandleGet returns the subscription with the ID in the URL.
+func (h *SubscriptionsHandler) HandleGet(c *gin.Context) {
+	sub, ok := h.Dispatcher.Lookup(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrSubscriptionNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sub.response())
+}
+
+// HandleDelete stops the subscription with the ID in the URL. Its dead
+// letters are kept.
+func (h *SubscriptionsHandler) HandleDelete(c *gin.Context) {
+	if err := h.Dispatcher.Unsubscribe(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
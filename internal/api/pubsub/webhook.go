@@ -0,0 +1,541 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Headers of a webhook delivery, besides the ones describing a popped message.
+const (
+	ChannelHeader          = "X-Channel"
+	WebhookAttemptHeader   = "X-Webhook-Attempt"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	// WebhookSignatureHeader holds "sha256=" followed by the hex HMAC-SHA256
+	// of the timestamp, a dot and the body, keyed with the subscription secret.
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// DeadLetterPrefix starts the name of the channels holding the messages a
+// subscription failed to deliver, followed by the subscription ID.
+const DeadLetterPrefix = "_dead."
+
+// IsDeadLetterChannel reports whether name holds undeliverable messages.
+func IsDeadLetterChannel(name string) bool {
+	return strings.HasPrefix(name, DeadLetterPrefix)
+}
+
+// Bounds of a subscription. MaxSubscriberBacklog bounds the messages
+// waiting to be delivered when the channel has no maximum depth, and
+// MaxWebhookResponse the bytes of a response read for the connection to be
+// reused.
+const (
+	DefaultWebhookAttempts = 5
+	MaxWebhookConcurrency  = 64
+	MaxSubscriberBacklog   = 10000
+	MaxWebhookResponse     = 64 << 10
+)
+
+// Subscription delivers the messages of a channel to an HTTP endpoint. Every
+// subscription gets its own copy of each message published once it started.
+// The message stays queued in the channel, for the pulling consumers and the
+// snapshots, until every subscription which got a copy delivered it, moved it
+// to its dead letters or skipped it. With a concurrency above 1, messages
+// sharing a key may be delivered out of order.
+type Subscription struct {
+	ID          string
+	Channel     string
+	URL         string
+	Secret      string // Key of the delivery signatures, none when empty
+	Concurrency int    // Deliveries in progress at most
+	MaxAttempts int
+	// Filter expression the delivered messages must match, as for pops.
+	// Messages not matching it are skipped by this subscription only.
+	Filter string
+
+	filter *MessageFilter
+	queue  *subscriberQueue
+
+	Stats SubscriptionStats
+
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// SubscriptionStats counts the deliveries of a subscription.
+type SubscriptionStats struct {
+	Delivered atomic.Uint64
+	Retried   atomic.Uint64
+	Failed    atomic.Uint64 // Moved to the dead letter channel
+	InFlight  atomic.Int64
+}
+
+// subscriberQueue holds the messages a subscription has yet to deliver.
+type subscriberQueue struct {
+	*Queue[*Message]
+	dropped atomic.Uint64 // Not queued because the subscription lagged too far behind
+}
+
+// push queues msg unless limit messages are already waiting. A limit of zero
+// or less means unbounded.
+func (q *subscriberQueue) push(msg *Message, limit int) bool {
+	if !q.EnqueueBounded(msg, limit) {
+		q.dropped.Add(1)
+		return false
+	}
+	return true
+}
+
+// fanOut gives each subscription of ch a copy of msg, and keeps count of
+// them. Must be called with mu held.
+func (ch *Channel) fanOut(msg *Message, defaults *ChannelDefaults) {
+	limit := defaults.MaxDepth
+	if limit <= 0 {
+		limit = MaxSubscriberBacklog
+	}
+	copies := 0
+	for _, q := range ch.subscribers {
+		if q.push(msg, limit) {
+			copies++
+		}
+	}
+	if copies > 0 {
+		ch.pending[msg] = copies
+	}
+}
+
+// ack records that a subscription is done with its copy of msg. Once all the
+// copies are, msg is removed from its partition if not popped already.
+func (ch *Channel) ack(msg *Message) {
+	ch.mu.Lock()
+	copies, ok := ch.pending[msg]
+	if copies > 1 {
+		ch.pending[msg] = copies - 1
+	} else {
+		delete(ch.pending, msg)
+	}
+	ch.mu.Unlock()
+	if !ok || copies > 1 {
+		return
+	}
+	if _, ok := ch.Partitions[msg.Partition].RemoveFirst(func(m *Message) bool { return m == msg }); ok {
+		ch.removed(msg)
+	}
+}
+
+// dropCopies removes the copies of messages from the subscription queues,
+// which stop counting them as pending. Copies being delivered are still
+// delivered, their acknowledgement is then ignored.
+func (ch *Channel) dropCopies(messages map[*Message]bool) {
+	if len(messages) == 0 {
+		return
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for msg := range messages {
+		delete(ch.pending, msg)
+	}
+	for _, q := range ch.subscribers {
+		for {
+			if _, ok := q.RemoveFirst(func(msg *Message) bool { return messages[msg] }); !ok {
+				break
+			}
+		}
+	}
+}
+
+// subscribe registers a queue receiving a copy of the messages published to
+// ch from now on.
+func (ch *Channel) subscribe(id string) *subscriberQueue {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	q := &subscriberQueue{Queue: NewQueue[*Message]()}
+	ch.subscribers[id] = q
+	return q
+}
+
+// unsubscribe drops the queue of subscription id, acknowledging the messages
+// it did not deliver.
+func (ch *Channel) unsubscribe(id string) {
+	ch.mu.Lock()
+	q, ok := ch.subscribers[id]
+	delete(ch.subscribers, id)
+	ch.mu.Unlock()
+	if !ok {
+		return
+	}
+	for msg, ok := q.Dequeue(); ok; msg, ok = q.Dequeue() {
+		ch.ack(msg)
+	}
+}
+
+// SubscriptionResponse is the JSON representation of a subscription.
+type SubscriptionResponse struct {
+	ID          string `json:"id"`
+	Channel     string `json:"channel"`
+	URL         string `json:"url"`
+	Concurrency int    `json:"concurrency"`
+	MaxAttempts int    `json:"max_attempts"`
+	Filter      string `json:"filter,omitempty"`
+	Delivered   uint64 `json:"delivered"`
+	Retried     uint64 `json:"retried"`
+	Failed      uint64 `json:"failed"`
+	Dropped     uint64 `json:"dropped"`
+	InFlight    int64  `json:"in_flight"`
+	DeadLetters string `json:"dead_letters"` // Channel of the failed messages
+}
+
+func (s *Subscription) response() SubscriptionResponse {
+	return SubscriptionResponse{
+		ID:          s.ID,
+		Channel:     s.Channel,
+		URL:         s.URL,
+		Concurrency: s.Concurrency,
+		MaxAttempts: s.MaxAttempts,
+		Filter:      s.Filter,
+		Delivered:   s.Stats.Delivered.Load(),
+		Retried:     s.Stats.Retried.Load(),
+		Failed:      s.Stats.Failed.Load(),
+		Dropped:     s.queue.dropped.Load(),
+		InFlight:    s.Stats.InFlight.Load(),
+		DeadLetters: DeadLetterPrefix + s.ID,
+	}
+}
+
+// Dispatcher runs the webhook subscriptions of a broker.
+type Dispatcher struct {
+	Broker *Broker
+	Client *http.Client
+	// Delay before the second attempt, doubled for every following one up
+	// to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	allowed atomic.Pointer[[]netip.Prefix]
+	mu      sync.Mutex
+	subs    map[string]*Subscription
+	ctx     context.Context
+	stop    context.CancelFunc
+}
+
+// NewDispatcher returns a dispatcher whose client refuses to connect to
+// loopback, private and link-local addresses, so that subscribers cannot make
+// the broker reach internal services. SetAllowedNetworks lifts this for
+// trusted networks.
+func NewDispatcher(b *Broker) *Dispatcher {
+	ctx, stop := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		Broker:         b,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		subs:           make(map[string]*Subscription),
+		ctx:            ctx,
+		stop:           stop,
+	}
+	// Addresses are checked once resolved, which also covers redirects and
+	// host names pointing to internal addresses
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: d.checkDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	d.Client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	return d
+}
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrWebhookAddress       = errors.New("webhook address not allowed")
+)
+
+// SetAllowedNetworks replaces the loopback, private or link-local networks
+// webhooks may be delivered to. It is safe to call while delivering.
+func (d *Dispatcher) SetAllowedNetworks(networks []netip.Prefix) {
+	d.allowed.Store(&networks)
+}
+
+// checkAddr returns ErrWebhookAddress when addr is internal and not in an
+// allowed network.
+func (d *Dispatcher) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	if !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast() && !addr.IsUnspecified() {
+		return nil
+	}
+	if allowed := d.allowed.Load(); allowed != nil {
+		for _, network := range *allowed {
+			if network.Contains(addr) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrWebhookAddress, addr)
+}
+
+// checkDial is the net.Dialer Control function of the dispatcher client.
+func (d *Dispatcher) checkDial(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	return d.checkAddr(addrPort.Addr())
+}
+
+// Subscribe validates sub, assigns its ID and starts delivering.
+func (d *Dispatcher) Subscribe(sub *Subscription) error {
+	if sub.Channel == "" || len(sub.Channel) > int(d.Broker.limits.Load().MaxChannelLen) {
+		return ErrChannelTooLarge
+	}
+	if IsReplyChannel(sub.Channel) || IsDeadLetterChannel(sub.Channel) {
+		return errors.New("cannot subscribe to an internal channel")
+	}
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q is not an http(s) URL", sub.URL)
+	}
+	// Host names are only checked once resolved, on delivery
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil {
+		if err := d.checkAddr(addr); err != nil {
+			return err
+		}
+	}
+	if sub.Concurrency == 0 {
+		sub.Concurrency = 1
+	}
+	if sub.Concurrency < 0 || sub.Concurrency > MaxWebhookConcurrency {
+		return fmt.Errorf("concurrency must be between 1 and %d", MaxWebhookConcurrency)
+	}
+	if sub.MaxAttempts == 0 {
+		sub.MaxAttempts = DefaultWebhookAttempts
+	}
+	if sub.MaxAttempts < 0 {
+		return errors.New("max_attempts cannot be negative")
+	}
+	if sub.Filter != "" {
+		filter, err := d.Broker.ParseFilter(sub.Channel, sub.Filter)
+		if err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+		sub.filter = filter
+	}
+
+	var id [8]byte
+	rand.Read(id[:])
+	sub.ID = hex.EncodeToString(id[:])
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ctx, cancel := context.WithCancel(d.ctx)
+	sub.cancel = cancel
+	d.subs[sub.ID] = sub
+	ch := d.Broker.Channel(sub.Channel)
+	sub.queue = ch.subscribe(sub.ID)
+	for range sub.Concurrency {
+		sub.done.Add(1)
+		go func() {
+			defer sub.done.Done()
+			d.deliverLoop(ctx, sub, ch)
+		}()
+	}
+	return nil
+}
+
+// Unsubscribe stops the subscription with the given ID, waiting for the
+// deliveries in progress.
+func (d *Dispatcher) Unsubscribe(id string) error {
+	d.mu.Lock()
+	sub, ok := d.subs[id]
+	delete(d.subs, id)
+	d.mu.Unlock()
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	sub.cancel()
+	sub.done.Wait()
+	if ch, ok := d.Broker.Lookup(sub.Channel); ok {
+		ch.unsubscribe(id)
+	}
+	return nil
+}
+
+// Subscriptions returns the subscriptions sorted by channel then ID.
+func (d *Dispatcher) Subscriptions() []*Subscription {
+	d.mu.Lock()
+	list := make([]*Subscription, 0, len(d.subs))
+	for _, sub := range d.subs {
+		list = append(list, sub)
+	}
+	d.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Channel != list[j].Channel {
+			return list[i].Channel < list[j].Channel
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Lookup returns the subscription with the given ID.
+func (d *Dispatcher) Lookup(id string) (*Subscription, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sub, ok := d.subs[id]
+	return sub, ok
+}
+
+// Stop stops every subscription. The messages they did not deliver are still
+// queued in their channel and their subscription queue.
+func (d *Dispatcher) Stop() {
+	d.stop()
+	for _, sub := range d.Subscriptions() {
+		sub.done.Wait()
+	}
+}
+
+// deliverLoop delivers the messages of the subscription queue until ctx is
+// done. The filter is evaluated here rather than on publish, so that it never
+// holds up publishers.
+func (d *Dispatcher) deliverLoop(ctx context.Context, sub *Subscription, ch *Channel) {
+	for ctx.Err() == nil {
+		published := ch.published()
+		msg, ok := sub.queue.Dequeue()
+		if !ok {
+			select {
+			case <-published:
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if sub.filter != nil && !sub.filter.Match(msg) {
+			ch.ack(msg)
+			continue
+		}
+
+		sub.Stats.InFlight.Add(1)
+		if d.deliver(ctx, sub, msg) {
+			ch.ack(msg)
+		} else {
+			sub.queue.Requeue(msg)
+		}
+		sub.Stats.InFlight.Add(-1)
+	}
+}
+
+// deliver posts msg until it is accepted, retrying with exponential backoff.
+// After the last attempt, the message goes to the dead letter channel.
+// Delivery is abandoned when ctx is done, and false returned for the message
+// to be queued again.
+func (d *Dispatcher) deliver(ctx context.Context, sub *Subscription, msg *Message) bool {
+	// Decompress once for every attempt, a corrupt message is not retried
+	body, decodeErr := msg.Data, error(nil)
+	if msg.Encoding != "" {
+		body, decodeErr = Decompress(msg.Encoding, msg.Data, int64(d.Broker.limits.Load().MaxData))
+	}
+	err := decodeErr
+	for attempt := 1; decodeErr == nil && attempt <= sub.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			sub.Stats.Retried.Add(1)
+			select {
+			case <-time.After(d.backoff(attempt)):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		if err = d.post(ctx, sub, msg, body, attempt); err == nil {
+			sub.Stats.Delivered.Add(1)
+			return true
+		}
+	}
+	if ctx.Err() != nil {
+		return false // The last attempt was interrupted
+	}
+
+	sub.Stats.Failed.Add(1)
+	d.Broker.Channel(DeadLetterPrefix+sub.ID).deadLetter(msg, sub.Channel, err)
+	return true
+}
+
+// backoff returns the delay before the given attempt.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := float64(d.InitialBackoff) * math.Pow(2, float64(attempt-2))
+	return min(time.Duration(delay), d.MaxBackoff)
+}
+
+// post sends body, the decompressed data of msg, to the subscription URL.
+func (d *Dispatcher) post(ctx context.Context, sub *Subscription, msg *Message, body []byte, attempt int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(ChannelHeader, sub.Channel)
+	req.Header.Set(SequenceHeader, strconv.FormatUint(msg.Seq, 10))
+	req.Header.Set(PartitionHeader, strconv.Itoa(msg.Partition))
+	req.Header.Set(WebhookAttemptHeader, strconv.Itoa(attempt))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if msg.ID != "" {
+		req.Header.Set(MessageIDHeader, msg.ID)
+	}
+	if msg.Key != "" {
+		req.Header.Set(PartitionKeyHeader, msg.Key)
+	}
+	if sub.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(sub.Secret, timestamp, body))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, MaxWebhookResponse))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SignWebhook returns the signature header value of a delivery, which
+// receivers compute again with their copy of the secret to authenticate it.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter enqueues a message which could not be delivered, remembering
+// the channel it comes from and why it failed.
+func (ch *Channel) deadLetter(msg *Message, origin string, cause error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	dead := *msg
+	dead.Seq = ch.lastSeq + 1
+	dead.Partition = 0
+	dead.Origin = origin
+	if cause != nil {
+		dead.Error = cause.Error()
+	}
+	ch.enqueue(&dead)
+}
@@ -0,0 +1,413 @@
+package pubsub
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// receiver is a local webhook endpoint answering with the status returned by
+// respond for each attempt.
+type receiver struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+	heads  []http.Header
+}
+
+func newReceiver(t *testing.T, respond func(r *http.Request, body []byte) int) *receiver {
+	rec := &receiver{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		status := respond(r, body)
+		if status/100 == 2 {
+			rec.mu.Lock()
+			rec.bodies = append(rec.bodies, string(body))
+			rec.heads = append(rec.heads, r.Header.Clone())
+			rec.mu.Unlock()
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (rec *receiver) header(i int) http.Header {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.heads[i]
+}
+
+func (rec *receiver) received() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string(nil), rec.bodies...)
+}
+
+// loopback holds the addresses of the local test receivers.
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+func newTestDispatcher(t *testing.T, b *Broker) *Dispatcher {
+	d := NewDispatcher(b)
+	d.SetAllowedNetworks(loopback)
+	d.InitialBackoff = time.Millisecond
+	d.MaxBackoff = 5 * time.Millisecond
+	t.Cleanup(d.Stop)
+	return d
+}
+
+func TestDispatcher_DeliversSigned(t *testing.T) {
+	b := NewBroker()
+	d := newTestDispatcher(t, b)
+	rec := newReceiver(t, func(r *http.Request, body []byte) int {
+		want := SignWebhook("s3cret", r.Header.Get(WebhookTimestampHeader), body)
+		if r.Header.Get(WebhookSignatureHeader) != want {
+			return http.StatusUnauthorized
+		}
+		return http.StatusNoContent
+	})
+
+	sub := &Subscription{Channel: "events", URL: rec.URL, Secret: "s3cret"}
+	if err := d.Subscribe(sub); err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	for _, data := range []string{"a", "b", "c"} {
+		b.Publish(&Frame{ChannelName: "events", Data: []byte(data), Key: "k"})
+	}
+
+	waitFor(t, "deliveries", func() bool { return sub.Stats.Delivered.Load() == 3 })
+	if got := strings.Join(rec.received(), ""); got != "abc" {
+		t.Errorf("expected deliveries in order, got %q", got)
+	}
+	h := rec.header(0)
+	if h.Get(ChannelHeader) != "events" || h.Get(SequenceHeader) != "1" || h.Get(PartitionKeyHeader) != "k" {
+		t.Errorf("unexpected delivery headers: %v", h)
+	}
+	if sub.Stats.Retried.Load() != 0 {
+		t.Errorf("expected no retry, got %d", sub.Stats.Retried.Load())
+	}
+}
+
+func TestDispatcher_Retries(t *testing.T) {
+	b := NewBroker()
+	d := newTestDispatcher(t, b)
+	var attempts atomic.Int32
+	rec := newReceiver(t, func(r *http.Request, body []byte) int {
+		if attempts.Add(1) < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+
+	sub := &Subscription{Channel: "events", URL: rec.URL}
+	d.Subscribe(sub)
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("retried")})
+
+	waitFor(t, "delivery", func() bool { return sub.Stats.Delivered.Load() == 1 })
+	if got := sub.Stats.Retried.Load(); got != 2 {
+		t.Errorf("expected 2 retries, got %d", got)
+	}
+	if got := rec.header(0).Get(WebhookAttemptHeader); got != "3" {
+		t.Errorf("expected attempt 3, got %q", got)
+	}
+}
+
+func TestDispatcher_BoundsResponses(t *testing.T) {
+	b := NewBroker()
+	d := newTestDispatcher(t, b)
+	endless := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunk := make([]byte, 32<<10)
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(endless.Close)
+
+	// The delivery completes without reading the whole response
+	sub := &Subscription{Channel: "events", URL: endless.URL}
+	d.Subscribe(sub)
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("x")})
+	waitFor(t, "delivery", func() bool { return sub.Stats.Delivered.Load() == 1 })
+}
+
+func TestDispatcher_Decompresses(t *testing.T) {
+	b := NewBroker()
+	d := newTestDispatcher(t, b)
+	var attempts atomic.Int32
+	rec := newReceiver(t, func(r *http.Request, body []byte) int {
+		if attempts.Add(1) < 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+
+	sub := &Subscription{Channel: "events", URL: rec.URL}
+	d.Subscribe(sub)
+	data, _ := Compress(EncodingGzip, []byte("compressed"))
+	b.Publish(&Frame{ChannelName: "events", Data: data, Encoding: EncodingGzip})
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("corrupt"), Encoding: EncodingGzip})
+
+	waitFor(t, "deliveries", func() bool { return sub.Stats.Delivered.Load()+sub.Stats.Failed.Load() == 2 })
+	if got := rec.received(); len(got) != 1 || got[0] != "compressed" {
+		t.Errorf("expected the decompressed data delivered after a retry, got %q", got)
+	}
+	if got := sub.Stats.Failed.Load(); got != 1 || attempts.Load() != 2 {
+		t.Errorf("expected the corrupt message dead lettered without attempt, got %d failed and %d attempts", got, attempts.Load())
+	}
+}
+
+func TestDispatcher_DeadLetter(t *testing.T) {
+	b := NewBroker()
+	d := newTestDispatcher(t, b)
+	rec := newReceiver(t, func(r *http.Request, body []byte) int {
+		return http.StatusInternalServerError
+	})
+
+	sub := &Subscription{Channel: "events", URL: rec.URL, MaxAttempts: 2}
+	d.Subscribe(sub)
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("lost"), MessageID: "m1"})
+
+	waitFor(t, "dead letter", func() bool { return sub.Stats.Failed.Load() == 1 })
+	dead, ok := b.Lookup(DeadLetterPrefix + sub.ID)
+	if !ok || dead.Size() != 1 {
+		t.Fatal("expected the message in the dead letter channel")
+	}
+	msg, _ := dead.Partitions[0].Dequeue()
+	if string(msg.Data) != "lost" || msg.ID != "m1" || msg.Origin != "events" {
+		t.Errorf("unexpected dead letter: %+v", msg)
+	}
+	if !strings.Contains(msg.Error, "500") {
+		t.Errorf("expected the failure cause, got %q", msg.Error)
+	}
+}
+
+func TestDispatcher_Concurrency(t *testing.T) {
+	b := NewBroker()
+	d := newTestDispatcher(t, b)
+	var inFlight, peak atomic.Int32
+	rec := newReceiver(t, func(r *http.Request, body []byte) int {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(10 * time.Millisecond)
+		return http.StatusOK
+	})
+
+	sub := &Subscription{Channel: "events", URL: rec.URL, Concurrency: 3}
+	d.Subscribe(sub)
+	for range 12 {
+		b.Publish(&Frame{ChannelName: "events", Data: []byte("x")})
+	}
+
+	waitFor(t, "deliveries", func() bool { return sub.Stats.Delivered.Load() == 12 })
+	if got := peak.Load(); got != 3 {
+		t.Errorf("expected at most and up to 3 concurrent deliveries, got %d", got)
+	}
+}
+
+func TestDispatcher_StopKeepsMessages(t *testing.T) {
+	b := NewBroker()
+	d := NewDispatcher(b)
+	d.SetAllowedNetworks(loopback)
+	d.InitialBackoff = time.Hour
+	rec := newReceiver(t, func(r *http.Request, body []byte) int {
+		return http.StatusBadGateway
+	})
+
+	sub := &Subscription{Channel: "events", URL: rec.URL}
+	d.Subscribe(sub)
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("pending")})
+	waitFor(t, "first attempt", func() bool { return sub.Stats.Retried.Load() == 1 })
+
+	d.Stop()
+	if sub.queue.Size() != 1 {
+		t.Errorf("expected the abandoned delivery back in the subscription queue, got %d messages", sub.queue.Size())
+	}
+	ch, _ := b.Lookup("events")
+	if msg, _ := b.Pop(ch, ""); string(msg.Data) != "pending" || msg.Seq != 1 {
+		t.Errorf("expected the undelivered message still in its channel, got %q with sequence %d", msg.Data, msg.Seq)
+	}
+}
+
+func TestDispatcher_DeliveriesFreeTheChannel(t *testing.T) {
+	b := NewBroker()
+	defaults := DefaultConfig().Channels
+	defaults.MaxDepth = 2
+	b.SetDefaults(defaults)
+	d := newTestDispatcher(t, b)
+	rec := newReceiver(t, func(r *http.Request, body []byte) int { return http.StatusOK })
+	sub := &Subscription{Channel: "events", URL: rec.URL}
+	d.Subscribe(sub)
+
+	for i := range 5 {
+		if _, err := b.Publish(&Frame{ChannelName: "events", Data: []byte("x")}); err != nil {
+			t.Fatalf("publish %d: %v", i, err)
+		}
+		waitFor(t, "delivery", func() bool { return sub.Stats.Delivered.Load() == uint64(i+1) })
+	}
+	ch, _ := b.Lookup("events")
+	waitFor(t, "delivered messages removed", func() bool { return ch.Size() == 0 })
+
+	// Messages left undelivered are released on unsubscribe
+	blocked := &Subscription{Channel: "events", URL: rec.URL}
+	d.Subscribe(blocked)
+	d.Unsubscribe(sub.ID)
+	blocked.cancel()
+	blocked.done.Wait()
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("y")})
+	d.Unsubscribe(blocked.ID)
+	if ch.Size() != 0 || len(ch.pending) != 0 {
+		t.Errorf("expected the unsubscribed copies released, got %d messages and %d pending", ch.Size(), len(ch.pending))
+	}
+}
+
+func TestDispatcher_FanOut(t *testing.T) {
+	b := NewBroker()
+	d := newTestDispatcher(t, b)
+	var failing atomic.Bool
+	failing.Store(true)
+	slow := newReceiver(t, func(r *http.Request, body []byte) int {
+		if failing.Load() {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	fast := newReceiver(t, func(r *http.Request, body []byte) int { return http.StatusOK })
+
+	first := &Subscription{Channel: "events", URL: slow.URL, MaxAttempts: 1}
+	second := &Subscription{Channel: "events", URL: fast.URL}
+	d.Subscribe(first)
+	d.Subscribe(second)
+	ch, _ := b.Lookup("events")
+	b.Pop(ch, "puller") // Joining the consumer group takes nothing from subscriptions
+	for _, data := range []string{"a", "b"} {
+		b.Publish(&Frame{ChannelName: "events", Data: []byte(data)})
+	}
+
+	waitFor(t, "deliveries", func() bool {
+		return second.Stats.Delivered.Load() == 2 && first.Stats.Failed.Load() == 2
+	})
+	if got := strings.Join(fast.received(), ""); got != "ab" {
+		t.Errorf("expected every message delivered to the second subscription, got %q", got)
+	}
+	waitFor(t, "handled messages removed", func() bool { return ch.Size() == 0 })
+
+	// Replayed dead letters only go back to the subscription which failed
+	failing.Store(false)
+	dead, _ := b.Lookup(DeadLetterPrefix + first.ID)
+	if n, err := b.ReplayDeadLetters(dead); err != nil || n != 2 {
+		t.Fatalf("ReplayDeadLetters() = %d, %v, want 2", n, err)
+	}
+	waitFor(t, "redeliveries", func() bool { return first.Stats.Delivered.Load() == 2 })
+	if got := strings.Join(slow.received(), ""); got != "ab" {
+		t.Errorf("expected the dead letters redelivered in order, got %q", got)
+	}
+	if second.Stats.Delivered.Load() != 2 || ch.Size() != 0 {
+		t.Errorf("expected the replay to leave the channel and other subscriptions alone")
+	}
+}
+
+func TestDispatcher_ChannelNameLimit(t *testing.T) {
+	b := NewBroker()
+	limits := DefaultLimits()
+	limits.MaxChannelLen = 4
+	b.SetLimits(limits)
+	d := newTestDispatcher(t, b)
+	if err := d.Subscribe(&Subscription{Channel: "events", URL: "http://localhost/hook"}); !errors.Is(err, ErrChannelTooLarge) {
+		t.Errorf("error = %v, want %v", err, ErrChannelTooLarge)
+	}
+}
+
+func TestDispatcher_RefusesInternalAddresses(t *testing.T) {
+	b := NewBroker()
+	d := NewDispatcher(b)
+	t.Cleanup(d.Stop)
+	rec := newReceiver(t, func(r *http.Request, body []byte) int {
+		return http.StatusOK
+	})
+
+	for _, url := range []string{"http://127.0.0.1/hook", "http://169.254.169.254/latest", "http://[::1]/hook", "http://10.1.2.3/hook"} {
+		if err := d.Subscribe(&Subscription{Channel: "events", URL: url}); !errors.Is(err, ErrWebhookAddress) {
+			t.Errorf("%s: error = %v, want %v", url, err, ErrWebhookAddress)
+		}
+	}
+
+	// Host names are checked once resolved
+	sub := &Subscription{Channel: "events", URL: strings.Replace(rec.URL, "127.0.0.1", "localhost", 1), MaxAttempts: 1}
+	if err := d.Subscribe(sub); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b.Publish(&Frame{ChannelName: "events", Data: []byte("secret")})
+	waitFor(t, "dead letter", func() bool { return sub.Stats.Failed.Load() == 1 })
+	if got := rec.received(); len(got) != 0 {
+		t.Errorf("expected nothing delivered to a loopback address, got %v", got)
+	}
+	dead, _ := b.Lookup(DeadLetterPrefix + sub.ID)
+	if msg, _ := b.Pop(dead, ""); !strings.Contains(msg.Error, ErrWebhookAddress.Error()) {
+		t.Errorf("expected the dead letter to tell the address is refused, got %q", msg.Error)
+	}
+
+	d.SetAllowedNetworks([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	if err := d.Subscribe(&Subscription{Channel: "events", URL: "http://10.1.2.3/hook"}); err != nil {
+		t.Errorf("expected an allowed network to be accepted, got %v", err)
+	}
+}
+
+func TestSubscriptionsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	d := newTestDispatcher(t, NewBroker())
+	h := NewSubscriptionsHandler(d)
+	r := gin.New()
+	r.POST("/subscriptions", h.HandleCreate)
+	r.GET("/subscriptions", h.HandleList)
+	r.DELETE("/subscriptions/:id", h.HandleDelete)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return w
+	}
+
+	for _, body := range []string{
+		`{"channel":"events","url":"ftp://example.com"}`,
+		`{"channel":"_reply.x","url":"http://example.com"}`,
+		`{"channel":"events","url":"http://example.com","concurrency":1000}`,
+		`not json`,
+	} {
+		if w := serve("POST", "/subscriptions", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, w.Code)
+		}
+	}
+
+	w := serve("POST", "/subscriptions", `{"channel":"events","url":"http://example.com/hook","secret":"s"}`)
+	if w.Code != http.StatusCreated || strings.Contains(w.Body.String(), `"secret"`) {
+		t.Fatalf("expected 201 without the secret, got %d %s", w.Code, w.Body.String())
+	}
+	sub := d.Subscriptions()[0]
+	if sub.Concurrency != 1 || sub.MaxAttempts != DefaultWebhookAttempts {
+		t.Errorf("expected default concurrency and attempts, got %d and %d", sub.Concurrency, sub.MaxAttempts)
+	}
+
+	if w := serve("GET", "/subscriptions", ""); !strings.Contains(w.Body.String(), `"dead_letters":"_dead.`+sub.ID+`"`) {
+		t.Errorf("unexpected list: %s", w.Body.String())
+	}
+	if w := serve("DELETE", "/subscriptions/"+sub.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	if w := serve("DELETE", "/subscriptions/"+sub.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
@@ -20,7 +20,7 @@ func StartHTTPServer(srv *http.Server, wg *sync.WaitGroup) {
 }
 
 func StopHTTPServer(srv *http.Server, timeout time.Duration) error {
-	// Graceful shutdown with 5 second timeout
+	// Graceful shutdown, waiting at most timeout for in-flight requests
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
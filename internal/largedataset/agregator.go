@@ -2,13 +2,29 @@ package largedataset
 
 import (
 	"fmt"
-	"io"
+	"slices"
 	"sort"
 )
 
 type Aggregator interface {
 	Consume(row *LogicalRow)
-	Report(w io.Writer)
+	// Merge adds the rows consumed by other, an aggregator of the same type
+	// and settings, as if they had been consumed by this one.
+	Merge(other Aggregator)
+	// Tables returns the results, once every row has been consumed.
+	Tables() []*Table
+}
+
+// mergeChecker is implemented by the aggregators which can't always be
+// merged, and must then consume every row on a single worker.
+type mergeChecker interface {
+	mergeable() bool
+}
+
+// mergeable reports whether agg can be merged with other aggregators.
+func mergeable(agg Aggregator) bool {
+	m, ok := agg.(mergeChecker)
+	return !ok || m.mergeable()
 }
 
 type CompositeAggregator struct {
@@ -25,99 +41,120 @@ func (c *CompositeAggregator) Consume(row *LogicalRow) {
 	}
 }
 
-func (c *CompositeAggregator) Report(w io.Writer) {
-	for _, a := range c.aggs {
-		a.Report(w)
+func (c *CompositeAggregator) Merge(other Aggregator) {
+	o := other.(*CompositeAggregator)
+	for i, a := range c.aggs {
+		a.Merge(o.aggs[i])
 	}
 }
 
-type GlobalAmountAggregator struct {
-	Stats *AmountStats
+func (c *CompositeAggregator) mergeable() bool {
+	for _, a := range c.aggs {
+		if !mergeable(a) {
+			return false
+		}
+	}
+	return true
 }
 
-func NewGlobalAmountAggregator() *GlobalAmountAggregator {
-	stats := NewAmountStats()
-	return &GlobalAmountAggregator{Stats: stats}
+func (c *CompositeAggregator) Tables() []*Table {
+	var tables []*Table
+	for _, a := range c.aggs {
+		tables = append(tables, a.Tables()...)
+	}
+	return tables
 }
 
-func (g *GlobalAmountAggregator) Consume(row *LogicalRow) {
-	g.Stats.Add(row)
+// newMeasureStats returns empty stats for each measure.
+func newMeasureStats(measures []Measure) []*AmountStats {
+	stats := make([]*AmountStats, len(measures))
+	for i := range stats {
+		stats[i] = NewAmountStats()
+	}
+	return stats
 }
 
-func (g *GlobalAmountAggregator) Report(w io.Writer) {
-	if !g.Stats.HasData() {
-		fmt.Fprintln(w, "\nNo valid amount data to compute stats.")
-		return
+// mergeStats merges each of from into the stats of the same measure.
+func mergeStats(stats, from []*AmountStats) {
+	for i, s := range from {
+		stats[i].Merge(s)
 	}
-
-	fmt.Fprintln(w, "\n=== Amount stats (global) ===")
-	fmt.Fprintf(w, "Count:   %d\n", g.Stats.Count)
-	fmt.Fprintf(w, "Sum:     %.2f\n", g.Stats.Sum)
-	fmt.Fprintf(w, "Min:     %.2f\n", g.Stats.Min)
-	fmt.Fprintf(w, "Max:     %.2f\n", g.Stats.Max)
-	fmt.Fprintf(w, "Average: %.2f\n", g.Stats.Average())
 }
 
-type GroupByAggregator struct {
-	statsByKey map[string]*AmountStats
+// addValues adds the measures of row to stats.
+func addValues(stats []*AmountStats, row *LogicalRow) {
+	for i, v := range row.Values {
+		stats[i].Add(v)
+	}
 }
 
-func NewGroupByAggregator() *GroupByAggregator {
-	return &GroupByAggregator{
-		statsByKey: make(map[string]*AmountStats),
+// reportPercentiles are the percentiles of the stats tables.
+var reportPercentiles = []float64{50, 90, 99}
+
+// statsColumns returns the columns of statsRow.
+func statsColumns() []string {
+	columns := []string{"Measure", "Count", "Sum", "Min", "Max", "Average", "Variance", "StdDev"}
+	for _, p := range reportPercentiles {
+		columns = append(columns, fmt.Sprintf("P%g", p))
 	}
+	return columns
 }
 
-func (g *GroupByAggregator) Consume(row *LogicalRow) {
-	if row.GroupKey == "" {
-		return
+// statsRow returns the stats of measure m as cells.
+func statsRow(m Measure, s *AmountStats) []any {
+	if !s.HasData() {
+		row := []any{m.Name, int64(0)}
+		return append(row, make([]any, len(statsColumns())-len(row))...)
 	}
-
-	s, ok := g.statsByKey[row.GroupKey]
-	if !ok {
-		s = NewAmountStats()
-		g.statsByKey[row.GroupKey] = s
+	row := []any{m.Name, s.Count, measureValue(m, s.Sum), measureValue(m, s.Min), measureValue(m, s.Max),
+		number(s.Average()), number(s.Variance()), number(s.StdDev())}
+	for _, p := range reportPercentiles {
+		row = append(row, measureValue(m, s.Percentile(p)))
 	}
-
-	s.Add(row)
+	return row
 }
 
-func (g *GroupByAggregator) Report(w io.Writer) {
-	if len(g.statsByKey) == 0 {
-		return
+// formatMeasure formats a value of m, without decimals for integer measures.
+func formatMeasure(m Measure, v float64) string {
+	if m.Type == TypeInt {
+		return fmt.Sprintf("%.0f", v)
 	}
+	return fmt.Sprintf("%.2f", v)
+}
 
-	fmt.Fprintln(w, "\n=== Group-by statistics ===")
-	fmt.Fprintf(w, "Number of groups: %d\n\n", len(g.statsByKey))
+type GlobalAmountAggregator struct {
+	Measures []Measure
+	Stats    []*AmountStats // One per measure
+}
 
-	type groupStat struct {
-		key   string
-		stats *AmountStats
-	}
+func NewGlobalAmountAggregator(measures []Measure) *GlobalAmountAggregator {
+	return &GlobalAmountAggregator{Measures: measures, Stats: newMeasureStats(measures)}
+}
 
-	groupList := make([]groupStat, 0, len(g.statsByKey))
-	for key, stats := range g.statsByKey {
-		groupList = append(groupList, groupStat{key: key, stats: stats})
-	}
+func (g *GlobalAmountAggregator) Consume(row *LogicalRow) {
+	addValues(g.Stats, row)
+}
 
-	sort.Slice(groupList, func(i, j int) bool {
-		return groupList[i].stats.Sum > groupList[j].stats.Sum
-	})
+func (g *GlobalAmountAggregator) Merge(other Aggregator) {
+	mergeStats(g.Stats, other.(*GlobalAmountAggregator).Stats)
+}
 
-	fmt.Fprintln(w, "Groups sorted by total sum (descending):")
-	for i, group := range groupList {
-		fmt.Fprintf(w, "[%d] %s\n", i+1, group.key)
-		fmt.Fprintf(w, "  Count:   %d\n", group.stats.Count)
-		fmt.Fprintf(w, "  Sum:     %.2f\n", group.stats.Sum)
-		fmt.Fprintf(w, "  Min:     %.2f\n", group.stats.Min)
-		fmt.Fprintf(w, "  Max:     %.2f\n", group.stats.Max)
-		fmt.Fprintf(w, "  Average: %.2f\n\n", group.stats.Average())
+func (g *GlobalAmountAggregator) Tables() []*Table {
+	t := &Table{Title: "Measure stats (global)", Columns: statsColumns()}
+	if len(g.Stats) == 0 || !g.Stats[0].HasData() {
+		t.Notes = append(t.Notes, "No valid measure data to compute stats.")
+		return []*Table{t}
+	}
+	for i, m := range g.Measures {
+		t.Rows = append(t.Rows, statsRow(m, g.Stats[i]))
 	}
+	return []*Table{t}
 }
 
+// DebugAggregator shows the first rows aggregated, in file order.
 type DebugAggregator struct {
-	currentRow int
-	maxRows    int
+	rows    []*LogicalRow
+	maxRows int
 }
 
 func NewDebugAggregator(maxRows int) *DebugAggregator {
@@ -127,10 +164,34 @@ func NewDebugAggregator(maxRows int) *DebugAggregator {
 }
 
 func (g *DebugAggregator) Consume(row *LogicalRow) {
-	if g.currentRow < g.maxRows {
-		fmt.Printf("Debug Row %d: %+v\n", g.currentRow+1, row.RawRecord)
-		g.currentRow++
+	g.keep(row)
+}
+
+func (g *DebugAggregator) Merge(other Aggregator) {
+	for _, row := range other.(*DebugAggregator).rows {
+		g.keep(row)
+	}
+}
+
+// keep inserts row in the rows shown if it comes before the last of them.
+func (g *DebugAggregator) keep(row *LogicalRow) {
+	i := sort.Search(len(g.rows), func(i int) bool { return g.rows[i].Row > row.Row })
+	if i >= g.maxRows {
+		return
+	}
+	g.rows = slices.Insert(g.rows, i, row)
+	if len(g.rows) > g.maxRows {
+		g.rows = g.rows[:g.maxRows]
 	}
 }
 
-func (g *DebugAggregator) Report(w io.Writer) {}
+func (g *DebugAggregator) Tables() []*Table {
+	if len(g.rows) == 0 {
+		return nil
+	}
+	t := &Table{Title: "First rows", Columns: []string{"Row", "Record"}}
+	for _, row := range g.rows {
+		t.Rows = append(t.Rows, []any{int64(row.Row), row.record().Strings()})
+	}
+	return []*Table{t}
+}
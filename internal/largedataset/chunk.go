@@ -0,0 +1,137 @@
+package largedataset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+)
+
+// DefaultChunkSize is the size of the chunks a file is split into for the
+// parallel pipeline.
+const DefaultChunkSize = 4 << 20
+
+// Chunk is a run of whole CSV records of a file.
+type Chunk struct {
+	Index    int    // Position of the chunk in the file, from 0
+	FirstRow int    // Row number of its first record, from 1
+	Data     []byte // Records, each ending with a newline except maybe the last of the file
+}
+
+// chunkReader splits a CSV stream into chunks at record boundaries. Quotes
+// are tracked so that newlines inside quoted fields do not end a record, and
+// records are counted like csv.Reader does, skipping empty lines.
+type chunkReader struct {
+	r       io.Reader
+	size    int
+	pending []byte // Start of the next chunk, read but not returned yet
+	index   int
+	nextRow int
+	eof     bool
+}
+
+func newChunkReader(r io.Reader, size int) *chunkReader {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	return &chunkReader{r: r, size: size, nextRow: 1}
+}
+
+// Next returns the next chunk, of about size bytes unless a record is larger,
+// or io.EOF after the last one.
+func (c *chunkReader) Next() (*Chunk, error) {
+	for {
+		if !c.eof && len(c.pending) < c.size {
+			buf := make([]byte, len(c.pending), c.size+len(c.pending))
+			copy(buf, c.pending)
+			n, err := io.ReadFull(c.r, buf[len(buf):cap(buf)])
+			c.pending = buf[:len(buf)+n]
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		end, records := recordBoundary(c.pending, c.eof)
+		if end == 0 {
+			if c.eof {
+				return nil, io.EOF
+			}
+			// A single record larger than the pending bytes, read more
+			c.size *= 2
+			continue
+		}
+
+		data := c.pending[:end]
+		c.pending = c.pending[end:]
+		if records == 0 {
+			// Only empty lines
+			continue
+		}
+		chunk := &Chunk{Index: c.index, FirstRow: c.nextRow, Data: data}
+		c.index++
+		c.nextRow += records
+		return chunk, nil
+	}
+}
+
+// recordBoundary returns the length of the longest prefix of data made of
+// whole records and how many non-empty records it holds. At the end of the
+// input, a last record without newline counts as whole.
+func recordBoundary(data []byte, atEOF bool) (end, records int) {
+	inQuotes := false
+	lineHasData := false
+	for i, b := range data {
+		switch {
+		case b == '"':
+			inQuotes = !inQuotes
+			lineHasData = true
+		case b == '\n' && !inQuotes:
+			if lineHasData {
+				records++
+			}
+			lineHasData = false
+			end = i + 1
+		case b != '\r':
+			lineHasData = true
+		}
+	}
+	if atEOF && end < len(data) {
+		if lineHasData {
+			records++
+		}
+		end = len(data)
+	}
+	return end, records
+}
+
+// ReadRecord reads a single CSV record from r, reading only up to its end so
+// that the rest of r can be processed separately, e.g. after the header.
+func ReadRecord(r *bufio.Reader, sep rune) ([]string, error) {
+	var line []byte
+	for {
+		part, err := r.ReadBytes('\n')
+		line = append(line, part...)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		atEOF := errors.Is(err, io.EOF)
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// Empty lines are skipped, as csv.Reader does
+			if atEOF {
+				return nil, io.EOF
+			}
+			line = line[:0]
+			continue
+		}
+		if atEOF || bytes.Count(line, []byte{'"'})%2 == 0 {
+			break
+		}
+	}
+
+	cr := csv.NewReader(bytes.NewReader(line))
+	cr.Comma = sep
+	return cr.Read()
+}
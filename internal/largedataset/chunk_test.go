@@ -0,0 +1,85 @@
+package largedataset
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRecordBoundary(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		atEOF       bool
+		wantEnd     int
+		wantRecords int
+	}{
+		{"whole records", "a,1\nb,2\n", false, 8, 2},
+		{"partial record", "a,1\nb,", false, 4, 1},
+		{"partial record at EOF", "a,1\nb,", true, 6, 2},
+		{"quoted newline", "a,\"x\ny\"\nb", false, 8, 1},
+		{"open quote", "a,\"x\ny", false, 0, 0},
+		{"empty lines", "\n\r\na,1\r\n\n", false, 9, 1},
+		{"escaped quotes", "\"a\"\"\n\",1\n", false, 9, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end, records := recordBoundary([]byte(tt.data), tt.atEOF)
+			if end != tt.wantEnd || records != tt.wantRecords {
+				t.Errorf("recordBoundary() = %d, %d, want %d, %d", end, records, tt.wantEnd, tt.wantRecords)
+			}
+		})
+	}
+}
+
+func TestChunkReader(t *testing.T) {
+	data := "1,a\n2,\"multi\nline\"\n\n3,c\n4,\"" + strings.Repeat("x", 40) + "\"\n5,e"
+	reader := newChunkReader(strings.NewReader(data), 8)
+
+	var chunks []*Chunk
+	var joined strings.Builder
+	for {
+		chunk, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		chunks = append(chunks, chunk)
+		joined.Write(chunk.Data)
+	}
+
+	if joined.String() != data {
+		t.Errorf("chunks do not add up to the input: %q", joined.String())
+	}
+	for i, chunk := range chunks {
+		if chunk.Index != i {
+			t.Errorf("chunk %d has index %d", i, chunk.Index)
+		}
+		row, err := fieldCount(chunk.Data, ',')
+		if err != nil || row != 2 {
+			t.Errorf("chunk %d does not start with a whole record: %q", i, chunk.Data)
+		}
+	}
+	last := chunks[len(chunks)-1]
+	if last.FirstRow != 4 || !strings.HasSuffix(string(last.Data), "\n5,e") {
+		t.Errorf("unexpected last chunk: %+v", last)
+	}
+}
+
+func TestReadRecord(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\nName,\"Multi\nLine\"\n1,2\n"))
+	header, err := ReadRecord(r, ',')
+	if err != nil || len(header) != 2 || header[1] != "Multi\nLine" {
+		t.Fatalf("ReadRecord() = %q, %v", header, err)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "1,2\n" {
+		t.Errorf("expected the rest of the input to be left, got %q", rest)
+	}
+	if _, err := ReadRecord(bufio.NewReader(strings.NewReader("\n\n")), ','); err != io.EOF {
+		t.Errorf("error = %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,63 @@
+package largedataset
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Column is a resolved column reference.
+type Column struct {
+	Index int
+	Name  string
+}
+
+// ResolveColumns resolves each of refs with ResolveColumn.
+func ResolveColumns(refs []string, header []string) ([]Column, error) {
+	columns := make([]Column, 0, len(refs))
+	for _, ref := range refs {
+		index, name, err := ResolveColumn(ref, header)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, Column{Index: index, Name: name})
+	}
+	return columns, nil
+}
+
+// ResolveColumn returns the index and display name of the column referred to
+// by ref, either a 0-based index or a header name matched case-insensitively.
+// Columns without a name in the header are named col_<index>.
+func ResolveColumn(ref string, header []string) (int, string, error) {
+	ref = strings.TrimSpace(ref)
+	if idx, err := strconv.Atoi(ref); err == nil {
+		if idx < 0 {
+			return 0, "", fmt.Errorf("negative column index %d", idx)
+		}
+		if idx < len(header) && header[idx] != "" {
+			return idx, header[idx], nil
+		}
+		return idx, fmt.Sprintf("col_%d", idx), nil
+	}
+
+	if header == nil {
+		return 0, "", fmt.Errorf("column name %q used but no header provided", ref)
+	}
+	for i, name := range header {
+		if strings.EqualFold(name, ref) {
+			return i, name, nil
+		}
+	}
+	return 0, "", fmt.Errorf("column %q not found in header", ref)
+}
+
+// findMeasure returns the position among measures of the measure of column
+// index, -1 if the column is not a measure.
+func findMeasure(measures []Measure, index int) int {
+	for i, m := range measures {
+		if m.Index == index {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,35 @@
+package largedataset
+
+import "testing"
+
+func TestResolveColumn(t *testing.T) {
+	header := []string{"Symbol", "", "Price"}
+
+	tests := []struct {
+		ref       string
+		header    []string
+		wantIndex int
+		wantName  string
+		wantErr   bool
+	}{
+		{"Price", header, 2, "Price", false},
+		{"price", header, 2, "Price", false},
+		{" 0 ", header, 0, "Symbol", false},
+		{"1", header, 1, "col_1", false},
+		{"5", header, 5, "col_5", false},
+		{"8", nil, 8, "col_8", false},
+		{"Volume", header, 0, "", true},
+		{"Price", nil, 0, "", true},
+		{"-1", header, 0, "", true},
+	}
+	for _, tt := range tests {
+		index, name, err := ResolveColumn(tt.ref, tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ResolveColumn(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (index != tt.wantIndex || name != tt.wantName) {
+			t.Errorf("ResolveColumn(%q) = %d, %q, want %d, %q", tt.ref, index, name, tt.wantIndex, tt.wantName)
+		}
+	}
+}
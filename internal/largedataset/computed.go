@@ -0,0 +1,125 @@
+package largedataset
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Computed is a column whose values are computed from the other columns of
+// each record.
+type Computed struct {
+	Name string
+	Expr Expr
+}
+
+func (c Computed) String() string {
+	return c.Name + " = " + c.Expr.String()
+}
+
+// ParseComputed parses a "Name = expression" computed column, see ParseExpr.
+func ParseComputed(spec string, header []string) (Computed, error) {
+	name, src, ok := strings.Cut(spec, "=")
+	if !ok {
+		return Computed{}, fmt.Errorf("invalid computed column %q, expected Name = expression", spec)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Computed{}, fmt.Errorf("missing computed column name in %q", spec)
+	}
+	for _, col := range header {
+		if strings.EqualFold(col, name) {
+			return Computed{}, fmt.Errorf("computed column %q already exists", name)
+		}
+	}
+	expr, err := ParseExpr(src, header)
+	if err != nil {
+		return Computed{}, fmt.Errorf("invalid expression of %s: %w", name, err)
+	}
+	return Computed{Name: name, Expr: expr}, nil
+}
+
+// ParseComputedColumns parses specs with ParseComputed, each computed column
+// being able to use the previous ones. It returns them and header followed
+// by their names, which filters, group-bys and measures can refer to.
+func ParseComputedColumns(specs []string, header []string) ([]Computed, []string, error) {
+	computed := make([]Computed, 0, len(specs))
+	for _, spec := range specs {
+		c, err := ParseComputed(spec, header)
+		if err != nil {
+			return nil, nil, err
+		}
+		computed = append(computed, c)
+		header = append(header[:len(header):len(header)], c.Name)
+	}
+	return computed, header, nil
+}
+
+// Record is a CSV record followed by the values of the computed columns,
+// NaN when they can't be computed, e.g. for a division by zero. Computed
+// values stay numbers for the expressions, filters and measures, and are
+// only formatted for the extract and the group keys.
+type Record struct {
+	Fields   []string
+	Computed []float64
+}
+
+// Len returns the number of columns of r, computed ones included.
+func (r Record) Len() int {
+	return len(r.Fields) + len(r.Computed)
+}
+
+// computed returns the value of the column at index when it is a computed
+// one.
+func (r Record) computed(index int) (float64, bool) {
+	if index < len(r.Fields) || index >= r.Len() {
+		return 0, false
+	}
+	return r.Computed[index-len(r.Fields)], true
+}
+
+// Text returns the column at index, which must be below Len, as a string.
+func (r Record) Text(index int) string {
+	if v, ok := r.computed(index); ok {
+		return formatComputed(v)
+	}
+	return r.Fields[index]
+}
+
+// Strings returns the fields of r followed by its formatted computed values.
+func (r Record) Strings() []string {
+	if len(r.Computed) == 0 {
+		return r.Fields
+	}
+	out := make([]string, len(r.Fields), r.Len())
+	copy(out, r.Fields)
+	for _, v := range r.Computed {
+		out = append(out, formatComputed(v))
+	}
+	return out
+}
+
+// formatComputed formats a computed value, empty when it is missing.
+func formatComputed(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	// 12 significant digits drop the noise of float arithmetic, such as
+	// 5.57000000000005 for 323.22 - 317.65
+	return strconv.FormatFloat(v, 'g', 12, 64)
+}
+
+// computeValues returns the values of computed for record, each column
+// being able to use the previous ones.
+func computeValues(record []string, computed []Computed) []float64 {
+	values := make([]float64, 0, len(computed))
+	for _, c := range computed {
+		v, err := c.Expr.Eval(Record{Fields: record, Computed: values})
+		if err != nil || math.IsInf(v, 0) {
+			v = math.NaN()
+		}
+		values = append(values, v)
+	}
+	return values
+}
@@ -2,44 +2,146 @@ package largedataset
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 )
 
 // LogicalRow represents a cleaned / typed version of a CSV row.
-// For now we only care about a single numeric column: amount.
 type LogicalRow struct {
-	RawRecord []string // keep the original record if needed
-	Amount    float64  // parsed numeric column
-	GroupKey  string   // optional group-by key
+	Row       int       // Row number in the file, from 1, header excluded
+	RawRecord []string  // keep the original record if needed
+	Computed  []float64 // values of the computed columns following RawRecord
+	Values    []float64 // parsed measures, in the order of RowParser.Measures
+	GroupKey  []string  // values of the group-by columns, nil when not grouped
 }
 
-func ParseLogicalRow(record []string) (*LogicalRow, error) {
-	return ParseLogicalRowWithGroupBy(record, -1)
+// record returns the raw record and the computed values of r.
+func (r *LogicalRow) record() Record {
+	return Record{Fields: r.RawRecord, Computed: r.Computed}
 }
 
-func ParseLogicalRowWithGroupBy(record []string, groupByIndex int) (*LogicalRow, error) {
-	const amountIndex = 8
+// value returns the raw value of col, false if the record is too short.
+// Computed values are formatted.
+func (r *LogicalRow) value(col Column) (string, bool) {
+	rec := r.record()
+	if col.Index >= rec.Len() {
+		return "", false
+	}
+	return rec.Text(col.Index), true
+}
+
+// number returns the value of col parsed as a float. Computed values are
+// returned as is.
+func (r *LogicalRow) number(col Column) (float64, error) {
+	if v, ok := r.record().computed(col.Index); ok && !math.IsNaN(v) {
+		return v, nil
+	}
+	raw, _ := r.value(col)
+	return strconv.ParseFloat(raw, 64)
+}
 
-	if len(record) <= amountIndex {
-		return nil, fmt.Errorf("not enough columns, expected index %d", amountIndex)
+// Measure is a numeric column whose statistics are computed.
+type Measure struct {
+	Index int
+	Name  string
+	Type  ColumnType // TypeInt or TypeFloat
+}
+
+// DefaultMeasure is the Price column of the generated stock data.
+const DefaultMeasure = "8"
+
+// ParseMeasures resolves the measure columns referred to by refs. Their type
+// is TypeInt when schema declares so, TypeFloat otherwise. schema may be nil.
+func ParseMeasures(refs []string, header []string, schema *CSVSchema) ([]Measure, error) {
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("at least one measure is required")
 	}
+	measures := make([]Measure, 0, len(refs))
+	for _, ref := range refs {
+		index, name, err := ResolveColumn(ref, header)
+		if err != nil {
+			return nil, fmt.Errorf("invalid measure: %w", err)
+		}
+		m := Measure{Index: index, Name: name, Type: TypeFloat}
+		if schema != nil {
+			for _, col := range schema.Columns {
+				if col.Index == index && col.Type == TypeInt {
+					m.Type = TypeInt
+				}
+			}
+		}
+		measures = append(measures, m)
+	}
+	return measures, nil
+}
 
-	rawAmount := record[amountIndex]
+// Parse returns the value of the measure in record.
+func (m Measure) Parse(record []string) (float64, error) {
+	return m.ParseRecord(Record{Fields: record})
+}
 
-	// Try to parse the amount as float64
-	amount, err := strconv.ParseFloat(rawAmount, 64)
+// ParseRecord returns the value of the measure in r, computed values being
+// taken as is.
+func (m Measure) ParseRecord(r Record) (float64, error) {
+	if m.Index >= r.Len() {
+		return 0, fmt.Errorf("not enough columns, expected index %d (%s)", m.Index, m.Name)
+	}
+	if v, ok := r.computed(m.Index); ok {
+		if math.IsNaN(v) {
+			return 0, fmt.Errorf("missing %s, it could not be computed", m.Name)
+		}
+		return v, nil
+	}
+	raw := r.Fields[m.Index]
+	if m.Type == TypeInt {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %w", m.Name, raw, err)
+		}
+		return float64(v), nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
 	if err != nil {
-		return nil, fmt.Errorf("invalid amount %q: %w", rawAmount, err)
+		return 0, fmt.Errorf("invalid %s %q: %w", m.Name, raw, err)
 	}
+	return v, nil
+}
 
+// RowParser turns CSV records into logical rows.
+type RowParser struct {
+	Measures []Measure
+	GroupBy  []Column // Columns of the group-by key, none to disable
+}
+
+func (p *RowParser) Parse(record []string) (*LogicalRow, error) {
+	return p.ParseRecord(Record{Fields: record})
+}
+
+// ParseRecord is Parse for a record followed by computed columns.
+func (p *RowParser) ParseRecord(r Record) (*LogicalRow, error) {
 	logicalRow := &LogicalRow{
-		RawRecord: record,
-		Amount:    amount,
+		RawRecord: r.Fields,
+		Computed:  r.Computed,
+		Values:    make([]float64, len(p.Measures)),
+	}
+	for i, m := range p.Measures {
+		v, err := m.ParseRecord(r)
+		if err != nil {
+			return nil, err
+		}
+		logicalRow.Values[i] = v
 	}
 
-	// Extract group-by key if enabled
-	if groupByIndex >= 0 && groupByIndex < len(record) {
-		logicalRow.GroupKey = record[groupByIndex]
+	// Extract group-by key if enabled, rows lacking a column are not grouped
+	if len(p.GroupBy) > 0 {
+		key := make([]string, len(p.GroupBy))
+		for i, col := range p.GroupBy {
+			if col.Index >= r.Len() {
+				return logicalRow, nil
+			}
+			key[i] = r.Text(col.Index)
+		}
+		logicalRow.GroupKey = key
 	}
 
 	return logicalRow, nil
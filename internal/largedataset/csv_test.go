@@ -0,0 +1,105 @@
+package largedataset
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+var stockHeader = []string{
+	"TradeID", "Timestamp", "Symbol", "Exchange", "Sector", "TradeType", "OrderType", "Quantity",
+	"Price", "TotalValue", "OpenPrice", "ClosePrice", "HighPrice", "LowPrice", "Volume", "MarketCap",
+	"PERatio", "DividendYield", "Beta", "52WeekHigh", "52WeekLow", "ChangePercent",
+}
+
+func TestParseMeasures(t *testing.T) {
+	measures, err := ParseMeasures([]string{"Price", "14"}, stockHeader, NewStockDataSchema())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Measure{{Index: 8, Name: "Price", Type: TypeFloat}, {Index: 14, Name: "Volume", Type: TypeInt}}
+	for i := range want {
+		if measures[i] != want[i] {
+			t.Errorf("measure %d = %+v, want %+v", i, measures[i], want[i])
+		}
+	}
+
+	if measures, _ := ParseMeasures([]string{"Volume"}, stockHeader, nil); measures[0].Type != TypeFloat {
+		t.Errorf("expected a float measure without schema, got %v", measures[0].Type)
+	}
+	if _, err := ParseMeasures(nil, stockHeader, nil); err == nil {
+		t.Error("expected an error without measure")
+	}
+	if _, err := ParseMeasures([]string{"Spread"}, stockHeader, nil); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
+
+func TestRowParser_Parse(t *testing.T) {
+	parser := &RowParser{
+		Measures: []Measure{{Index: 1, Name: "Price", Type: TypeFloat}, {Index: 2, Name: "Volume", Type: TypeInt}},
+		GroupBy:  []Column{{Index: 0, Name: "Symbol"}, {Index: 3, Name: "Exchange"}},
+	}
+
+	tests := []struct {
+		name       string
+		record     []string
+		wantValues []float64
+		wantErr    string
+	}{
+		{"valid", []string{"AAPL", "185.5", "1200", "NASDAQ"}, []float64{185.5, 1200}, ""},
+		{"float in int measure", []string{"AAPL", "185.5", "12.5"}, nil, "invalid Volume"},
+		{"missing column", []string{"AAPL", "185.5"}, nil, "not enough columns"},
+		{"invalid float", []string{"AAPL", "n/a", "1"}, nil, "invalid Price"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, err := parser.Parse(tt.record)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want mention of %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(row.GroupKey, "/") != "AAPL/NASDAQ" || row.Values[0] != tt.wantValues[0] || row.Values[1] != tt.wantValues[1] {
+				t.Errorf("unexpected row: %+v", row)
+			}
+		})
+	}
+}
+
+func TestRowParser_MissingGroupColumn(t *testing.T) {
+	parser := &RowParser{
+		Measures: []Measure{{Index: 0, Name: "Price", Type: TypeFloat}},
+		GroupBy:  []Column{{Index: 0, Name: "Price"}, {Index: 4, Name: "Sector"}},
+	}
+	row, err := parser.Parse([]string{"10", "x"})
+	if err != nil || row.GroupKey != nil {
+		t.Errorf("expected an ungrouped row, got %+v, %v", row, err)
+	}
+}
+
+func TestGlobalAmountAggregator_Tables(t *testing.T) {
+	measures := []Measure{{Index: 0, Name: "Price", Type: TypeFloat}, {Index: 1, Name: "Volume", Type: TypeInt}}
+	agg := NewGlobalAmountAggregator(measures)
+	if notes := agg.Tables()[0].Notes; len(notes) != 1 {
+		t.Errorf("expected a note without data, got %q", notes)
+	}
+	agg.Consume(&LogicalRow{Values: []float64{10.5, 100}})
+	agg.Consume(&LogicalRow{Values: []float64{20, 300}})
+
+	rows := agg.Tables()[0].Rows
+	if len(rows) != 2 {
+		t.Fatalf("expected a row per measure, got %v", rows)
+	}
+	// Measure, Count, Sum, Min, Max, Average, Variance, StdDev, P50
+	if want := []any{"Price", int64(2), 30.5, 10.5, 20.0, 15.25, 45.125}; !slices.Equal(rows[0][:7], want) {
+		t.Errorf("Price row = %v, want %v", rows[0], want)
+	}
+	if want := []any{"Volume", int64(2), int64(400), int64(100), int64(300), 200.0, 20000.0}; !slices.Equal(rows[1][:7], want) {
+		t.Errorf("Volume row = %v, want %v", rows[1], want)
+	}
+}
@@ -0,0 +1,119 @@
+package largedataset
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision is the number of hash bits selecting a HyperLogLog register.
+// 2^14 registers take 16 KiB and give a standard error of 1.04 / sqrt(2^14),
+// about 0.81%.
+const hllPrecision = 14
+
+// HyperLogLog estimates the number of distinct values of a stream in a fixed
+// amount of memory. Sketches can be merged, counting the union of their
+// streams.
+type HyperLogLog struct {
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+// StdError returns the relative standard error of the estimates.
+func (h *HyperLogLog) StdError() float64 {
+	return 1.04 / math.Sqrt(float64(len(h.registers)))
+}
+
+func (h *HyperLogLog) Add(value string) {
+	x := hashString(value)
+	i := x >> (64 - hllPrecision)
+	// Rank of the first set bit of the remaining bits, the register index
+	// bits being shifted out
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// Merge adds the values of o to the sketch.
+func (h *HyperLogLog) Merge(o *HyperLogLog) {
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// Estimate returns the estimated number of distinct values.
+func (h *HyperLogLog) Estimate() float64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Small cardinalities are better estimated by linear counting
+		return m * math.Log(m/float64(zeros))
+	}
+	return estimate
+}
+
+// hashString returns a 64-bit hash of s: FNV-1a, whose low quality bits are
+// mixed with the splitmix64 finalizer. Unlike hash/maphash, it is the same
+// across runs.
+func hashString(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// DistinctAggregator estimates the number of distinct values of a column.
+type DistinctAggregator struct {
+	Column Column
+	sketch *HyperLogLog
+}
+
+func NewDistinctAggregator(column Column) *DistinctAggregator {
+	return &DistinctAggregator{Column: column, sketch: NewHyperLogLog()}
+}
+
+func (d *DistinctAggregator) Consume(row *LogicalRow) {
+	if v, ok := row.value(d.Column); ok {
+		d.sketch.Add(v)
+	}
+}
+
+func (d *DistinctAggregator) Merge(other Aggregator) {
+	d.sketch.Merge(other.(*DistinctAggregator).sketch)
+}
+
+// Estimate returns the estimated number of distinct values.
+func (d *DistinctAggregator) Estimate() int64 {
+	return int64(math.Round(d.sketch.Estimate()))
+}
+
+func (d *DistinctAggregator) Tables() []*Table {
+	stdErr := d.sketch.StdError()
+	return []*Table{{
+		Title:   fmt.Sprintf("Distinct values of %s", d.Column.Name),
+		Columns: []string{"Column", "Distinct", "StdErrorPercent"},
+		Rows:    [][]any{{d.Column.Name, d.Estimate(), 100 * stdErr}},
+		Notes: []string{fmt.Sprintf("HyperLogLog estimate, standard error %.2f%%: within ±%.2f%% 95%% of the time",
+			100*stdErr, 200*stdErr)},
+	}}
+}
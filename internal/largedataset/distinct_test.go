@@ -0,0 +1,65 @@
+package largedataset
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHyperLogLog_Estimate(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 5000, 200000} {
+		h := NewHyperLogLog()
+		for i := range n {
+			h.Add("value-" + strconv.Itoa(i))
+			h.Add("value-" + strconv.Itoa(i)) // Duplicates do not count
+		}
+		got := h.Estimate()
+		// 4 standard errors, which estimates practically never exceed
+		if math.Abs(got-float64(n)) > 4*h.StdError()*float64(n) {
+			t.Errorf("Estimate() of %d values = %v", n, got)
+		}
+	}
+}
+
+func TestHyperLogLog_Merge(t *testing.T) {
+	a, b, union := NewHyperLogLog(), NewHyperLogLog(), NewHyperLogLog()
+	for i := range 30000 {
+		v := strconv.Itoa(i)
+		if i < 20000 {
+			a.Add(v)
+		}
+		if i >= 10000 {
+			b.Add(v)
+		}
+		union.Add(v)
+	}
+	a.Merge(b)
+	if a.Estimate() != union.Estimate() {
+		t.Errorf("merged estimate = %v, want the union estimate %v", a.Estimate(), union.Estimate())
+	}
+}
+
+func TestDistinctAggregator(t *testing.T) {
+	col := Column{Index: 1, Name: "Symbol"}
+	a, b := NewDistinctAggregator(col), NewDistinctAggregator(col)
+	for i, symbol := range []string{"AAPL", "MSFT", "AAPL", "KO", "MSFT", "TSLA"} {
+		agg := a
+		if i%2 == 1 {
+			agg = b
+		}
+		agg.Consume(&LogicalRow{RawRecord: []string{strconv.Itoa(i), symbol}})
+	}
+	a.Consume(&LogicalRow{RawRecord: []string{"short"}})
+	a.Merge(b)
+
+	if a.Estimate() != 4 {
+		t.Errorf("Estimate() = %d, want 4", a.Estimate())
+	}
+	out := renderText(a.Tables())
+	for _, want := range []string{"Distinct values of Symbol", "Symbol  4         0.81", "standard error 0.81%"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report does not contain %q:\n%s", want, out)
+		}
+	}
+}
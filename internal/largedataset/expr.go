@@ -0,0 +1,409 @@
+package largedataset
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is an arithmetic expression over the columns of a record.
+type Expr interface {
+	Eval(r Record) (float64, error)
+	String() string
+}
+
+// ParseExpr parses an arithmetic expression: numbers, columns, the + - * /
+// operators, parentheses and the functions of exprFuncs, e.g.
+// "(ClosePrice - OpenPrice) / OpenPrice * 100".
+//
+// Columns are resolved against header with ResolveColumn, as names, names
+// quoted with backticks when they hold spaces or operators, or 0-based
+// indexes prefixed with $, e.g. $8.
+func ParseExpr(src string, header []string) (Expr, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, header: header}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEnd {
+		return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+	}
+	return e, nil
+}
+
+// exprFuncs are the functions expressions can call, with their number of
+// arguments.
+var exprFuncs = map[string]struct {
+	args int
+	fn   func(args []float64) float64
+}{
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"log":   {1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"exp":   {1, func(a []float64) float64 { return math.Exp(a[0]) }},
+	"round": {1, func(a []float64) float64 { return math.Round(a[0]) }},
+	"floor": {1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"ceil":  {1, func(a []float64) float64 { return math.Ceil(a[0]) }},
+	"pow":   {2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+}
+
+type tokenKind int
+
+const (
+	tokEnd tokenKind = iota
+	tokNumber
+	tokIdent  // Column or function name
+	tokColumn // $index or `quoted name`
+	tokOp     // + - * / ( ) ,
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t exprToken) String() string {
+	if t.kind == tokEnd {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// isIdentRune reports whether r can be part of a name or a number. Numbers
+// and names are told apart once read, names such as 52WeekHigh starting
+// with digits.
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.'
+}
+
+func tokenizeExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("+-*/(),", r):
+			tokens = append(tokens, exprToken{kind: tokOp, text: string(r), pos: i})
+			i++
+		case r == '`':
+			end := i + 1
+			for end < len(runes) && runes[end] != '`' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated quoted column at position %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: tokColumn, text: string(runes[i+1 : end]), pos: i})
+			i = end + 1
+		case r == '$':
+			end := i + 1
+			for end < len(runes) && unicode.IsDigit(runes[end]) {
+				end++
+			}
+			if end == i+1 {
+				return nil, fmt.Errorf("missing column index after $ at position %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: tokColumn, text: string(runes[i+1 : end]), pos: i})
+			i = end
+		case isIdentRune(r):
+			// Numbers may have a signed exponent, names such as 52WeekHigh
+			// may start with digits
+			if end := scanNumber(runes, i); end > i && (end == len(runes) || !isIdentRune(runes[end])) {
+				tokens = append(tokens, exprToken{kind: tokNumber, text: string(runes[i:end]), pos: i})
+				i = end
+				continue
+			}
+			end := i
+			for end < len(runes) && isIdentRune(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: tokIdent, text: string(runes[i:end]), pos: i})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", r, i)
+		}
+	}
+	return append(tokens, exprToken{kind: tokEnd, pos: len(runes)}), nil
+}
+
+// scanNumber returns the end of the number starting at runes[i], such as 12,
+// .5 or 2.5e-3, or i when there is none.
+func scanNumber(runes []rune, i int) int {
+	digits := func(j int) int {
+		for j < len(runes) && unicode.IsDigit(runes[j]) {
+			j++
+		}
+		return j
+	}
+	end := digits(i)
+	if end < len(runes) && runes[end] == '.' {
+		end = digits(end + 1)
+	}
+	if end == i || end == i+1 && runes[i] == '.' {
+		return i
+	}
+	if end < len(runes) && (runes[end] == 'e' || runes[end] == 'E') {
+		exp := end + 1
+		if exp < len(runes) && (runes[exp] == '+' || runes[exp] == '-') {
+			exp++
+		}
+		if last := digits(exp); last > exp {
+			end = last
+		}
+	}
+	return end
+}
+
+// exprParser is a recursive descent parser of:
+//
+//	expr    = term {("+" | "-") term}
+//	term    = unary {("*" | "/") unary}
+//	unary   = "-" unary | primary
+//	primary = number | column | name "(" expr {"," expr} ")" | "(" expr ")"
+type exprParser struct {
+	tokens []exprToken
+	header []string
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[0]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[0]
+	if tok.kind != tokEnd {
+		p.tokens = p.tokens[1:]
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the operator op.
+func (p *exprParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q, got %s at position %d", op, tok, tok.pos)
+	}
+	return nil
+}
+
+func (p *exprParser) expr() (Expr, error) {
+	return p.binary(p.term, "+", "-")
+}
+
+func (p *exprParser) term() (Expr, error) {
+	return p.binary(p.unary, "*", "/")
+}
+
+// binary parses operands separated by any of ops, left to right.
+func (p *exprParser) binary(operand func() (Expr, error), ops ...string) (Expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOp || !slices.Contains(ops, tok.text) {
+			return left, nil
+		}
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: tok.text[0], left: left, right: right}
+	}
+}
+
+func (p *exprParser) unary() (Expr, error) {
+	if p.accept("-") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &negExpr{operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (Expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		v, _ := strconv.ParseFloat(tok.text, 64)
+		return numberExpr(v), nil
+	case tokColumn:
+		return p.column(tok.text)
+	case tokIdent:
+		if p.accept("(") {
+			return p.call(tok)
+		}
+		return p.column(tok.text)
+	case tokOp:
+		if tok.text == "(" {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+}
+
+func (p *exprParser) column(ref string) (Expr, error) {
+	index, name, err := ResolveColumn(ref, p.header)
+	if err != nil {
+		return nil, err
+	}
+	return &columnExpr{Column{Index: index, Name: name}}, nil
+}
+
+// call parses the arguments of the function named by tok, whose opening
+// parenthesis has been read.
+func (p *exprParser) call(tok exprToken) (Expr, error) {
+	name := strings.ToLower(tok.text)
+	f, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at position %d", tok, tok.pos)
+	}
+	c := &callExpr{name: name, fn: f.fn}
+	for {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(c.args) != f.args {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name, f.args, len(c.args))
+	}
+	return c, nil
+}
+
+type numberExpr float64
+
+func (n numberExpr) Eval(Record) (float64, error) { return float64(n), nil }
+
+func (n numberExpr) String() string { return strconv.FormatFloat(float64(n), 'g', -1, 64) }
+
+type columnExpr struct {
+	col Column
+}
+
+func (c *columnExpr) Eval(r Record) (float64, error) {
+	if c.col.Index >= r.Len() {
+		return 0, fmt.Errorf("not enough columns, expected index %d (%s)", c.col.Index, c.col.Name)
+	}
+	if v, ok := r.computed(c.col.Index); ok {
+		if math.IsNaN(v) {
+			return 0, fmt.Errorf("missing %s", c.col.Name)
+		}
+		return v, nil
+	}
+	raw := r.Fields[c.col.Index]
+	v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", c.col.Name, raw)
+	}
+	return v, nil
+}
+
+func (c *columnExpr) String() string {
+	if strings.IndexFunc(c.col.Name, func(r rune) bool { return !isIdentRune(r) }) >= 0 {
+		return "`" + c.col.Name + "`"
+	}
+	return c.col.Name
+}
+
+type negExpr struct {
+	operand Expr
+}
+
+func (n *negExpr) Eval(r Record) (float64, error) {
+	v, err := n.operand.Eval(r)
+	return -v, err
+}
+
+func (n *negExpr) String() string { return "-" + n.operand.String() }
+
+type binaryExpr struct {
+	op          byte
+	left, right Expr
+}
+
+func (b *binaryExpr) Eval(r Record) (float64, error) {
+	left, err := b.left.Eval(r)
+	if err != nil {
+		return 0, err
+	}
+	right, err := b.right.Eval(r)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	default:
+		return left / right, nil
+	}
+}
+
+func (b *binaryExpr) String() string {
+	return fmt.Sprintf("(%s %c %s)", b.left, b.op, b.right)
+}
+
+type callExpr struct {
+	name string
+	fn   func([]float64) float64
+	args []Expr
+}
+
+func (c *callExpr) Eval(r Record) (float64, error) {
+	values := make([]float64, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.Eval(r)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = v
+	}
+	return c.fn(values), nil
+}
+
+func (c *callExpr) String() string {
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.String()
+	}
+	return c.name + "(" + strings.Join(args, ", ") + ")"
+}
@@ -0,0 +1,95 @@
+package largedataset
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	header := []string{"Symbol", "OpenPrice", "ClosePrice", "52WeekHigh", "Total Value", "Quantity"}
+	record := []string{"AAPL", "100", "110", "150", "2200", "20"}
+
+	tests := []struct {
+		src     string
+		want    float64
+		str     string
+		wantErr string
+	}{
+		{src: "ClosePrice - OpenPrice", want: 10, str: "(ClosePrice - OpenPrice)"},
+		{src: "(closeprice - OpenPrice) / OpenPrice * 100", want: 10, str: "(((ClosePrice - OpenPrice) / OpenPrice) * 100)"},
+		{src: "1 + 2 * 3 - -4", want: 11},
+		{src: "OpenPrice * 1e-5 + 2.5e+3 - .5E1", want: 2495.001, str: "(((OpenPrice * 1e-05) + 2500) - 5)"},
+		{src: "1e", wantErr: "not found"},
+		{src: "`Total Value` / Quantity", want: 110, str: "(`Total Value` / Quantity)"},
+		{src: "52WeekHigh - $2", want: 40, str: "(52WeekHigh - ClosePrice)"},
+		{src: "max(OpenPrice, ClosePrice) + abs(-2.5) + round(0.4)", want: 112.5},
+		{src: "sqrt(pow(3, 2) + 16)", want: 5},
+		{src: "Volume * 2", wantErr: "not found"},
+		{src: "Symbol * 2", wantErr: `invalid Symbol "AAPL"`},
+		{src: "median(OpenPrice)", wantErr: "unknown function"},
+		{src: "max(OpenPrice)", wantErr: "expects 2 arguments"},
+		{src: "(OpenPrice + 1", wantErr: `expected ")"`},
+		{src: "OpenPrice +", wantErr: "unexpected end of expression"},
+		{src: "OpenPrice % 2", wantErr: `unexpected '%'`},
+		{src: "OpenPrice 2", wantErr: `unexpected "2"`},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.src, header)
+		var got float64
+		if err == nil {
+			got, err = e.Eval(Record{Fields: record})
+		}
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: error = %v, want %q", tt.src, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.src, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%q = %g, want %g", tt.src, got, tt.want)
+		}
+		if tt.str != "" && e.String() != tt.str {
+			t.Errorf("%q: String() = %q, want %q", tt.src, e.String(), tt.str)
+		}
+	}
+}
+
+func TestParseComputedColumns(t *testing.T) {
+	header := []string{"Symbol", "OpenPrice", "ClosePrice"}
+	computed, extended, err := ParseComputedColumns([]string{"Change = ClosePrice - OpenPrice", "ChangePct = Change / OpenPrice * 100"}, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Symbol", "OpenPrice", "ClosePrice", "Change", "ChangePct"}; !slices.Equal(extended, want) || len(header) != 3 {
+		t.Errorf("header = %q, want %q", extended, want)
+	}
+	record := []string{"AAPL", "80", "100"}
+	if got := computeValues(record, computed); !slices.Equal(got, []float64{20, 25}) {
+		t.Errorf("computed = %v, want [20 25]", got)
+	}
+	r := Record{Fields: []string{"AAPL", "317.65", "323.22"}}
+	r.Computed = computeValues(r.Fields, computed)
+	if closePrice, openPrice := 323.22, 317.65; r.Computed[0] != closePrice-openPrice {
+		t.Errorf("Change = %v, want the exact difference", r.Computed[0])
+	}
+	if got := r.Strings(); got[3] != "5.57" {
+		t.Errorf("Change = %q, want 5.57 without float noise", got[3])
+	}
+	r = Record{Fields: []string{"AAPL", "0", "n/a"}}
+	r.Computed = computeValues(r.Fields, computed)
+	if got := r.Strings(); !slices.Equal(got[3:], []string{"", ""}) {
+		t.Errorf("values that can't be computed = %q, want empty", got[3:])
+	}
+
+	for _, spec := range []string{"Change", "= OpenPrice", "openprice = ClosePrice", "Gap = OpenPrice -"} {
+		if _, _, err := ParseComputedColumns([]string{spec}, header); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package largedataset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// ExtractFormats are the formats rows can be extracted to.
+var ExtractFormats = []string{"csv", "tsv", "jsonl", "parquet-lite"}
+
+// extractBatch is the number of rows encoded at once in serial mode, and
+// the size of the parquet-lite row groups.
+const extractBatch = 4096
+
+// Extract writes the rows kept by a pipeline, those passing the validation
+// and the filters, to W.
+type Extract struct {
+	W       io.Writer
+	Format  string   // One of ExtractFormats
+	Columns []Column // Columns written, nil for all of them
+	// Header holds the names of the input columns, nil if it has none: CSV
+	// and TSV extracts have no header either, and the other formats name
+	// the columns col_<index>. Unused when Columns are given.
+	Header []string
+
+	enc   rowEncoder
+	names []string
+	begun bool // Whether the beginning was written, for end to write anything
+}
+
+// rowEncoder encodes the rows of an extract format.
+type rowEncoder interface {
+	begin(w io.Writer, names []string) error
+	// rows encodes a batch of rows, batches being written in order.
+	rows(w io.Writer, rows [][]string) error
+	end(w io.Writer) error
+}
+
+// NewExtract checks format and returns an extract writing to w.
+func NewExtract(w io.Writer, format string, columns []Column, header []string) (*Extract, error) {
+	e := &Extract{W: w, Format: format, Columns: columns, Header: header}
+	switch format {
+	case "csv":
+		e.enc = csvEncoder{comma: ','}
+	case "tsv":
+		e.enc = csvEncoder{comma: '\t'}
+	case "jsonl":
+		e.enc = &jsonlEncoder{}
+	case "parquet-lite":
+		e.enc = &parquetLiteEncoder{}
+	default:
+		return nil, fmt.Errorf("unknown extract format %q (expected %s)", format, strings.Join(ExtractFormats, ", "))
+	}
+	return e, nil
+}
+
+// begin writes the beginning of the extract, the input having fields
+// columns.
+func (e *Extract) begin(fields int) error {
+	switch {
+	case e.Columns != nil:
+		for _, col := range e.Columns {
+			e.names = append(e.names, col.Name)
+		}
+	case e.Header != nil:
+		e.names = e.Header
+	case e.Format != "csv" && e.Format != "tsv":
+		for i := range fields {
+			e.names = append(e.names, fmt.Sprintf("col_%d", i))
+		}
+	}
+	if err := e.enc.begin(e.W, e.names); err != nil {
+		return err
+	}
+	e.begun = true
+	return nil
+}
+
+// project returns the written columns of record, empty when missing.
+func (e *Extract) project(record []string) []string {
+	if e.Columns == nil {
+		return record
+	}
+	row := make([]string, len(e.Columns))
+	for i, col := range e.Columns {
+		if col.Index < len(record) {
+			row[i] = record[col.Index]
+		}
+	}
+	return row
+}
+
+// encode writes rows to w in the extract format.
+func (e *Extract) encode(w io.Writer, rows [][]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return e.enc.rows(w, rows)
+}
+
+// end writes the end of the extract, if it was begun.
+func (e *Extract) end() error {
+	if !e.begun {
+		return nil
+	}
+	return e.enc.end(e.W)
+}
+
+type csvEncoder struct {
+	comma rune
+}
+
+func (c csvEncoder) begin(w io.Writer, names []string) error {
+	if names == nil {
+		return nil
+	}
+	return c.rows(w, [][]string{names})
+}
+
+func (c csvEncoder) rows(w io.Writer, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = c.comma
+	return cw.WriteAll(rows)
+}
+
+func (c csvEncoder) end(io.Writer) error {
+	return nil
+}
+
+// jsonlEncoder writes a JSON object per row, keyed by column names in the
+// column order.
+type jsonlEncoder struct {
+	keys [][]byte // JSON encoded names followed by a colon
+}
+
+func (j *jsonlEncoder) begin(w io.Writer, names []string) error {
+	for _, name := range names {
+		key, _ := json.Marshal(name)
+		j.keys = append(j.keys, append(key, ':'))
+	}
+	return nil
+}
+
+func (j *jsonlEncoder) rows(w io.Writer, rows [][]string) error {
+	var buf bytes.Buffer
+	for _, row := range rows {
+		buf.WriteByte('{')
+		for i, value := range row {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if i < len(j.keys) {
+				buf.Write(j.keys[i])
+			} else {
+				fmt.Fprintf(&buf, "\"col_%d\":", i)
+			}
+			v, _ := json.Marshal(value)
+			buf.Write(v)
+		}
+		buf.WriteString("}\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (j *jsonlEncoder) end(io.Writer) error {
+	return nil
+}
+
+// parquetLiteMagic starts and ends parquet-lite files.
+const parquetLiteMagic = "PQL1"
+
+// parquetLiteEncoder writes parquet-lite, a minimal columnar format: the
+// rows are stored in groups, column after column, so that readers can skip
+// the columns they don't need.
+//
+//	file   = magic header group* uvarint(0) magic
+//	header = uvarint(columns) string*         column names
+//	group  = uvarint(rows) block*             one block per column
+//	block  = uvarint(length) string*          the column values, length bytes
+//	string = uvarint(length) bytes
+type parquetLiteEncoder struct {
+	columns int
+}
+
+func (p *parquetLiteEncoder) begin(w io.Writer, names []string) error {
+	p.columns = len(names)
+	buf := []byte(parquetLiteMagic)
+	buf = binary.AppendUvarint(buf, uint64(len(names)))
+	for _, name := range names {
+		buf = appendString(buf, name)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func (p *parquetLiteEncoder) rows(w io.Writer, rows [][]string) error {
+	buf := binary.AppendUvarint(nil, uint64(len(rows)))
+	var block []byte
+	for col := range p.columns {
+		block = block[:0]
+		for _, row := range rows {
+			if col < len(row) {
+				block = appendString(block, row[col])
+			} else {
+				block = appendString(block, "")
+			}
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(block)))
+		buf = append(buf, block...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func (p *parquetLiteEncoder) end(w io.Writer) error {
+	_, err := w.Write(append(binary.AppendUvarint(nil, 0), parquetLiteMagic...))
+	return err
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// ReadParquetLite reads a whole parquet-lite file, returning its column
+// names and rows. Counts and lengths are checked against the data actually
+// read before allocating for them, a corrupt file fails rather than
+// exhausting memory.
+func ReadParquetLite(r io.Reader) ([]string, [][]string, error) {
+	br := bufio.NewReader(r)
+	if err := readMagic(br); err != nil {
+		return nil, nil, err
+	}
+	columns, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parquet-lite header: %w", err)
+	}
+	var names []string
+	for range columns {
+		name, err := readString(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parquet-lite header: %w", err)
+		}
+		names = append(names, name)
+	}
+
+	var rows [][]string
+	for {
+		count, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parquet-lite row group: %w", err)
+		}
+		if count == 0 {
+			break
+		}
+		var group [][]string
+		for col, name := range names {
+			length, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parquet-lite column %s: %w", name, err)
+			}
+			// Every value takes one byte at least
+			if count > length {
+				return nil, nil, fmt.Errorf("parquet-lite column %s: %d values in %d bytes", name, count, length)
+			}
+			block, err := readBytes(br, length)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parquet-lite column %s: %w", name, err)
+			}
+			if group == nil {
+				group = make([][]string, count)
+				for i := range group {
+					group[i] = make([]string, len(names))
+				}
+			}
+			values := bytes.NewReader(block)
+			for _, row := range group {
+				if row[col], err = readString(values); err != nil {
+					return nil, nil, fmt.Errorf("parquet-lite column %s: %w", name, err)
+				}
+			}
+			if values.Len() > 0 {
+				return nil, nil, fmt.Errorf("parquet-lite column %s: %d bytes left after the values", name, values.Len())
+			}
+		}
+		rows = append(rows, group...)
+	}
+	return names, rows, readMagic(br)
+}
+
+func readMagic(r io.Reader) error {
+	magic := make([]byte, len(parquetLiteMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != parquetLiteMagic {
+		return errors.New("not a parquet-lite file")
+	}
+	return nil
+}
+
+func readString(r interface {
+	io.Reader
+	io.ByteReader
+}) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	buf, err := readBytes(r, n)
+	return string(buf), err
+}
+
+// readBytes reads n bytes, growing the buffer as they come rather than
+// trusting n upfront.
+func readBytes(r io.Reader, n uint64) ([]byte, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, int64(min(n, math.MaxInt64))))
+	if err == nil && uint64(len(buf)) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf, err
+}
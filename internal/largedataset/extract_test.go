@@ -0,0 +1,111 @@
+package largedataset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtract_Formats(t *testing.T) {
+	rows := [][]string{{"AAPL", "Tech", "189.5"}, {"XOM", "Energy, Oil", "104"}}
+	symbolPrice := []Column{{Index: 0, Name: "Symbol"}, {Index: 2, Name: "Price"}}
+	header := []string{"Symbol", "Sector", "Price"}
+
+	tests := []struct {
+		name    string
+		format  string
+		columns []Column
+		header  []string
+		want    string
+	}{
+		{"csv", "csv", nil, header, "Symbol,Sector,Price\nAAPL,Tech,189.5\nXOM,\"Energy, Oil\",104\n"},
+		{"csv without header", "csv", nil, nil, "AAPL,Tech,189.5\nXOM,\"Energy, Oil\",104\n"},
+		{"tsv projected", "tsv", symbolPrice, header, "Symbol\tPrice\nAAPL\t189.5\nXOM\t104\n"},
+		{"jsonl projected", "jsonl", symbolPrice, header, "{\"Symbol\":\"AAPL\",\"Price\":\"189.5\"}\n{\"Symbol\":\"XOM\",\"Price\":\"104\"}\n"},
+		{"jsonl without header", "jsonl", nil, nil, "{\"col_0\":\"AAPL\",\"col_1\":\"Tech\",\"col_2\":\"189.5\"}\n{\"col_0\":\"XOM\",\"col_1\":\"Energy, Oil\",\"col_2\":\"104\"}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			e, err := NewExtract(&out, tt.format, tt.columns, tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := e.begin(3); err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range rows {
+				if err := e.encode(e.W, [][]string{e.project(row)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := e.end(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("extract = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+
+	if _, err := NewExtract(nil, "xlsx", nil, nil); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestExtract_ParquetLiteRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	e, _ := NewExtract(&buf, "parquet-lite", []Column{{Index: 2, Name: "Price"}, {Index: 0, Name: "Symbol"}}, nil)
+	e.begin(3)
+	e.encode(e.W, [][]string{e.project([]string{"AAPL", "Tech", "189.5"})})
+	e.encode(e.W, [][]string{e.project([]string{"XOM", "Energy"}), e.project([]string{"", "", "0"})})
+	e.end()
+
+	names, rows, err := ReadParquetLite(&buf)
+	if err != nil {
+		t.Fatalf("ReadParquetLite: %v", err)
+	}
+	if !slices.Equal(names, []string{"Price", "Symbol"}) {
+		t.Errorf("names = %q", names)
+	}
+	want := [][]string{{"189.5", "AAPL"}, {"", "XOM"}, {"0", ""}}
+	if !slices.EqualFunc(rows, want, slices.Equal) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+
+	if _, _, err := ReadParquetLite(strings.NewReader("PAR1")); err == nil {
+		t.Error("expected an error for a file with another magic")
+	}
+}
+
+func TestReadParquetLite_Corrupt(t *testing.T) {
+	header := func(names ...string) []byte {
+		buf := binary.AppendUvarint([]byte(parquetLiteMagic), uint64(len(names)))
+		for _, name := range names {
+			buf = appendString(buf, name)
+		}
+		return buf
+	}
+	huge := uint64(1) << 60
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"column count beyond the data", binary.AppendUvarint([]byte(parquetLiteMagic), huge)},
+		{"name length beyond the data", binary.AppendUvarint(binary.AppendUvarint([]byte(parquetLiteMagic), 1), huge)},
+		{"row count beyond the block", binary.AppendUvarint(binary.AppendUvarint(header("Price"), huge), 1)},
+		{"block length beyond the data", binary.AppendUvarint(binary.AppendUvarint(header("Price"), 1), huge)},
+		{"block longer than its values", append(binary.AppendUvarint(binary.AppendUvarint(header("Price", "Symbol"), 1), 3), 1, 'x', 'y')},
+		{"block shorter than its values", append(binary.AppendUvarint(binary.AppendUvarint(header("Price"), 2), 2), 1, 'x')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ReadParquetLite(bytes.NewReader(tt.data)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -45,6 +45,7 @@ const (
 // FilterExpression represents a filter expression tree
 type FilterExpression interface {
 	Evaluate(record []string) (bool, error)
+	EvaluateRecord(r Record) (bool, error)
 	String() string
 }
 
@@ -108,32 +109,12 @@ func ParseFilter(expr string, header []string) (*Filter, error) {
 	}
 
 	// Parse column name or index
-	if idx, err := strconv.Atoi(leftPart); err == nil {
-		// It's a column index
-		filter.ColumnIndex = idx
-		if header != nil && idx < len(header) {
-			filter.ColumnName = header[idx]
-		} else {
-			filter.ColumnName = fmt.Sprintf("col_%d", idx)
-		}
-	} else {
-		// It's a column name - find its index
-		if header == nil {
-			return nil, fmt.Errorf("column name %q used but no header provided", leftPart)
-		}
-		found := false
-		for i, name := range header {
-			if strings.EqualFold(name, leftPart) {
-				filter.ColumnIndex = i
-				filter.ColumnName = name
-				found = true
-				break
-			}
-		}
-		if !found {
-			return nil, fmt.Errorf("column %q not found in header", leftPart)
-		}
+	index, name, err := ResolveColumn(leftPart, header)
+	if err != nil {
+		return nil, err
 	}
+	filter.ColumnIndex = index
+	filter.ColumnName = name
 
 	// Parse value (remove quotes if present)
 	filter.Value = rightPart
@@ -164,11 +145,22 @@ func ParseFilter(expr string, header []string) (*Filter, error) {
 
 // Evaluate checks if a record matches the filter
 func (f *Filter) Evaluate(record []string) (bool, error) {
-	if f.ColumnIndex >= len(record) {
-		return false, fmt.Errorf("column index %d out of range (record has %d columns)", f.ColumnIndex, len(record))
+	return f.EvaluateRecord(Record{Fields: record})
+}
+
+// EvaluateRecord checks if a record and its computed columns match the
+// filter. Computed values are compared as numbers, without being formatted.
+func (f *Filter) EvaluateRecord(r Record) (bool, error) {
+	if f.ColumnIndex >= r.Len() {
+		return false, fmt.Errorf("column index %d out of range (record has %d columns)", f.ColumnIndex, r.Len())
+	}
+	if v, ok := r.computed(f.ColumnIndex); ok {
+		if match, ok := f.compare(v); ok {
+			return match, nil
+		}
 	}
 
-	cellValue := record[f.ColumnIndex]
+	cellValue := r.Text(f.ColumnIndex)
 
 	switch f.Operator {
 	case OpEqual:
@@ -236,6 +228,27 @@ func (f *Filter) Evaluate(record []string) (bool, error) {
 	}
 }
 
+// compare evaluates the numeric operators against v, a missing value (NaN)
+// being handled as an unparsable cell. It returns false for the other
+// operators.
+func (f *Filter) compare(v float64) (match, ok bool) {
+	switch {
+	case f.Operator == OpEqual && f.IsNumeric:
+		return v == f.NumValue, true
+	case f.Operator == OpNotEqual && f.IsNumeric:
+		return !(v == f.NumValue), true
+	case f.Operator == OpGreaterThan:
+		return v > f.NumValue, true
+	case f.Operator == OpGreaterThanOrEqual:
+		return v >= f.NumValue, true
+	case f.Operator == OpLessThan:
+		return v < f.NumValue, true
+	case f.Operator == OpLessThanOrEqual:
+		return v <= f.NumValue, true
+	}
+	return false, false
+}
+
 // String returns a human-readable representation of the filter
 func (f *Filter) String() string {
 	opStr := ""
@@ -269,6 +282,11 @@ func (fc *FilterCondition) Evaluate(record []string) (bool, error) {
 	return fc.Filter.Evaluate(record)
 }
 
+// EvaluateRecord for FilterCondition
+func (fc *FilterCondition) EvaluateRecord(r Record) (bool, error) {
+	return fc.Filter.EvaluateRecord(r)
+}
+
 // String for FilterCondition
 func (fc *FilterCondition) String() string {
 	return fc.Filter.String()
@@ -276,6 +294,11 @@ func (fc *FilterCondition) String() string {
 
 // Evaluate for FilterGroup
 func (fg *FilterGroup) Evaluate(record []string) (bool, error) {
+	return fg.EvaluateRecord(Record{Fields: record})
+}
+
+// EvaluateRecord for FilterGroup
+func (fg *FilterGroup) EvaluateRecord(r Record) (bool, error) {
 	if len(fg.Expressions) == 0 {
 		return true, nil
 	}
@@ -283,7 +306,7 @@ func (fg *FilterGroup) Evaluate(record []string) (bool, error) {
 	if fg.Operator == LogicalAND {
 		// All expressions must be true
 		for _, expr := range fg.Expressions {
-			match, err := expr.Evaluate(record)
+			match, err := expr.EvaluateRecord(r)
 			if err != nil {
 				return false, err
 			}
@@ -295,7 +318,7 @@ func (fg *FilterGroup) Evaluate(record []string) (bool, error) {
 	} else { // LogicalOR
 		// At least one expression must be true
 		for _, expr := range fg.Expressions {
-			match, err := expr.Evaluate(record)
+			match, err := expr.EvaluateRecord(r)
 			if err != nil {
 				return false, err
 			}
@@ -566,13 +589,19 @@ func splitByLogicalOperator(expr string, operator string) []string {
 
 // Evaluate checks if a record matches the filter expression tree
 func (fs *FilterSet) Evaluate(record []string) (bool, error) {
+	return fs.EvaluateRecord(Record{Fields: record})
+}
+
+// EvaluateRecord checks if a record and its computed columns match the
+// filter expression tree
+func (fs *FilterSet) EvaluateRecord(r Record) (bool, error) {
 	if fs.Root != nil {
-		return fs.Root.Evaluate(record)
+		return fs.Root.EvaluateRecord(r)
 	}
 
 	// Backward compatibility: use Filters array with AND logic
 	for _, filter := range fs.Filters {
-		match, err := filter.Evaluate(record)
+		match, err := filter.EvaluateRecord(r)
 		if err != nil {
 			return false, err
 		}
@@ -0,0 +1,249 @@
+package largedataset
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortMetrics are the statistics groups can be sorted by.
+var sortMetrics = map[string]func(*AmountStats) float64{
+	"count":  func(s *AmountStats) float64 { return float64(s.Count) },
+	"sum":    func(s *AmountStats) float64 { return s.Sum },
+	"min":    func(s *AmountStats) float64 { return s.Min },
+	"max":    func(s *AmountStats) float64 { return s.Max },
+	"avg":    (*AmountStats).Average,
+	"stddev": (*AmountStats).StdDev,
+	"p50":    func(s *AmountStats) float64 { return s.Percentile(50) },
+	"p90":    func(s *AmountStats) float64 { return s.Percentile(90) },
+	"p99":    func(s *AmountStats) float64 { return s.Percentile(99) },
+}
+
+// SortKey orders groups by a statistic of one of the measures.
+type SortKey struct {
+	Metric    string // A key of sortMetrics
+	Measure   int    // Index of the measure in the aggregated measures
+	Ascending bool
+}
+
+// ParseSortKey parses "metric" or "metric:measure", e.g. "avg:Price". The
+// measure is a name or index among the command line columns, the first
+// measure when omitted.
+func ParseSortKey(spec string, measures []Measure, header []string) (SortKey, error) {
+	metric, ref, hasMeasure := strings.Cut(spec, ":")
+	key := SortKey{Metric: strings.ToLower(strings.TrimSpace(metric))}
+	if _, ok := sortMetrics[key.Metric]; !ok {
+		return SortKey{}, fmt.Errorf("unknown sort metric %q (expected count, sum, min, max, avg, stddev, p50, p90 or p99)", metric)
+	}
+	if !hasMeasure {
+		return key, nil
+	}
+
+	index, name, err := ResolveColumn(ref, header)
+	if err != nil {
+		return SortKey{}, err
+	}
+	if key.Measure = findMeasure(measures, index); key.Measure < 0 {
+		return SortKey{}, fmt.Errorf("cannot sort by %s, it is not a measure", name)
+	}
+	return key, nil
+}
+
+// String describes the order, e.g. "sum of Price (descending)".
+func (k SortKey) String(measures []Measure) string {
+	order := "descending"
+	if k.Ascending {
+		order = "ascending"
+	}
+	return fmt.Sprintf("%s of %s (%s)", k.Metric, measures[k.Measure].Name, order)
+}
+
+func (k SortKey) sort(nodes []*groupNode) {
+	metric := sortMetrics[k.Metric]
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := metric(nodes[i].stats[k.Measure]), metric(nodes[j].stats[k.Measure])
+		if math.IsNaN(a) != math.IsNaN(b) {
+			return math.IsNaN(b) // Groups without the statistic come last
+		}
+		if a == b || math.IsNaN(a) {
+			return strings.Join(nodes[i].key, "\x1f") < strings.Join(nodes[j].key, "\x1f")
+		}
+		return (a < b) == k.Ascending
+	})
+}
+
+// groupNode holds the stats of the rows whose key starts with key. Its
+// children extend the key with the value of the next group-by column.
+type groupNode struct {
+	key      []string
+	stats    []*AmountStats
+	children map[string]*groupNode
+}
+
+func newGroupNode(key []string, measures []Measure) *groupNode {
+	return &groupNode{key: key, stats: newMeasureStats(measures), children: make(map[string]*groupNode)}
+}
+
+func (n *groupNode) sortedChildren(by SortKey) []*groupNode {
+	children := make([]*groupNode, 0, len(n.children))
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+	by.sort(children)
+	return children
+}
+
+// GroupByAggregator computes the stats of each distinct combination of the
+// group-by columns values. With Rollup, the report nests the groups column
+// after column with the subtotal of each level.
+type GroupByAggregator struct {
+	Rollup bool
+	SortBy SortKey // Sum of the first measure, descending, by default
+
+	measures []Measure
+	columns  []Column
+	root     *groupNode
+	groups   int
+}
+
+func NewGroupByAggregator(measures []Measure, columns []Column) *GroupByAggregator {
+	return &GroupByAggregator{
+		SortBy:   SortKey{Metric: "sum"},
+		measures: measures,
+		columns:  columns,
+		root:     newGroupNode(nil, measures),
+	}
+}
+
+func (g *GroupByAggregator) Consume(row *LogicalRow) {
+	if row.GroupKey == nil {
+		return
+	}
+
+	node := g.root
+	addValues(node.stats, row)
+	for i, value := range row.GroupKey {
+		child, ok := node.children[value]
+		if !ok {
+			child = newGroupNode(row.GroupKey[:i+1:i+1], g.measures)
+			node.children[value] = child
+			if i == len(row.GroupKey)-1 {
+				g.groups++
+			}
+		}
+		addValues(child.stats, row)
+		node = child
+	}
+}
+
+func (g *GroupByAggregator) Merge(other Aggregator) {
+	g.groups += mergeNode(g.root, other.(*GroupByAggregator).root)
+}
+
+// mergeNode merges the stats of from and its children into node, and returns
+// how many groups of full keys were added.
+func mergeNode(node, from *groupNode) int {
+	mergeStats(node.stats, from.stats)
+	added := 0
+	for value, fromChild := range from.children {
+		child, ok := node.children[value]
+		if !ok {
+			// Adopt the whole subtree
+			node.children[value] = fromChild
+			added += countLeaves(fromChild)
+			continue
+		}
+		added += mergeNode(child, fromChild)
+	}
+	return added
+}
+
+func countLeaves(n *groupNode) int {
+	if len(n.children) == 0 {
+		return 1
+	}
+	leaves := 0
+	for _, child := range n.children {
+		leaves += countLeaves(child)
+	}
+	return leaves
+}
+
+// Tables returns one row per group and measure, the groups being numbered
+// in the Rank column. With Rollup, the total and the subtotals come first,
+// the columns they aggregate over being empty, and ranks are numbered after
+// their parent, e.g. 2.1.
+func (g *GroupByAggregator) Tables() []*Table {
+	if g.groups == 0 {
+		return nil
+	}
+
+	names := make([]string, len(g.columns))
+	for i, col := range g.columns {
+		names[i] = col.Name
+	}
+	t := &Table{
+		Title:   "Group-by statistics",
+		Columns: append(append([]string{"Rank"}, names...), statsColumns()...),
+		Notes: []string{
+			fmt.Sprintf("Grouped by: %s", strings.Join(names, ", ")),
+			fmt.Sprintf("Number of groups: %d", g.groups),
+			fmt.Sprintf("Groups sorted by %s", g.SortBy.String(g.measures)),
+		},
+	}
+
+	if g.Rollup {
+		g.addRows(t, nil, g.root)
+		g.addNested(t, g.root, "")
+		return []*Table{t}
+	}
+	for i, leaf := range g.leaves() {
+		g.addRows(t, strconv.Itoa(i+1), leaf)
+	}
+	return []*Table{t}
+}
+
+// addRows adds the rows of node, one per measure.
+func (g *GroupByAggregator) addRows(t *Table, rank any, node *groupNode) {
+	for i, m := range g.measures {
+		row := []any{rank}
+		for j := range g.columns {
+			if j < len(node.key) {
+				row = append(row, node.key[j])
+			} else {
+				row = append(row, nil)
+			}
+		}
+		t.Rows = append(t.Rows, append(row, statsRow(m, node.stats[i])...))
+	}
+}
+
+// addNested adds the rows of the children of node, each followed by its own
+// children.
+func (g *GroupByAggregator) addNested(t *Table, node *groupNode, rank string) {
+	for i, child := range node.sortedChildren(g.SortBy) {
+		childRank := fmt.Sprintf("%s%d", rank, i+1)
+		g.addRows(t, childRank, child)
+		g.addNested(t, child, childRank+".")
+	}
+}
+
+// leaves returns the groups of full keys, sorted.
+func (g *GroupByAggregator) leaves() []*groupNode {
+	var leaves []*groupNode
+	var walk func(n *groupNode)
+	walk = func(n *groupNode) {
+		if len(n.children) == 0 {
+			leaves = append(leaves, n)
+			return
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(g.root)
+	g.SortBy.sort(leaves)
+	return leaves
+}
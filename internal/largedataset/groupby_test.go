@@ -0,0 +1,134 @@
+package largedataset
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseSortKey(t *testing.T) {
+	measures := []Measure{{Index: 8, Name: "Price"}, {Index: 14, Name: "Volume"}}
+
+	tests := []struct {
+		spec    string
+		want    SortKey
+		wantErr bool
+	}{
+		{"sum", SortKey{Metric: "sum"}, false},
+		{"AVG:Volume", SortKey{Metric: "avg", Measure: 1}, false},
+		{"max:14", SortKey{Metric: "max", Measure: 1}, false},
+		{"p90:Price", SortKey{Metric: "p90"}, false},
+		{"StdDev:Volume", SortKey{Metric: "stddev", Measure: 1}, false},
+		{"median", SortKey{}, true},
+		{"sum:Quantity", SortKey{}, true},
+		{"sum:Spread", SortKey{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSortKey(tt.spec, measures, stockHeader)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSortKey(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSortKey(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+// newTestGroupBy returns a group-by on Exchange and Sector fed with a few
+// rows whose single measure is Price.
+func newTestGroupBy() *GroupByAggregator {
+	g := NewGroupByAggregator(
+		[]Measure{{Index: 2, Name: "Price", Type: TypeFloat}},
+		[]Column{{Index: 0, Name: "Exchange"}, {Index: 1, Name: "Sector"}},
+	)
+	rows := []struct {
+		exchange, sector string
+		price            float64
+	}{
+		{"NYSE", "Tech", 10},
+		{"NYSE", "Energy", 50},
+		{"NYSE", "Tech", 30},
+		{"LSE", "Tech", 100},
+		{"LSE", "Energy", 1},
+	}
+	for _, r := range rows {
+		g.Consume(&LogicalRow{GroupKey: []string{r.exchange, r.sector}, Values: []float64{r.price}})
+	}
+	g.Consume(&LogicalRow{Values: []float64{1000}}) // not grouped
+	return g
+}
+
+// reportLabels returns the ranks and keys of the table rows, in order.
+func reportLabels(g *GroupByAggregator) []string {
+	var labels []string
+	for _, row := range g.Tables()[0].Rows {
+		rank := "Total"
+		if row[0] != nil {
+			rank = row[0].(string)
+		}
+		var key []string
+		for _, cell := range row[1:3] {
+			if cell != nil {
+				key = append(key, cell.(string))
+			}
+		}
+		labels = append(labels, strings.TrimSpace(fmt.Sprintf("[%s] %s", rank, strings.Join(key, " / "))))
+	}
+	return labels
+}
+
+func TestGroupByAggregator_Tables(t *testing.T) {
+	tests := []struct {
+		name   string
+		rollup bool
+		sortBy SortKey
+		want   []string
+	}{
+		{
+			name:   "by sum",
+			sortBy: SortKey{Metric: "sum"},
+			want:   []string{"[1] LSE / Tech", "[2] NYSE / Energy", "[3] NYSE / Tech", "[4] LSE / Energy"},
+		},
+		{
+			name:   "by count ascending",
+			sortBy: SortKey{Metric: "count", Ascending: true},
+			want:   []string{"[1] LSE / Energy", "[2] LSE / Tech", "[3] NYSE / Energy", "[4] NYSE / Tech"},
+		},
+		{
+			name:   "rollup",
+			rollup: true,
+			sortBy: SortKey{Metric: "avg"},
+			want: []string{
+				"[Total]",
+				"[1] LSE", "[1.1] LSE / Tech", "[1.2] LSE / Energy",
+				"[2] NYSE", "[2.1] NYSE / Energy", "[2.2] NYSE / Tech",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGroupBy()
+			g.Rollup = tt.rollup
+			g.SortBy = tt.sortBy
+			got := reportLabels(g)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("labels = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupByAggregator_Subtotals(t *testing.T) {
+	g := newTestGroupBy()
+	if g.groups != 4 {
+		t.Errorf("expected 4 groups, got %d", g.groups)
+	}
+	if total := g.root.stats[0]; total.Count != 5 || total.Sum != 191 {
+		t.Errorf("unexpected total: %+v", total)
+	}
+	nyse := g.root.children["NYSE"]
+	if nyse.stats[0].Sum != 90 || nyse.children["Tech"].stats[0].Average() != 20 {
+		t.Errorf("unexpected NYSE subtotals: %+v", nyse.stats[0])
+	}
+}
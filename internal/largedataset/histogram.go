@@ -0,0 +1,114 @@
+package largedataset
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Histogram describes the buckets of a histogram of a measure. Bounds
+// b1 < b2 < ... < bn make the buckets (-inf, b1), [b1, b2), ..., [bn, +inf).
+type Histogram struct {
+	Measure int // Index of the measure in the aggregated measures
+	Bounds  []float64
+}
+
+// ParseHistogram parses "measure:b1,b2,...", e.g. "Price:10,50,100". The
+// measure is a name or index among the command line columns.
+func ParseHistogram(spec string, measures []Measure, header []string) (Histogram, error) {
+	ref, list, ok := strings.Cut(spec, ":")
+	if !ok {
+		return Histogram{}, fmt.Errorf("invalid histogram %q (expected measure:bound,bound,...)", spec)
+	}
+	index, name, err := ResolveColumn(ref, header)
+	if err != nil {
+		return Histogram{}, err
+	}
+	hist := Histogram{Measure: findMeasure(measures, index)}
+	if hist.Measure < 0 {
+		return Histogram{}, fmt.Errorf("cannot draw a histogram of %s, it is not a measure", name)
+	}
+
+	for _, s := range strings.Split(list, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return Histogram{}, fmt.Errorf("invalid histogram bound %q", s)
+		}
+		if n := len(hist.Bounds); n > 0 && b <= hist.Bounds[n-1] {
+			return Histogram{}, fmt.Errorf("histogram bounds must be increasing, got %v after %v", b, hist.Bounds[n-1])
+		}
+		hist.Bounds = append(hist.Bounds, b)
+	}
+	return hist, nil
+}
+
+// HistogramAggregator counts the values of a measure falling in each bucket
+// of a histogram.
+type HistogramAggregator struct {
+	Histogram
+	measure Measure
+	counts  []int64 // One more than the bounds
+}
+
+func NewHistogramAggregator(measures []Measure, hist Histogram) *HistogramAggregator {
+	return &HistogramAggregator{
+		Histogram: hist,
+		measure:   measures[hist.Measure],
+		counts:    make([]int64, len(hist.Bounds)+1),
+	}
+}
+
+func (h *HistogramAggregator) Consume(row *LogicalRow) {
+	v := row.Values[h.Measure]
+	i, found := slices.BinarySearch(h.Bounds, v)
+	if found {
+		i++ // Buckets include their lower bound
+	}
+	h.counts[i]++
+}
+
+func (h *HistogramAggregator) Merge(other Aggregator) {
+	for i, c := range other.(*HistogramAggregator).counts {
+		h.counts[i] += c
+	}
+}
+
+// Counts returns the number of values in each bucket.
+func (h *HistogramAggregator) Counts() []int64 {
+	return h.counts
+}
+
+// Tables returns a row per bucket: its label, bounds (empty when infinite),
+// count and percentage of the values.
+func (h *HistogramAggregator) Tables() []*Table {
+	t := &Table{
+		Title:   fmt.Sprintf("Histogram of %s", h.measure.Name),
+		Columns: []string{"Bucket", "From", "To", "Count", "Percent"},
+	}
+	total := int64(0)
+	for _, c := range h.counts {
+		total += c
+	}
+	for i, c := range h.counts {
+		var bucket string
+		var from, to any
+		switch {
+		case i == 0:
+			bucket = "< " + formatMeasure(h.measure, h.Bounds[0])
+			to = measureValue(h.measure, h.Bounds[0])
+		case i == len(h.Bounds):
+			bucket = ">= " + formatMeasure(h.measure, h.Bounds[i-1])
+			from = measureValue(h.measure, h.Bounds[i-1])
+		default:
+			bucket = fmt.Sprintf("[%s, %s)", formatMeasure(h.measure, h.Bounds[i-1]), formatMeasure(h.measure, h.Bounds[i]))
+			from, to = measureValue(h.measure, h.Bounds[i-1]), measureValue(h.measure, h.Bounds[i])
+		}
+		percent := 0.0
+		if total > 0 {
+			percent = 100 * float64(c) / float64(total)
+		}
+		t.Rows = append(t.Rows, []any{bucket, from, to, c, percent})
+	}
+	return []*Table{t}
+}
@@ -0,0 +1,65 @@
+package largedataset
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseHistogram(t *testing.T) {
+	measures := []Measure{{Index: 8, Name: "Price"}, {Index: 14, Name: "Volume"}}
+
+	tests := []struct {
+		spec    string
+		want    Histogram
+		wantErr bool
+	}{
+		{"Price:10,50,100", Histogram{Measure: 0, Bounds: []float64{10, 50, 100}}, false},
+		{"volume: 0, 1e6", Histogram{Measure: 1, Bounds: []float64{0, 1e6}}, false},
+		{"14:5", Histogram{Measure: 1, Bounds: []float64{5}}, false},
+		{"Price", Histogram{}, true},
+		{"Price:10,abc", Histogram{}, true},
+		{"Price:50,10", Histogram{}, true},
+		{"Price:10,10", Histogram{}, true},
+		{"Quantity:10", Histogram{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHistogram(tt.spec, measures, stockHeader)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHistogram(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got.Measure != tt.want.Measure || !slices.Equal(got.Bounds, tt.want.Bounds) {
+			t.Errorf("ParseHistogram(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramAggregator(t *testing.T) {
+	measures := []Measure{{Index: 0, Name: "Quantity", Type: TypeInt}, {Index: 1, Name: "Price", Type: TypeFloat}}
+	hist := Histogram{Measure: 1, Bounds: []float64{10, 50}}
+	a, b := NewHistogramAggregator(measures, hist), NewHistogramAggregator(measures, hist)
+	for i, price := range []float64{5, 10, 49.99, 50, 70, 120, 9} {
+		agg := a
+		if i%2 == 1 {
+			agg = b
+		}
+		agg.Consume(&LogicalRow{Values: []float64{1, price}})
+	}
+	a.Merge(b)
+
+	if got := a.Counts(); !slices.Equal(got, []int64{2, 2, 3}) {
+		t.Errorf("Counts() = %v, want [2 2 3]", got)
+	}
+
+	rows := a.Tables()[0].Rows
+	want := [][]any{
+		{"< 10.00", nil, 10.0, int64(2), 100 * 2 / 7.0},
+		{"[10.00, 50.00)", 10.0, 50.0, int64(2), 100 * 2 / 7.0},
+		{">= 50.00", 50.0, nil, int64(3), 100 * 3 / 7.0},
+	}
+	for i := range want {
+		if !slices.Equal(rows[i], want[i]) {
+			t.Errorf("row %d = %v, want %v", i, rows[i], want[i])
+		}
+	}
+}
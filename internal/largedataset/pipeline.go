@@ -0,0 +1,500 @@
+package largedataset
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Counters sum up the rows seen by a pipeline.
+type Counters struct {
+	Total            int `json:"total"`             // Records read
+	Valid            int `json:"valid"`             // Rows aggregated
+	Invalid          int `json:"invalid"`           // Rows whose measures could not be parsed
+	ValidationErrors int `json:"validation_errors"` // Rows rejected by the schema
+	FilterErrors     int `json:"filter_errors"`     // Rows the filters could not be evaluated on
+	Filtered         int `json:"filtered"`          // Rows not matching the filters
+	Extracted        int `json:"extracted"`         // Rows written to the extract
+}
+
+func (c *Counters) add(o Counters) {
+	c.Total += o.Total
+	c.Valid += o.Valid
+	c.Invalid += o.Invalid
+	c.ValidationErrors += o.ValidationErrors
+	c.FilterErrors += o.FilterErrors
+	c.Filtered += o.Filtered
+	c.Extracted += o.Extracted
+}
+
+// Rejected returns the number of rows rejected as invalid.
+func (c Counters) Rejected() int {
+	return c.ValidationErrors + c.FilterErrors + c.Invalid
+}
+
+// Pipeline reads CSV records, validates them against Schema, keeps the ones
+// matching Filters, parses them with Parser and aggregates them. Computed
+// columns are appended to the records once validated.
+//
+// With more than one worker, the input is split into chunks at record
+// boundaries, each processed by a worker with its own aggregator, and the
+// aggregators are merged at the end.
+//
+// With an Extract, the rows passing the validation and the filters are also
+// written out, and with Rejects the invalid rows, in input order whatever the
+// number of workers.
+type Pipeline struct {
+	Sep rune
+	// FieldsPerRecord is the number of fields every record must have, as for
+	// csv.Reader: 0 sets it from the first record and a negative value
+	// allows any number.
+	FieldsPerRecord int
+	Schema          *CSVSchema // nil to skip validation
+	Computed        []Computed // Columns appended to each record, after the input ones
+	Filters         *FilterSet // nil to keep every row
+	Parser          *RowParser
+	NewAggregator   func() Aggregator // Called once per worker
+	Workers         int               // 1 or less to process records serially
+	ChunkSize       int               // Bytes per chunk, DefaultChunkSize when 0
+	Extract         *Extract          // nil to write no rows
+	Rejects         *Rejects          // nil to log the invalid rows instead
+	Budget          *ErrorBudget      // nil to accept any number of invalid rows
+
+	rejected atomic.Int64 // Rows rejected by every worker
+}
+
+// ErrNotMergeable is returned when several workers are asked of a pipeline
+// whose aggregators can't be merged, such as sorted time buckets.
+var ErrNotMergeable = errors.New("aggregators can't be merged, the pipeline needs a single worker")
+
+// Run processes the records of r, which must not include the header, and
+// returns the counters and the aggregator holding the results. It fails
+// with ErrBudgetExceeded when too many rows are invalid, and with
+// ErrNotMergeable when Workers is above 1 for aggregators that must see
+// every row.
+func (p *Pipeline) Run(r io.Reader) (Counters, Aggregator, error) {
+	p.rejected.Store(0)
+	if p.Workers <= 1 {
+		return p.runSerial(r)
+	}
+	return p.runParallel(r)
+}
+
+// process handles the record found at the given row number. It fails when
+// the error budget is exceeded.
+func (p *Pipeline) process(record []string, row int, res *workerResult) error {
+	c := &res.counters
+	c.Total++
+
+	// Validate schema if enabled
+	if p.Schema != nil {
+		if err := p.Schema.ValidateRecord(record); err != nil {
+			c.ValidationErrors++
+			return p.reject(res, Reject{Row: row, Reason: RejectValidation, Err: err, Record: record})
+		}
+	}
+
+	r := Record{Fields: record}
+	if len(p.Computed) > 0 {
+		r.Computed = computeValues(record, p.Computed)
+	}
+
+	// Apply filters if any
+	if p.Filters != nil {
+		match, err := p.Filters.EvaluateRecord(r)
+		if err != nil {
+			c.FilterErrors++
+			return p.reject(res, Reject{Row: row, Reason: RejectFilter, Err: err, Record: record})
+		}
+		if !match {
+			c.Filtered++
+			return nil // Skip this row
+		}
+	}
+
+	logical, err := p.Parser.ParseRecord(r)
+	if err != nil {
+		c.Invalid++
+		return p.reject(res, Reject{Row: row, Reason: RejectParse, Err: err, Record: record})
+	}
+	logical.Row = row
+
+	// Only the rows kept are extracted
+	if p.Extract != nil {
+		res.rows = append(res.rows, p.Extract.project(r.Strings()))
+		c.Extracted++
+	}
+
+	c.Valid++
+	res.agg.Consume(logical)
+	return nil
+}
+
+// reject keeps rej for the rejects, or logs it, and checks the error budget.
+func (p *Pipeline) reject(res *workerResult, rej Reject) error {
+	if p.Rejects != nil {
+		res.rejects = append(res.rejects, rej)
+	} else {
+		log.Printf("skipping row %d, %s error: %v", rej.Row, rej.Reason, rej.Err)
+	}
+	return p.Budget.checkCount(p.rejected.Add(1))
+}
+
+func (p *Pipeline) newReader(r io.Reader, fieldsPerRecord int) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.Comma = p.Sep
+	cr.FieldsPerRecord = fieldsPerRecord
+	return cr
+}
+
+// readError describes a CSV error on the given row. Line numbers of parse
+// errors are dropped, since they are relative to the chunk being read.
+func readError(err error, row int) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("error while reading CSV at row %d, column %d: %w", row, parseErr.Column, parseErr.Err)
+	}
+	return fmt.Errorf("error while reading CSV at row %d: %w", row, err)
+}
+
+func extractError(err error) error {
+	return fmt.Errorf("error while writing the extract: %w", err)
+}
+
+func rejectsError(err error) error {
+	return fmt.Errorf("error while writing the rejects: %w", err)
+}
+
+// beginOutputs writes the beginning of the extract and the rejects, the
+// input having fields columns.
+func (p *Pipeline) beginOutputs(fields int) error {
+	if p.Extract != nil {
+		if err := p.Extract.begin(max(fields, 0) + len(p.Computed)); err != nil {
+			return extractError(err)
+		}
+	}
+	if p.Rejects != nil {
+		if err := p.Rejects.begin(); err != nil {
+			return rejectsError(err)
+		}
+	}
+	return nil
+}
+
+// encodeOutputs writes the rows extracted and rejected by res to rows and
+// rejects, and forgets them.
+func (p *Pipeline) encodeOutputs(res *workerResult, rows, rejects io.Writer) error {
+	defer func() { res.rows, res.rejects = res.rows[:0], res.rejects[:0] }()
+	if p.Extract != nil {
+		if err := p.Extract.encode(rows, res.rows); err != nil {
+			return extractError(err)
+		}
+	}
+	if p.Rejects != nil {
+		if err := p.Rejects.encode(rejects, res.rejects); err != nil {
+			return rejectsError(err)
+		}
+	}
+	return nil
+}
+
+// writeOutputs writes the rows extracted and rejected by res to the extract
+// and the rejects.
+func (p *Pipeline) writeOutputs(res *workerResult) error {
+	var rows, rejects io.Writer
+	if p.Extract != nil {
+		rows = p.Extract.W
+	}
+	if p.Rejects != nil {
+		rejects = p.Rejects.W
+	}
+	return p.encodeOutputs(res, rows, rejects)
+}
+
+// endOutputs writes the end of the extract.
+func (p *Pipeline) endOutputs() error {
+	if p.Extract != nil {
+		if err := p.Extract.end(); err != nil {
+			return extractError(err)
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) runSerial(r io.Reader) (Counters, Aggregator, error) {
+	res := &workerResult{agg: p.NewAggregator()}
+	// On errors, the rows processed are still written out and the extract
+	// ended, for it to stay readable
+	stop := func(err error) (Counters, Aggregator, error) {
+		return res.counters, res.agg, cmp.Or(err, p.writeOutputs(res), p.endOutputs())
+	}
+
+	cr := p.newReader(r, p.FieldsPerRecord)
+	fields := p.FieldsPerRecord
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			if row == 1 {
+				if err := p.beginOutputs(fields); err != nil {
+					return stop(err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			// At this stage, we consider this a "fatal" CSV error (bad format)
+			return stop(readError(err, row))
+		}
+		if row == 1 {
+			if fields == 0 {
+				fields = len(record)
+			}
+			if err := p.beginOutputs(fields); err != nil {
+				return stop(err)
+			}
+		}
+		if err := p.process(record, row, res); err != nil {
+			return stop(err)
+		}
+		if len(res.rows)+len(res.rejects) >= extractBatch {
+			if err := p.writeOutputs(res); err != nil {
+				return stop(err)
+			}
+		}
+	}
+	if err := p.writeOutputs(res); err != nil {
+		return stop(err)
+	}
+	if err := p.endOutputs(); err != nil {
+		return res.counters, res.agg, err
+	}
+	return res.counters, res.agg, p.Budget.checkRate(res.counters)
+}
+
+// workerResult is what a worker computed over its chunks.
+type workerResult struct {
+	counters Counters
+	agg      Aggregator
+	err      error
+	errRow   int
+	rows     [][]string // Extracted rows not written yet
+	rejects  []Reject   // Rejected rows not written yet
+}
+
+// encodedChunk holds the extracted and rejected rows of a chunk, encoded.
+type encodedChunk struct {
+	index   int
+	rows    []byte
+	rejects []byte
+}
+
+func (p *Pipeline) runParallel(r io.Reader) (Counters, Aggregator, error) {
+	chunks := make(chan *Chunk, p.Workers)
+	results := make([]workerResult, p.Workers)
+	for i := range results {
+		results[i].agg = p.NewAggregator()
+	}
+	if !mergeable(results[0].agg) {
+		return Counters{}, results[0].agg, ErrNotMergeable
+	}
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	fail := func() { stopOnce.Do(func() { close(stop) }) }
+
+	// Every chunk must expect the field count of the first record
+	fields := p.FieldsPerRecord
+	ready := make(chan struct{})
+
+	// The extracted and rejected rows are written chunk after chunk, in input
+	// order. The reader takes a slot per chunk and the writer releases it
+	// once the chunk is written, so that few chunks wait for an earlier one.
+	ordered := p.Extract != nil || p.Rejects != nil
+	var encoded chan encodedChunk
+	var slots chan struct{}
+	var writeErr error
+	written := make(chan struct{})
+	if ordered {
+		encoded = make(chan encodedChunk, 2*p.Workers)
+		slots = make(chan struct{}, 2*p.Workers)
+		go func() {
+			defer close(written)
+			pending := make(map[int]encodedChunk)
+			next := 0
+			for ec := range encoded {
+				pending[ec.index] = ec
+				for ec, ok := pending[next]; ok; ec, ok = pending[next] {
+					delete(pending, next)
+					next++
+					if writeErr == nil {
+						if writeErr = p.writeChunk(ec); writeErr != nil {
+							fail()
+						}
+					}
+					<-slots
+				}
+			}
+		}()
+	} else {
+		close(written)
+	}
+
+	var wg sync.WaitGroup
+	for i := range p.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := &results[i]
+			<-ready
+			for chunk := range chunks {
+				row, err := p.processChunk(chunk, fields, res)
+				if ordered {
+					// Every chunk is sent, even without rows or after an
+					// error, for the writer to move on
+					var rows, rejects bytes.Buffer
+					p.encodeOutputs(res, &rows, &rejects) // Writing to buffers can't fail
+					encoded <- encodedChunk{index: chunk.Index, rows: rows.Bytes(), rejects: rejects.Bytes()}
+				}
+				if err != nil {
+					res.err, res.errRow = err, row
+					fail()
+					return
+				}
+			}
+		}()
+	}
+
+	// Read chunks until the end of the input or a worker failure
+	reader := newChunkReader(r, p.ChunkSize)
+	var readErr error
+read:
+	for {
+		chunk, err := reader.Next()
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+		if chunk.Index == 0 {
+			if fields == 0 {
+				if fields, err = fieldCount(chunk.Data, p.Sep); err != nil {
+					readErr = readError(err, 1)
+					break
+				}
+			}
+			if err := p.beginOutputs(fields); err != nil {
+				readErr = err
+				break
+			}
+			close(ready)
+		}
+		if ordered {
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				break read
+			}
+		}
+		select {
+		case chunks <- chunk:
+		case <-stop:
+			break read
+		}
+	}
+	close(chunks)
+	begun := true
+	select {
+	case <-ready:
+	default:
+		begun = false
+		close(ready)
+	}
+	wg.Wait()
+	if ordered {
+		close(encoded)
+	}
+	<-written
+
+	// Merge the results, reporting the error of the earliest row if any
+	var c Counters
+	var agg Aggregator
+	var failed *workerResult
+	for i := range results {
+		res := &results[i]
+		if res.err != nil && (failed == nil || res.errRow < failed.errRow) {
+			failed = res
+		}
+		c.add(res.counters)
+		if agg == nil {
+			agg = res.agg
+		} else {
+			agg.Merge(res.agg)
+		}
+	}
+	// The rows written before an error make a readable extract
+	if failed != nil {
+		return c, agg, cmp.Or(failed.err, p.endOutputs())
+	}
+	if readErr != nil || writeErr != nil {
+		return c, agg, cmp.Or(readErr, writeErr, p.endOutputs())
+	}
+	if !begun {
+		if err := p.beginOutputs(fields); err != nil {
+			return c, agg, err
+		}
+	}
+	if err := p.endOutputs(); err != nil {
+		return c, agg, err
+	}
+	return c, agg, p.Budget.checkRate(c)
+}
+
+// writeChunk writes the rows extracted and rejected from a chunk.
+func (p *Pipeline) writeChunk(ec encodedChunk) error {
+	if p.Extract != nil {
+		if _, err := p.Extract.W.Write(ec.rows); err != nil {
+			return extractError(err)
+		}
+	}
+	if p.Rejects != nil {
+		if _, err := p.Rejects.W.Write(ec.rejects); err != nil {
+			return rejectsError(err)
+		}
+	}
+	return nil
+}
+
+// processChunk processes the records of chunk, returning the row of the
+// error that stopped it, if any.
+func (p *Pipeline) processChunk(chunk *Chunk, fields int, res *workerResult) (int, error) {
+	cr := p.newReader(bytes.NewReader(chunk.Data), fields)
+	for row := chunk.FirstRow; ; row++ {
+		record, err := cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return row, readError(err, row)
+		}
+		if err := p.process(record, row, res); err != nil {
+			return row, err
+		}
+	}
+}
+
+// fieldCount returns the number of fields of the first record of data.
+func fieldCount(data []byte, sep rune) (int, error) {
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.Comma = sep
+	record, err := cr.Read()
+	if err != nil {
+		return 0, err
+	}
+	return len(record), nil
+}
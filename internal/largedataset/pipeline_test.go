@@ -0,0 +1,217 @@
+package largedataset
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// testRecords returns n records of Symbol, Sector, Price, Volume with a few
+// quoted newlines, blank lines and unparsable prices.
+func testRecords(n int) string {
+	var b strings.Builder
+	sectors := []string{"Tech", "Energy", "\"Health\ncare\""}
+	for i := range n {
+		price := fmt.Sprintf("%d.%02d", 10+i%90, i%100)
+		if i%97 == 0 {
+			price = "n/a"
+		}
+		fmt.Fprintf(&b, "S%d,%s,%s,%d\n", i%7, sectors[i%len(sectors)], price, 100+i%1000)
+		if i%53 == 0 {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func newTestPipeline(workers, chunkSize int) *Pipeline {
+	measures := []Measure{{Index: 2, Name: "Price", Type: TypeFloat}, {Index: 3, Name: "Volume", Type: TypeInt}}
+	columns := []Column{{Index: 1, Name: "Sector"}, {Index: 0, Name: "Symbol"}}
+	return &Pipeline{
+		Sep:     ',',
+		Parser:  &RowParser{Measures: measures, GroupBy: columns},
+		Workers: workers, ChunkSize: chunkSize,
+		NewAggregator: func() Aggregator {
+			groups := NewGroupByAggregator(measures, columns)
+			groups.Rollup = true
+			return NewCompositeAggregator(NewGlobalAmountAggregator(measures), groups, NewDebugAggregator(3))
+		},
+	}
+}
+
+func TestPipeline_ParallelMatchesSerial(t *testing.T) {
+	data := testRecords(2000)
+
+	serialCounters, serialAgg, err := newTestPipeline(1, 0).Run(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("serial run failed: %v", err)
+	}
+	want := withoutPercentiles(serialAgg.Tables())
+	if serialCounters.Total != 2000 || serialCounters.Invalid != 21 {
+		t.Fatalf("unexpected serial counters: %+v", serialCounters)
+	}
+
+	for _, workers := range []int{2, 4} {
+		counters, agg, err := newTestPipeline(workers, 256).Run(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("%d workers: unexpected error: %v", workers, err)
+		}
+		if counters != serialCounters {
+			t.Errorf("%d workers: counters = %+v, want %+v", workers, counters, serialCounters)
+		}
+		if got := withoutPercentiles(agg.Tables()); got != want {
+			t.Errorf("%d workers: report differs from the serial one:\n%s\nwant:\n%s", workers, got, want)
+		}
+	}
+}
+
+func TestPipeline_ExtractKeepsInputOrder(t *testing.T) {
+	data := testRecords(2000)
+	extract := func(workers int) (Counters, string) {
+		var out strings.Builder
+		p := newTestPipeline(workers, 256)
+		p.Filters, _ = NewFilterSet([]string{"Sector != Energy"}, []string{"Symbol", "Sector", "Price", "Volume"})
+		p.Extract, _ = NewExtract(&out, "csv", []Column{{Index: 0, Name: "Symbol"}, {Index: 2, Name: "Price"}}, nil)
+		c, _, err := p.Run(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("%d workers: unexpected error: %v", workers, err)
+		}
+		return c, out.String()
+	}
+
+	serialCounters, want := extract(1)
+	if lines := strings.Count(want, "\n"); lines != serialCounters.Extracted+1 || serialCounters.Filtered == 0 || serialCounters.Extracted != serialCounters.Valid {
+		t.Fatalf("unexpected serial extract of %d lines, counters %+v", lines, serialCounters)
+	}
+	if !strings.HasPrefix(want, "Symbol,Price\nS2,12.02\n") { // The first row has an invalid price
+		t.Errorf("unexpected extract start: %q", want[:40])
+	}
+	for _, workers := range []int{2, 4} {
+		if c, got := extract(workers); got != want || c != serialCounters {
+			t.Errorf("%d workers: extract differs from the serial one (%d bytes, want %d)", workers, len(got), len(want))
+		}
+	}
+}
+
+func TestPipeline_Computed(t *testing.T) {
+	header := []string{"Symbol", "Sector", "Price", "Volume"}
+	computed, header, err := ParseComputedColumns([]string{"Notional = Price * Volume"}, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters, err := NewFilterSet([]string{"Notional > 1000"}, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	measures, _ := ParseMeasures([]string{"Notional"}, header, nil)
+	global := NewGlobalAmountAggregator(measures)
+	var out strings.Builder
+	extract, _ := NewExtract(&out, "csv", []Column{{Index: 0, Name: "Symbol"}, {Index: 4, Name: "Notional"}}, nil)
+	p := &Pipeline{
+		Sep: ',', Computed: computed, Filters: filters,
+		Parser:        &RowParser{Measures: measures},
+		NewAggregator: func() Aggregator { return global },
+		Extract:       extract,
+	}
+
+	data := "AAPL,Tech,10,200\nXOM,Energy,5,100\nMSFT,Tech,20,60\nBAD,Tech,n/a,1\n"
+	c, _, err := p.Run(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Filtered != 2 || c.Valid != 2 { // BAD has an empty Notional
+		t.Errorf("unexpected counters: %+v", c)
+	}
+	if s := global.Stats[0]; s.Count != 2 || s.Sum != 3200 {
+		t.Errorf("Notional stats: count %d, sum %g, want 2 and 3200", s.Count, s.Sum)
+	}
+	if want := "Symbol,Notional\nAAPL,2000\nMSFT,1200\n"; out.String() != want {
+		t.Errorf("extract = %q, want %q", out.String(), want)
+	}
+}
+
+// withoutPercentiles renders tables with their percentiles blanked, since
+// their estimates depend on the order the values were merged in.
+func withoutPercentiles(tables []*Table) string {
+	for _, t := range tables {
+		for i, col := range t.Columns {
+			if len(col) > 1 && col[0] == 'P' && unicode.IsDigit(rune(col[1])) {
+				for _, row := range t.Rows {
+					row[i] = nil
+				}
+			}
+		}
+	}
+	return renderText(tables)
+}
+
+func TestPipeline_ReadErrorRow(t *testing.T) {
+	data := testRecords(500)
+	lines := strings.SplitAfter(data, "\n")
+	// Record 401 gets an extra field
+	var b strings.Builder
+	row := 0
+	for _, line := range lines {
+		b.WriteString(line)
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "care\"") {
+			row++
+			if row == 400 {
+				b.WriteString("S1,Tech,1.5,10,extra\n")
+			}
+		}
+	}
+
+	for _, workers := range []int{1, 3} {
+		_, _, err := newTestPipeline(workers, 128).Run(strings.NewReader(b.String()))
+		if err == nil {
+			t.Fatalf("%d workers: expected an error", workers)
+		}
+		if !strings.Contains(err.Error(), "at row 401,") {
+			t.Errorf("%d workers: error = %v, want it at row 401", workers, err)
+		}
+	}
+}
+
+func TestAmountStats_Merge(t *testing.T) {
+	a, b, all := NewAmountStats(), NewAmountStats(), NewAmountStats()
+	for i, v := range []float64{4, -2, 10, 7, 3} {
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+		all.Add(v)
+	}
+	a.Merge(b)
+	a.Merge(NewAmountStats())
+	empty := NewAmountStats()
+	empty.Merge(all)
+
+	for _, s := range []*AmountStats{a, empty} {
+		if s.Count != all.Count || s.Sum != all.Sum || s.Min != all.Min || s.Max != all.Max {
+			t.Errorf("merged stats = %+v, want %+v", *s, *all)
+		}
+		if math.Abs(s.Variance()-all.Variance()) > 1e-9 || math.Abs(s.Mean-all.Mean) > 1e-9 {
+			t.Errorf("merged variance = %v (mean %v), want %v (mean %v)", s.Variance(), s.Mean, all.Variance(), all.Mean)
+		}
+		if s.Percentile(50) != 4 {
+			t.Errorf("merged median = %v, want 4", s.Percentile(50))
+		}
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	data := testRecords(100000)
+	for _, workers := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				if _, _, err := newTestPipeline(workers, 64<<10).Run(strings.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package largedataset
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Reasons rows are rejected for.
+const (
+	RejectValidation = "validation" // The schema rejected the row
+	RejectFilter     = "filter"     // The filters could not be evaluated
+	RejectParse      = "parse"      // A measure could not be parsed
+)
+
+// Reject is a row left out because it is invalid.
+type Reject struct {
+	Row    int
+	Reason string // One of the Reject* reasons
+	Err    error
+	Record []string
+}
+
+// Rejects writes the rejected rows to W as CSV: their row number, the
+// reason, the column, value and expectation of ValidationError errors, the
+// error message and the record as read.
+type Rejects struct {
+	W   io.Writer
+	Sep rune // Separator the records are written back with
+}
+
+var rejectsHeader = []string{"row", "reason", "column", "column_name", "value", "expected", "error", "record"}
+
+// begin writes the header of the rejects.
+func (r *Rejects) begin() error {
+	cw := csv.NewWriter(r.W)
+	return cw.WriteAll([][]string{rejectsHeader})
+}
+
+// encode writes rejects to w.
+func (r *Rejects) encode(w io.Writer, rejects []Reject) error {
+	if len(rejects) == 0 {
+		return nil
+	}
+	cw := csv.NewWriter(w)
+	var record bytes.Buffer
+	rw := csv.NewWriter(&record)
+	rw.Comma = r.Sep
+	for _, rej := range rejects {
+		line := []string{strconv.Itoa(rej.Row), rej.Reason, "", "", "", "", rej.Err.Error(), ""}
+		var verr *ValidationError
+		if errors.As(rej.Err, &verr) {
+			line[2], line[3], line[4], line[5] = strconv.Itoa(verr.Column), verr.ColName, verr.Value, verr.Expected
+		}
+		record.Reset()
+		rw.Write(rej.Record)
+		rw.Flush()
+		line[7] = string(bytes.TrimSuffix(record.Bytes(), []byte{'\n'}))
+		cw.Write(line)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ErrBudgetExceeded is returned when a pipeline rejected more rows than its
+// ErrorBudget allows.
+var ErrBudgetExceeded = errors.New("error budget exceeded")
+
+// ErrorBudget bounds the rows a pipeline may reject, those failing the
+// validation, the filters or the parsing of their measures.
+type ErrorBudget struct {
+	MaxErrors int     // Rejected rows allowed, negative for no limit
+	MaxRate   float64 // Fraction of the rows read allowed to be rejected, 0 for no limit
+}
+
+// checkCount fails once more than MaxErrors rows have been rejected, so that
+// the run stops early.
+func (b *ErrorBudget) checkCount(rejected int64) error {
+	if b == nil || b.MaxErrors < 0 || rejected <= int64(b.MaxErrors) {
+		return nil
+	}
+	return fmt.Errorf("%w: more than %d rows rejected", ErrBudgetExceeded, b.MaxErrors)
+}
+
+// checkRate fails if the rows rejected are more than MaxRate of the rows
+// read, which is only known once every row has been read.
+func (b *ErrorBudget) checkRate(c Counters) error {
+	if b == nil || b.MaxRate <= 0 || c.Total == 0 {
+		return nil
+	}
+	if rate := float64(c.Rejected()) / float64(c.Total); rate > b.MaxRate {
+		return fmt.Errorf("%w: %.2f%% of the rows rejected, at most %.2f%% allowed", ErrBudgetExceeded, 100*rate, 100*b.MaxRate)
+	}
+	return nil
+}
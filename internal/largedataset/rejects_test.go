@@ -0,0 +1,123 @@
+package largedataset
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRejects_Encode(t *testing.T) {
+	var out strings.Builder
+	r := &Rejects{W: &out, Sep: ';'}
+	r.begin()
+	err := r.encode(r.W, []Reject{
+		{Row: 3, Reason: RejectValidation, Record: []string{"AAPL", "x;y"},
+			Err: &ValidationError{Column: 1, ColName: "Price", Value: "x;y", Expected: "expected float"}},
+		{Row: 7, Reason: RejectParse, Record: []string{"MSFT", ""}, Err: errors.New("invalid Price \"\"")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `row,reason,column,column_name,value,expected,error,record
+3,validation,1,Price,x;y,expected float,"column 1 (Price): invalid value ""x;y"" - expected float","AAPL;""x;y"""
+7,parse,,,,,"invalid Price """"",MSFT;
+`
+	if out.String() != want {
+		t.Errorf("rejects = %s\nwant %s", out.String(), want)
+	}
+}
+
+func TestPipeline_ErrorBudget(t *testing.T) {
+	data := testRecords(2000) // 21 invalid prices, the first on row 1
+
+	tests := []struct {
+		name    string
+		budget  ErrorBudget
+		wantErr bool
+	}{
+		{"no limit", ErrorBudget{MaxErrors: -1}, false},
+		{"enough errors", ErrorBudget{MaxErrors: 21}, false},
+		{"too many errors", ErrorBudget{MaxErrors: 20}, true},
+		{"no error allowed", ErrorBudget{MaxErrors: 0}, true},
+		{"rate under", ErrorBudget{MaxErrors: -1, MaxRate: 0.011}, false},
+		{"rate over", ErrorBudget{MaxErrors: -1, MaxRate: 0.01}, true},
+	}
+	for _, tt := range tests {
+		for _, workers := range []int{1, 4} {
+			p := newTestPipeline(workers, 256)
+			p.Budget = &tt.budget
+			_, _, err := p.Run(strings.NewReader(data))
+			if tt.wantErr != errors.Is(err, ErrBudgetExceeded) || !tt.wantErr && err != nil {
+				t.Errorf("%s, %d workers: error = %v, wantErr %v", tt.name, workers, err, tt.wantErr)
+			}
+		}
+	}
+}
+
+func TestPipeline_ErrorBudgetEndsExtract(t *testing.T) {
+	data := testRecords(2000)
+	for _, workers := range []int{1, 4} {
+		var out bytes.Buffer
+		p := newTestPipeline(workers, 256)
+		p.Budget = &ErrorBudget{MaxErrors: 5}
+		p.Extract, _ = NewExtract(&out, "parquet-lite", []Column{{Index: 0, Name: "Symbol"}, {Index: 2, Name: "Price"}}, nil)
+		c, _, err := p.Run(strings.NewReader(data))
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Fatalf("%d workers: error = %v, want ErrBudgetExceeded", workers, err)
+		}
+
+		// The rows extracted before the error are readable
+		_, rows, err := ReadParquetLite(&out)
+		if err != nil {
+			t.Fatalf("%d workers: ReadParquetLite: %v", workers, err)
+		}
+		if len(rows) == 0 || workers == 1 && len(rows) != c.Extracted {
+			t.Errorf("%d workers: read %d rows, %d extracted", workers, len(rows), c.Extracted)
+		}
+	}
+}
+
+func TestPipeline_RejectedRowsNotExtracted(t *testing.T) {
+	var extract, rejects strings.Builder
+	p := newTestPipeline(1, 0)
+	p.Extract, _ = NewExtract(&extract, "csv", nil, nil)
+	p.Rejects = &Rejects{W: &rejects, Sep: ','}
+	c, _, err := p.Run(strings.NewReader("AAPL,Tech,10.5,100\nBAD,Tech,n/a,1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extract.String() != "AAPL,Tech,10.5,100\n" {
+		t.Errorf("extract = %q, want only the valid row", extract.String())
+	}
+	if !strings.Contains(rejects.String(), "BAD") || strings.Contains(rejects.String(), "AAPL") {
+		t.Errorf("rejects = %q, want only the invalid row", rejects.String())
+	}
+	if c.Extracted != 1 || c.Invalid != 1 {
+		t.Errorf("unexpected counters: %+v", c)
+	}
+}
+
+func TestPipeline_RejectsKeepInputOrder(t *testing.T) {
+	data := testRecords(2000)
+	rejects := func(workers int) string {
+		var out strings.Builder
+		p := newTestPipeline(workers, 256)
+		p.Rejects = &Rejects{W: &out, Sep: ','}
+		if _, _, err := p.Run(strings.NewReader(data)); err != nil {
+			t.Fatalf("%d workers: unexpected error: %v", workers, err)
+		}
+		return out.String()
+	}
+
+	want := rejects(1)
+	if lines, err := csv.NewReader(strings.NewReader(want)).ReadAll(); err != nil || len(lines) != 22 || lines[1][0] != "1" || lines[1][1] != RejectParse {
+		t.Fatalf("unexpected serial rejects:\n%s", want)
+	}
+	for _, workers := range []int{2, 4} {
+		if got := rejects(workers); got != want {
+			t.Errorf("%d workers: rejects differ from the serial ones:\n%s\nwant:\n%s", workers, got, want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package largedataset
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// summaryLines are the counters shown by the summaries, in order.
+var summaryLines = []struct {
+	label string
+	value func(Counters) int
+}{
+	{"Total rows read", func(c Counters) int { return c.Total }},
+	{"Filtered out", func(c Counters) int { return c.Filtered }},
+	{"Validation errors", func(c Counters) int { return c.ValidationErrors }},
+	{"Filter errors", func(c Counters) int { return c.FilterErrors }},
+	{"Invalid rows", func(c Counters) int { return c.Invalid }},
+	{"Valid logical rows", func(c Counters) int { return c.Valid }},
+}
+
+// textRenderer writes aligned plain text tables. Rows are buffered until
+// the table ends or is flushed, and columns only grow so that the rows of
+// successive flushes stay aligned.
+type textRenderer struct {
+	w       *bufio.Writer
+	widths  []int
+	pending [][]string
+}
+
+func newTextRenderer(w io.Writer) *textRenderer {
+	return &textRenderer{w: bufio.NewWriter(w)}
+}
+
+func (r *textRenderer) BeginTable(title string, columns []string) {
+	fmt.Fprintf(r.w, "\n=== %s ===\n", title)
+	r.widths = nil
+	r.pending = append(r.pending, columns)
+}
+
+func (r *textRenderer) Row(cells []any) {
+	line := make([]string, len(cells))
+	for i, cell := range cells {
+		line[i] = formatCell(cell, 2)
+	}
+	r.pending = append(r.pending, line)
+}
+
+func (r *textRenderer) EndTable(notes []string) {
+	r.writePending()
+	for _, note := range notes {
+		fmt.Fprintln(r.w, note)
+	}
+}
+
+func (r *textRenderer) writePending() {
+	for _, line := range r.pending {
+		for i, cell := range line {
+			if i == len(r.widths) {
+				r.widths = append(r.widths, 0)
+			}
+			r.widths[i] = max(r.widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	for _, line := range r.pending {
+		var b strings.Builder
+		for i, cell := range line {
+			b.WriteString(cell)
+			if i < len(line)-1 {
+				b.WriteString(strings.Repeat(" ", r.widths[i]-utf8.RuneCountInString(cell)+2))
+			}
+		}
+		fmt.Fprintln(r.w, strings.TrimRight(b.String(), " "))
+	}
+	r.pending = r.pending[:0]
+}
+
+func (r *textRenderer) Summary(c Counters) {
+	fmt.Fprintln(r.w, "\n=== Summary ===")
+	for _, line := range summaryLines {
+		fmt.Fprintf(r.w, "%-20s%d\n", line.label+":", line.value(c))
+	}
+}
+
+func (r *textRenderer) Flush() error {
+	r.writePending()
+	return r.w.Flush()
+}
+
+func (r *textRenderer) Close() error {
+	return r.Flush()
+}
+
+// markdownRenderer writes GitHub flavored Markdown tables.
+type markdownRenderer struct {
+	w *bufio.Writer
+}
+
+func newMarkdownRenderer(w io.Writer) *markdownRenderer {
+	return &markdownRenderer{w: bufio.NewWriter(w)}
+}
+
+func (r *markdownRenderer) BeginTable(title string, columns []string) {
+	fmt.Fprintf(r.w, "\n## %s\n\n", title)
+	r.line(columns)
+	separators := make([]string, len(columns))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	r.line(separators)
+}
+
+func (r *markdownRenderer) line(cells []string) {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		cell = strings.ReplaceAll(cell, "|", `\|`)
+		escaped[i] = strings.ReplaceAll(cell, "\n", "<br>")
+	}
+	fmt.Fprintf(r.w, "| %s |\n", strings.Join(escaped, " | "))
+}
+
+func (r *markdownRenderer) Row(cells []any) {
+	line := make([]string, len(cells))
+	for i, cell := range cells {
+		line[i] = formatCell(cell, 2)
+	}
+	r.line(line)
+}
+
+func (r *markdownRenderer) EndTable(notes []string) {
+	for _, note := range notes {
+		fmt.Fprintf(r.w, "\n%s\n", note)
+	}
+}
+
+func (r *markdownRenderer) Summary(c Counters) {
+	fmt.Fprint(r.w, "\n## Summary\n\n")
+	r.line([]string{"Counter", "Rows"})
+	r.line([]string{"---", "---:"})
+	for _, line := range summaryLines {
+		r.line([]string{line.label, strconv.Itoa(line.value(c))})
+	}
+}
+
+func (r *markdownRenderer) Flush() error {
+	return r.w.Flush()
+}
+
+func (r *markdownRenderer) Close() error {
+	return r.Flush()
+}
+
+// csvRenderer writes each table as a CSV block: a "# title" record, the
+// header, the rows and "# note" records, blocks being separated by an empty
+// line. Floats are written with full precision.
+type csvRenderer struct {
+	w      *bufio.Writer
+	cw     *csv.Writer
+	blocks int
+}
+
+func newCSVRenderer(w io.Writer) *csvRenderer {
+	bw := bufio.NewWriter(w)
+	return &csvRenderer{w: bw, cw: csv.NewWriter(bw)}
+}
+
+func (r *csvRenderer) begin(title string, columns []string) {
+	r.cw.Flush()
+	if r.blocks > 0 {
+		r.w.WriteString("\n")
+	}
+	r.blocks++
+	r.cw.Write([]string{"# " + title})
+	r.cw.Write(columns)
+}
+
+func (r *csvRenderer) BeginTable(title string, columns []string) {
+	r.begin(title, columns)
+}
+
+func (r *csvRenderer) Row(cells []any) {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i] = formatCell(cell, -1)
+	}
+	r.cw.Write(record)
+}
+
+func (r *csvRenderer) EndTable(notes []string) {
+	for _, note := range notes {
+		r.cw.Write([]string{"# " + note})
+	}
+	r.cw.Flush()
+}
+
+func (r *csvRenderer) Summary(c Counters) {
+	columns := make([]string, len(summaryLines))
+	record := make([]string, len(summaryLines))
+	for i, line := range summaryLines {
+		columns[i] = line.label
+		record[i] = strconv.Itoa(line.value(c))
+	}
+	r.begin("Summary", columns)
+	r.cw.Write(record)
+	r.cw.Flush()
+}
+
+func (r *csvRenderer) Flush() error {
+	r.cw.Flush()
+	if err := r.cw.Error(); err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+func (r *csvRenderer) Close() error {
+	return r.Flush()
+}
+
+// jsonRenderer writes a single JSON object:
+//
+//	{"tables": [{"title": ..., "columns": [...], "rows": [[...]], "notes": [...]}],
+//	 "summary": {"total": ..., ...}}
+//
+// Tables are written as they come, the summary once the report is closed.
+type jsonRenderer struct {
+	w       *bufio.Writer
+	tables  int // Tables begun
+	rows    int // Rows of the current table
+	summary *Counters
+	err     error
+}
+
+func newJSONRenderer(w io.Writer) *jsonRenderer {
+	return &jsonRenderer{w: bufio.NewWriter(w)}
+}
+
+// write writes the JSON encoding of v, keeping the first error.
+func (r *jsonRenderer) write(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		data = []byte("null")
+	}
+	r.w.Write(data)
+}
+
+func (r *jsonRenderer) BeginTable(title string, columns []string) {
+	if r.tables == 0 {
+		r.w.WriteString(`{"tables":[`)
+	} else {
+		r.w.WriteString(",")
+	}
+	r.tables++
+	r.rows = 0
+	r.w.WriteString("\n")
+	r.w.WriteString(`{"title":`)
+	r.write(title)
+	r.w.WriteString(`,"columns":`)
+	r.write(columns)
+	r.w.WriteString(`,"rows":[`)
+}
+
+func (r *jsonRenderer) Row(cells []any) {
+	if r.rows > 0 {
+		r.w.WriteString(",")
+	}
+	r.rows++
+	r.w.WriteString("\n")
+	r.write(cells)
+}
+
+func (r *jsonRenderer) EndTable(notes []string) {
+	r.w.WriteString("]")
+	if len(notes) > 0 {
+		r.w.WriteString(`,"notes":`)
+		r.write(notes)
+	}
+	r.w.WriteString("}")
+}
+
+func (r *jsonRenderer) Summary(c Counters) {
+	r.summary = &c
+}
+
+func (r *jsonRenderer) Flush() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.w.Flush()
+}
+
+func (r *jsonRenderer) Close() error {
+	if r.tables == 0 {
+		r.w.WriteString(`{"tables":[`)
+	}
+	r.w.WriteString("\n]")
+	if r.summary != nil {
+		r.w.WriteString(`,"summary":`)
+		r.write(r.summary)
+	}
+	r.w.WriteString("}\n")
+	return r.Flush()
+}
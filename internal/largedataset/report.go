@@ -0,0 +1,87 @@
+package largedataset
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Table is a result of an aggregator. Cells hold nil when there is no
+// value, int64, float64, string or []string.
+type Table struct {
+	Title   string
+	Columns []string
+	Rows    [][]any
+	Notes   []string // Remarks shown after the rows, e.g. error bounds
+}
+
+// Renderer writes the tables of a report and its summary in an output
+// format. Rows are written as they come so that large tables can be
+// streamed.
+type Renderer interface {
+	BeginTable(title string, columns []string)
+	Row(cells []any)
+	EndTable(notes []string)
+	Summary(c Counters)
+	// Flush writes what has been buffered, Close also ends the report.
+	Flush() error
+	Close() error
+}
+
+// OutputFormats are the formats NewRenderer supports.
+var OutputFormats = []string{"table", "markdown", "csv", "json"}
+
+// NewRenderer returns a renderer writing the given format to w.
+func NewRenderer(format string, w io.Writer) (Renderer, error) {
+	switch format {
+	case "table":
+		return newTextRenderer(w), nil
+	case "markdown":
+		return newMarkdownRenderer(w), nil
+	case "csv":
+		return newCSVRenderer(w), nil
+	case "json":
+		return newJSONRenderer(w), nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (expected %s)", format, strings.Join(OutputFormats, ", "))
+}
+
+// RenderTable writes t with r.
+func RenderTable(r Renderer, t *Table) {
+	r.BeginTable(t.Title, t.Columns)
+	for _, row := range t.Rows {
+		r.Row(row)
+	}
+	r.EndTable(t.Notes)
+}
+
+// number returns v as a cell, nil if it is not a finite number.
+func number(v float64) any {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+// measureValue returns a value of m as a cell, rounded for integer measures.
+func measureValue(m Measure, v float64) any {
+	if m.Type == TypeInt && !math.IsNaN(v) && !math.IsInf(v, 0) {
+		return int64(math.Round(v))
+	}
+	return number(v)
+}
+
+// formatCell formats a cell for the text formats, floats with precision
+// decimals (-1 for as many as needed).
+func formatCell(cell any, precision int) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', precision, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
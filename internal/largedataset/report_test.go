@@ -0,0 +1,141 @@
+package largedataset
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+// renderText renders tables in the table format.
+func renderText(tables []*Table) string {
+	var out bytes.Buffer
+	r := newTextRenderer(&out)
+	for _, t := range tables {
+		RenderTable(r, t)
+	}
+	r.Close()
+	return out.String()
+}
+
+var testTable = &Table{
+	Title:   "Prices",
+	Columns: []string{"Symbol", "Price", "Volume"},
+	Rows: [][]any{
+		{"AAPL", 10.5, int64(100)},
+		{"A|B", number(math.NaN()), nil},
+	},
+	Notes: []string{"1 row skipped"},
+}
+
+func render(t *testing.T, format string) string {
+	var out bytes.Buffer
+	r, err := NewRenderer(format, &out)
+	if err != nil {
+		t.Fatalf("NewRenderer(%q) error: %v", format, err)
+	}
+	r.Summary(Counters{Total: 3, Valid: 2, Filtered: 1})
+	RenderTable(r, testTable)
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	return out.String()
+}
+
+func TestRenderer_Table(t *testing.T) {
+	want := `
+=== Summary ===
+Total rows read:    3
+Filtered out:       1
+Validation errors:  0
+Filter errors:      0
+Invalid rows:       0
+Valid logical rows: 2
+
+=== Prices ===
+Symbol  Price  Volume
+AAPL    10.50  100
+A|B
+1 row skipped
+`
+	if got := render(t, "table"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderer_Markdown(t *testing.T) {
+	got := render(t, "markdown")
+	for _, want := range []string{"## Summary", "| Filtered out | 1 |", "## Prices", "| Symbol | Price | Volume |\n| --- | --- | --- |", "| AAPL | 10.50 | 100 |", `| A\|B |  |  |`, "\n1 row skipped\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("markdown does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderer_CSV(t *testing.T) {
+	r := csv.NewReader(strings.NewReader(render(t, "csv")))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	want := [][]string{
+		{"# Summary"},
+		{"Total rows read", "Filtered out", "Validation errors", "Filter errors", "Invalid rows", "Valid logical rows"},
+		{"3", "1", "0", "0", "0", "2"},
+		{"# Prices"},
+		{"Symbol", "Price", "Volume"},
+		{"AAPL", "10.5", "100"},
+		{"A|B", "", ""},
+		{"# 1 row skipped"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %q, want %q", records, want)
+	}
+	for i := range want {
+		if strings.Join(records[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("record %d = %q, want %q", i, records[i], want[i])
+		}
+	}
+}
+
+func TestRenderer_JSON(t *testing.T) {
+	var report struct {
+		Tables []struct {
+			Title   string   `json:"title"`
+			Columns []string `json:"columns"`
+			Rows    [][]any  `json:"rows"`
+			Notes   []string `json:"notes"`
+		} `json:"tables"`
+		Summary Counters `json:"summary"`
+	}
+	got := render(t, "json")
+	if err := json.Unmarshal([]byte(got), &report); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, got)
+	}
+	if report.Summary != (Counters{Total: 3, Valid: 2, Filtered: 1}) {
+		t.Errorf("summary = %+v", report.Summary)
+	}
+	if len(report.Tables) != 1 || report.Tables[0].Title != "Prices" || len(report.Tables[0].Rows) != 2 || report.Tables[0].Notes[0] != "1 row skipped" {
+		t.Fatalf("unexpected tables: %+v", report.Tables)
+	}
+	if row := report.Tables[0].Rows[1]; row[1] != nil || row[2] != nil {
+		t.Errorf("expected null cells for NaN and missing values, got %v", row)
+	}
+
+	// A report without table is still an object
+	var out bytes.Buffer
+	r, _ := NewRenderer("json", &out)
+	r.Close()
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Errorf("invalid empty JSON report %q: %v", out.String(), err)
+	}
+}
+
+func TestNewRenderer_Unknown(t *testing.T) {
+	if _, err := NewRenderer("xml", &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
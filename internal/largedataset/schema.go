@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,19 +22,42 @@ const (
 	TypeRegex
 )
 
+// columnTypeNames maps the names accepted by ParseColumnType to their type
+var columnTypeNames = map[string]ColumnType{
+	"string":   TypeString,
+	"int":      TypeInt,
+	"float":    TypeFloat,
+	"bool":     TypeBool,
+	"date":     TypeDate,
+	"datetime": TypeDateTime,
+	"email":    TypeEmail,
+	"regex":    TypeRegex,
+}
+
+// ParseColumnType returns the type named name, such as "int" or "datetime".
+// The "Type" prefix of the Go constants is accepted too, e.g. "TypeInt".
+func ParseColumnType(name string) (ColumnType, error) {
+	key := strings.ToLower(strings.TrimPrefix(name, "Type"))
+	if t, ok := columnTypeNames[key]; ok {
+		return t, nil
+	}
+	return TypeString, fmt.Errorf("unknown column type %q", name)
+}
+
 // ColumnDef defines the schema for a single CSV column
 type ColumnDef struct {
-	Index       int        // Column index (0-based)
-	Name        string     // Column name (for documentation/errors)
-	Type        ColumnType // Expected data type
-	Required    bool       // Whether the column must be non-empty
-	MinLength   int        // Minimum string length (for TypeString)
-	MaxLength   int        // Maximum string length (for TypeString)
-	Min         *float64   // Minimum value (for TypeInt/TypeFloat)
-	Max         *float64   // Maximum value (for TypeInt/TypeFloat)
-	Pattern     string     // Regex pattern (for TypeRegex)
-	DateFormat  string     // Date format (for TypeDate/TypeDateTime)
-	AllowedVals []string   // Whitelist of allowed values
+	Index       int            // Column index (0-based)
+	Name        string         // Column name (for documentation/errors)
+	Type        ColumnType     // Expected data type
+	Required    bool           // Whether the column must be non-empty
+	MinLength   int            // Minimum string length (for TypeString)
+	MaxLength   int            // Maximum string length (for TypeString)
+	Min         *float64       // Minimum value (for TypeInt/TypeFloat)
+	Max         *float64       // Maximum value (for TypeInt/TypeFloat)
+	Pattern     string         // Regex pattern (for TypeRegex)
+	Regex       *regexp.Regexp // Compiled Pattern, compiled for each value when nil
+	DateFormat  string         // Date format (for TypeDate/TypeDateTime)
+	AllowedVals []string       // Whitelist of allowed values
 }
 
 // CSVSchema represents the complete schema for a CSV file
@@ -196,20 +220,22 @@ func validateColumnValue(value string, colDef *ColumnDef) error {
 		}
 
 	case TypeEmail:
-		emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 		if !emailRegex.MatchString(value) {
 			return fmt.Errorf("expected valid email address")
 		}
 
 	case TypeRegex:
-		if colDef.Pattern == "" {
-			return fmt.Errorf("regex pattern not specified")
-		}
-		matched, err := regexp.MatchString(colDef.Pattern, value)
-		if err != nil {
-			return fmt.Errorf("invalid regex pattern: %v", err)
+		re := colDef.Regex
+		if re == nil {
+			if colDef.Pattern == "" {
+				return fmt.Errorf("regex pattern not specified")
+			}
+			var err error
+			if re, err = regexp.Compile(colDef.Pattern); err != nil {
+				return fmt.Errorf("invalid regex pattern: %v", err)
+			}
 		}
-		if !matched {
+		if !re.MatchString(value) {
 			return fmt.Errorf("value must match pattern: %s", colDef.Pattern)
 		}
 	}
@@ -121,3 +121,22 @@ func TestSchemaValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestParseColumnType(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    ColumnType
+		wantErr bool
+	}{
+		{"int", TypeInt, false},
+		{"DateTime", TypeDateTime, false},
+		{"TypeEmail", TypeEmail, false},
+		{"decimal", TypeString, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseColumnType(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseColumnType(%q) = %v, %v; want %v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -8,6 +8,10 @@ type AmountStats struct {
 	Sum   float64
 	Min   float64
 	Max   float64
+	Mean  float64 // Running mean (Welford), more accurate than Sum / Count
+	M2    float64 // Sum of squared differences from the mean
+	// Digest estimates the percentiles, it is created by the first value.
+	Digest *TDigest
 }
 
 // NewAmountStats creates a new AmountStats with proper initial values.
@@ -20,14 +24,13 @@ func NewAmountStats() *AmountStats {
 	}
 }
 
-// Add updates the stats with a new logical row.
-func (s *AmountStats) Add(row *LogicalRow) {
-	v := row.Amount
-
+// Add updates the stats with a new value.
+func (s *AmountStats) Add(v float64) {
 	if s.Count == 0 {
 		// First value, we can also directly set min/max to v
 		s.Min = v
 		s.Max = v
+		s.Digest = NewTDigest(DefaultCompression)
 	} else {
 		if v < s.Min {
 			s.Min = v
@@ -39,6 +42,32 @@ func (s *AmountStats) Add(row *LogicalRow) {
 
 	s.Count++
 	s.Sum += v
+	delta := v - s.Mean
+	s.Mean += delta / float64(s.Count)
+	s.M2 += delta * (v - s.Mean)
+	s.Digest.Add(v)
+}
+
+// Merge adds the values counted by o, as if they had been added to s. The
+// variance is combined with Chan's parallel algorithm.
+func (s *AmountStats) Merge(o *AmountStats) {
+	if o.Count == 0 {
+		return
+	}
+	if s.Count == 0 {
+		s.Min, s.Max = o.Min, o.Max
+		s.Digest = NewTDigest(DefaultCompression)
+	}
+	s.Min = math.Min(s.Min, o.Min)
+	s.Max = math.Max(s.Max, o.Max)
+
+	count := s.Count + o.Count
+	delta := o.Mean - s.Mean
+	s.M2 += o.M2 + delta*delta*float64(s.Count)*float64(o.Count)/float64(count)
+	s.Mean += delta * float64(o.Count) / float64(count)
+	s.Count = count
+	s.Sum += o.Sum
+	s.Digest.Merge(o.Digest)
 }
 
 // HasData returns true if at least one value has been added.
@@ -46,10 +75,33 @@ func (s *AmountStats) HasData() bool {
 	return s.Count > 0
 }
 
-// Average returns the average amount or NaN if there is no data.
+// Average returns the running mean or NaN if there is no data.
 func (s *AmountStats) Average() float64 {
 	if s.Count == 0 {
 		return math.NaN()
 	}
-	return s.Sum / float64(s.Count)
+	return s.Mean
+}
+
+// Variance returns the sample variance, NaN with less than two values.
+func (s *AmountStats) Variance() float64 {
+	if s.Count < 2 {
+		return math.NaN()
+	}
+	return s.M2 / float64(s.Count-1)
+}
+
+// StdDev returns the sample standard deviation, NaN with less than two
+// values.
+func (s *AmountStats) StdDev() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+// Percentile returns an estimate of the p-th percentile (0 to 100), NaN if
+// there is no data.
+func (s *AmountStats) Percentile(p float64) float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	return s.Digest.Quantile(p / 100)
 }
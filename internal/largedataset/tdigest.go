@@ -0,0 +1,132 @@
+package largedataset
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultCompression is the t-digest compression used by AmountStats. The
+// digest keeps at most about compression centroids, whatever the number of
+// values. The rank error of a quantile is in the order of 1/compression near
+// the median, and much lower near the tails.
+const DefaultCompression = 100
+
+type centroid struct {
+	Mean   float64
+	Weight float64
+}
+
+// TDigest estimates quantiles of a stream of values (Dunning's merging
+// t-digest). Digests of different streams can be merged.
+type TDigest struct {
+	Compression float64
+	centroids   []centroid // Sorted by mean
+	buffer      []centroid // Values not merged into centroids yet
+	count       float64
+	min, max    float64
+}
+
+// NewTDigest returns an empty digest with the given compression.
+func NewTDigest(compression float64) *TDigest {
+	return &TDigest{Compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// Count returns the number of values added.
+func (d *TDigest) Count() float64 {
+	return d.count
+}
+
+// Add adds a value to the digest.
+func (d *TDigest) Add(v float64) {
+	d.add(centroid{Mean: v, Weight: 1}, v, v)
+}
+
+func (d *TDigest) add(c centroid, min, max float64) {
+	d.buffer = append(d.buffer, c)
+	d.count += c.Weight
+	d.min = math.Min(d.min, min)
+	d.max = math.Max(d.max, max)
+	if len(d.buffer) >= int(5*d.Compression) {
+		d.compress()
+	}
+}
+
+// Merge adds the values of o to the digest. o is left unchanged.
+func (d *TDigest) Merge(o *TDigest) {
+	if o.count == 0 {
+		return
+	}
+	for _, cs := range [][]centroid{o.centroids, o.buffer} {
+		for _, c := range cs {
+			d.add(c, o.min, o.max)
+		}
+	}
+}
+
+// compress merges the buffered values into the centroids. Centroids are
+// merged as long as they span less than 1 on the k1 scale, k(q) =
+// compression / 2π * asin(2q - 1), which keeps them small near the tails.
+func (d *TDigest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+	all := append(d.centroids, d.buffer...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Mean < all[j].Mean })
+
+	merged := all[:1]
+	seen := 0.0 // Weight before the last merged centroid
+	kLeft := d.scale(0)
+	for _, c := range all[1:] {
+		last := &merged[len(merged)-1]
+		weight := last.Weight + c.Weight
+		if d.scale((seen+weight)/d.count)-kLeft <= 1 {
+			last.Mean += (c.Mean - last.Mean) * c.Weight / weight
+			last.Weight = weight
+			continue
+		}
+		seen += last.Weight
+		kLeft = d.scale(seen / d.count)
+		merged = append(merged, c)
+	}
+	d.centroids = merged
+	d.buffer = nil
+}
+
+func (d *TDigest) scale(q float64) float64 {
+	return d.Compression / (2 * math.Pi) * math.Asin(2*min(q, 1)-1)
+}
+
+// Quantile returns an estimate of the value below which a fraction q of the
+// values fall, NaN if the digest is empty.
+func (d *TDigest) Quantile(q float64) float64 {
+	d.compress()
+	cs := d.centroids
+	if len(cs) == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return d.min
+	}
+	if q >= 1 {
+		return d.max
+	}
+
+	// Values are interpolated between the centers of the centroids, and
+	// between the extreme centroids and min / max.
+	index := q * d.count
+	if index < cs[0].Weight/2 {
+		return d.min + (cs[0].Mean-d.min)*index/(cs[0].Weight/2)
+	}
+	seen := 0.0
+	for i := 0; i < len(cs)-1; i++ {
+		left := seen + cs[i].Weight/2
+		right := seen + cs[i].Weight + cs[i+1].Weight/2
+		if index <= right {
+			return cs[i].Mean + (cs[i+1].Mean-cs[i].Mean)*(index-left)/(right-left)
+		}
+		seen += cs[i].Weight
+	}
+	last := cs[len(cs)-1]
+	left := d.count - last.Weight/2
+	return last.Mean + (d.max-last.Mean)*(index-left)/(last.Weight/2)
+}
@@ -0,0 +1,85 @@
+package largedataset
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"testing"
+)
+
+func TestTDigest_Quantile(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	values := make([]float64, 100000)
+	whole, parts := NewTDigest(DefaultCompression), make([]*TDigest, 4)
+	for i := range parts {
+		parts[i] = NewTDigest(DefaultCompression)
+	}
+	for i := range values {
+		values[i] = rng.ExpFloat64() * 100
+		whole.Add(values[i])
+		parts[i%len(parts)].Add(values[i])
+	}
+	merged := NewTDigest(DefaultCompression)
+	for _, p := range parts {
+		merged.Merge(p)
+	}
+	sort.Float64s(values)
+
+	for _, d := range []*TDigest{whole, merged} {
+		if d.Count() != float64(len(values)) {
+			t.Fatalf("Count() = %v, want %d", d.Count(), len(values))
+		}
+		if got := len(d.centroids); got > DefaultCompression {
+			t.Errorf("digest keeps %d centroids", got)
+		}
+		for _, q := range []float64{0.01, 0.5, 0.9, 0.99, 0.999} {
+			// The rank of the estimate is what the digest bounds
+			got := d.Quantile(q)
+			rank := float64(sort.SearchFloat64s(values, got)) / float64(len(values))
+			if math.Abs(rank-q) > 0.01 {
+				t.Errorf("Quantile(%v) = %v has rank %v", q, got, rank)
+			}
+		}
+		if d.Quantile(0) != values[0] || d.Quantile(1) != values[len(values)-1] {
+			t.Errorf("Quantile(0), Quantile(1) = %v, %v, want the min and max", d.Quantile(0), d.Quantile(1))
+		}
+	}
+}
+
+func TestTDigest_SmallCounts(t *testing.T) {
+	d := NewTDigest(DefaultCompression)
+	if !math.IsNaN(d.Quantile(0.5)) {
+		t.Errorf("empty digest Quantile(0.5) = %v, want NaN", d.Quantile(0.5))
+	}
+	d.Add(7)
+	if d.Quantile(0.5) != 7 || d.Quantile(0.99) != 7 {
+		t.Errorf("single value quantiles = %v, %v, want 7", d.Quantile(0.5), d.Quantile(0.99))
+	}
+	for _, v := range []float64{1, 3, 5, 9} {
+		d.Add(v)
+	}
+	if got := d.Quantile(0.5); got != 5 {
+		t.Errorf("Quantile(0.5) = %v, want 5", got)
+	}
+}
+
+func TestAmountStats_Variance(t *testing.T) {
+	s := NewAmountStats()
+	if !math.IsNaN(s.Variance()) || !math.IsNaN(s.Percentile(50)) {
+		t.Error("expected NaN variance and percentile without data")
+	}
+	s.Add(1e9 + 4)
+	if !math.IsNaN(s.StdDev()) {
+		t.Errorf("StdDev() of a single value = %v, want NaN", s.StdDev())
+	}
+	// Large offsets would lose the variance with a naive sum of squares
+	for _, v := range []float64{1e9 + 7, 1e9 + 13, 1e9 + 16} {
+		s.Add(v)
+	}
+	if s.Variance() != 30 {
+		t.Errorf("Variance() = %v, want 30", s.Variance())
+	}
+	if math.Abs(s.StdDev()-math.Sqrt(30)) > 1e-12 {
+		t.Errorf("StdDev() = %v, want %v", s.StdDev(), math.Sqrt(30))
+	}
+}
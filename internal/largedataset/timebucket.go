@@ -0,0 +1,355 @@
+package largedataset
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeBucketSpec is the command line description of a time bucketing, its
+// columns being names or 0-based indexes.
+type TimeBucketSpec struct {
+	Size   string // 1m, 1h, 1d or any Go duration
+	Time   string
+	Layout string // Layout of the time column, as for time.Parse
+	Price  string
+	Volume string // Empty to skip the volume
+	Symbol string // Empty to aggregate all the rows of a bucket together
+}
+
+// TimeBucket groups rows by timestamp truncated to Size in its own time zone,
+// and by Symbol.
+type TimeBucket struct {
+	Size   time.Duration
+	Time   Column
+	Layout string
+	Price  Column
+	Volume *Column // nil to skip the volume
+	Symbol *Column // nil to aggregate all the rows of a bucket together
+}
+
+// ParseBucketSize parses a Go duration, or a number of days such as "1d".
+func ParseBucketSize(s string) (time.Duration, error) {
+	var size time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid bucket size %q", s)
+		}
+		size = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if size, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid bucket size %q", s)
+		}
+	}
+	if size <= 0 {
+		return 0, fmt.Errorf("bucket size must be positive, got %q", s)
+	}
+	return size, nil
+}
+
+// ResolveTimeBucket checks spec and resolves its columns against header.
+func ResolveTimeBucket(spec TimeBucketSpec, header []string) (TimeBucket, error) {
+	size, err := ParseBucketSize(spec.Size)
+	if err != nil {
+		return TimeBucket{}, err
+	}
+	if spec.Layout == "" {
+		return TimeBucket{}, errors.New("missing time layout")
+	}
+	b := TimeBucket{Size: size, Layout: spec.Layout}
+
+	cols, err := ResolveColumns([]string{spec.Time, spec.Price}, header)
+	if err != nil {
+		return TimeBucket{}, err
+	}
+	b.Time, b.Price = cols[0], cols[1]
+	for _, opt := range []struct {
+		ref string
+		col **Column
+	}{{spec.Volume, &b.Volume}, {spec.Symbol, &b.Symbol}} {
+		if opt.ref == "" {
+			continue
+		}
+		index, name, err := ResolveColumn(opt.ref, header)
+		if err != nil {
+			return TimeBucket{}, err
+		}
+		*opt.col = &Column{Index: index, Name: name}
+	}
+	return b, nil
+}
+
+// OHLC sums up the trades of a bucket: open, high, low and close prices and
+// traded volume.
+type OHLC struct {
+	Start  time.Time
+	Symbol string
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+	Trades int64
+	// Time and row of the open and close trades, the row breaking ties
+	openAt, closeAt tradeTime
+}
+
+type tradeTime struct {
+	time time.Time
+	row  int
+}
+
+func (t tradeTime) before(o tradeTime) bool {
+	return t.time.Before(o.time) || t.time.Equal(o.time) && t.row < o.row
+}
+
+func (c *OHLC) add(price, volume float64, at tradeTime) {
+	if c.Trades == 0 || at.before(c.openAt) {
+		c.Open, c.openAt = price, at
+	}
+	if c.Trades == 0 || c.closeAt.before(at) {
+		c.Close, c.closeAt = price, at
+	}
+	if c.Trades == 0 || price > c.High {
+		c.High = price
+	}
+	if c.Trades == 0 || price < c.Low {
+		c.Low = price
+	}
+	c.Volume += volume
+	c.Trades++
+}
+
+func (c *OHLC) merge(o *OHLC) {
+	if o.openAt.before(c.openAt) {
+		c.Open, c.openAt = o.Open, o.openAt
+	}
+	if c.closeAt.before(o.closeAt) {
+		c.Close, c.closeAt = o.Close, o.closeAt
+	}
+	c.High = max(c.High, o.High)
+	c.Low = min(c.Low, o.Low)
+	c.Volume += o.Volume
+	c.Trades += o.Trades
+}
+
+type bucketKey struct {
+	start  int64 // Unix nanoseconds
+	symbol string
+}
+
+// TimeBucketAggregator computes the OHLC of each time bucket and symbol.
+//
+// With Sorted set, rows are expected in time order: the buckets are written
+// to Out as soon as a row of a later bucket comes, so that only the buckets
+// of the current period are kept in memory. Rows of buckets already written
+// are dropped and counted. Sorted aggregators can't be merged, pipelines
+// refuse to run them on several workers.
+type TimeBucketAggregator struct {
+	TimeBucket
+	Sorted bool
+	Out    Renderer // Where the buckets are written, required when sorted
+
+	buckets    map[bucketKey]*OHLC
+	current    time.Time // Start of the latest bucket, when sorted
+	skipped    int64     // Rows whose time or price could not be parsed
+	outOfOrder int64     // Rows of already written buckets dropped, when sorted
+	begun      bool      // Whether the table was begun on Out
+}
+
+func NewTimeBucketAggregator(bucket TimeBucket) *TimeBucketAggregator {
+	return &TimeBucketAggregator{TimeBucket: bucket, buckets: make(map[bucketKey]*OHLC)}
+}
+
+func (a *TimeBucketAggregator) Consume(row *LogicalRow) {
+	ts, price, volume, err := a.parse(row)
+	if err != nil {
+		a.skipped++
+		return
+	}
+	start := bucketStart(ts, a.Size)
+	if a.Sorted {
+		switch {
+		case start.After(a.current):
+			a.flush()
+			a.current = start
+		case start.Before(a.current):
+			a.outOfOrder++
+			return
+		}
+	}
+
+	key := bucketKey{start: start.UnixNano()}
+	if a.Symbol != nil {
+		key.symbol, _ = row.value(*a.Symbol)
+	}
+	c, ok := a.buckets[key]
+	if !ok {
+		c = &OHLC{Start: start, Symbol: key.symbol}
+		a.buckets[key] = c
+	}
+	c.add(price, volume, tradeTime{time: ts, row: row.Row})
+}
+
+// bucketStart returns the start of the bucket of size holding ts. Buckets are
+// aligned on the wall clock of the time zone of ts rather than on UTC, so that
+// daily buckets start at local midnight.
+func bucketStart(ts time.Time, size time.Duration) time.Time {
+	if size%(24*time.Hour) == 0 {
+		// Count days on the calendar, their length changes with daylight saving
+		y, m, d := ts.Date()
+		day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Truncate(size)
+		y, m, d = day.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, ts.Location())
+	}
+	_, offset := ts.Zone()
+	shift := time.Duration(offset) * time.Second
+	return ts.Add(shift).Truncate(size).Add(-shift)
+}
+
+// parse returns the time, price and volume of row.
+func (a *TimeBucketAggregator) parse(row *LogicalRow) (time.Time, float64, float64, error) {
+	raw, ok := row.value(a.Time)
+	if !ok {
+		return time.Time{}, 0, 0, errors.New("missing time")
+	}
+	ts, err := time.Parse(a.Layout, raw)
+	if err != nil {
+		return time.Time{}, 0, 0, err
+	}
+	price, err := row.number(a.Price)
+	if err != nil {
+		return time.Time{}, 0, 0, err
+	}
+	volume := 0.0
+	if a.Volume != nil {
+		if volume, err = row.number(*a.Volume); err != nil {
+			return time.Time{}, 0, 0, err
+		}
+	}
+	return ts, price, volume, nil
+}
+
+func (a *TimeBucketAggregator) Merge(other Aggregator) {
+	if a.Sorted {
+		// Pipeline checks mergeable before running several workers
+		panic("largedataset: sorted time buckets can't be merged")
+	}
+	o := other.(*TimeBucketAggregator)
+	for key, c := range o.buckets {
+		if mine, ok := a.buckets[key]; ok {
+			mine.merge(c)
+		} else {
+			a.buckets[key] = c
+		}
+	}
+	a.skipped += o.skipped
+}
+
+func (a *TimeBucketAggregator) mergeable() bool {
+	return !a.Sorted
+}
+
+// Buckets returns the buckets not written yet, by start and symbol.
+func (a *TimeBucketAggregator) Buckets() []*OHLC {
+	buckets := make([]*OHLC, 0, len(a.buckets))
+	for _, c := range a.buckets {
+		buckets = append(buckets, c)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if !buckets[i].Start.Equal(buckets[j].Start) {
+			return buckets[i].Start.Before(buckets[j].Start)
+		}
+		return buckets[i].Symbol < buckets[j].Symbol
+	})
+	return buckets
+}
+
+// title returns the title of the OHLC table.
+func (a *TimeBucketAggregator) title() string {
+	return fmt.Sprintf("OHLC of %s per %s", a.Price.Name, a.Size)
+}
+
+// columns returns the columns of the OHLC table.
+func (a *TimeBucketAggregator) columns() []string {
+	columns := []string{"Bucket"}
+	if a.Symbol != nil {
+		columns = append(columns, a.Symbol.Name)
+	}
+	columns = append(columns, "Open", "High", "Low", "Close")
+	if a.Volume != nil {
+		columns = append(columns, a.Volume.Name)
+	}
+	return append(columns, "Trades")
+}
+
+// row returns the cells of c.
+func (a *TimeBucketAggregator) row(c *OHLC) []any {
+	row := []any{c.Start.Format(a.Layout)}
+	if a.Symbol != nil {
+		row = append(row, c.Symbol)
+	}
+	row = append(row, c.Open, c.High, c.Low, c.Close)
+	if a.Volume != nil {
+		if c.Volume == math.Trunc(c.Volume) && math.Abs(c.Volume) < 1<<53 {
+			row = append(row, int64(c.Volume)) // Quantities are usually whole
+		} else {
+			row = append(row, c.Volume)
+		}
+	}
+	return append(row, c.Trades)
+}
+
+// notes returns the remarks about the rows left out.
+func (a *TimeBucketAggregator) notes() []string {
+	var notes []string
+	if a.skipped > 0 {
+		notes = append(notes, fmt.Sprintf("%d rows skipped: invalid time, price or volume", a.skipped))
+	}
+	if a.outOfOrder > 0 {
+		notes = append(notes, fmt.Sprintf("Warning: %d rows were not sorted by %s, dropped as their buckets were already written", a.outOfOrder, a.Time.Name))
+	}
+	return notes
+}
+
+// flush writes the buckets kept to Out and forgets them.
+func (a *TimeBucketAggregator) flush() {
+	if len(a.buckets) == 0 {
+		return
+	}
+	if !a.begun {
+		a.Out.BeginTable(a.title(), a.columns())
+		a.begun = true
+	}
+	for _, c := range a.Buckets() {
+		a.Out.Row(a.row(c))
+	}
+	clear(a.buckets)
+	// Errors are reported when the renderer is closed
+	a.Out.Flush()
+}
+
+// Tables returns the OHLC table. Sorted aggregators end the table they
+// wrote to Out instead.
+func (a *TimeBucketAggregator) Tables() []*Table {
+	if a.Sorted {
+		a.flush()
+		if !a.begun {
+			a.Out.BeginTable(a.title(), a.columns())
+		}
+		a.Out.EndTable(a.notes())
+		return nil
+	}
+
+	t := &Table{Title: a.title(), Columns: a.columns(), Notes: a.notes()}
+	for _, c := range a.Buckets() {
+		t.Rows = append(t.Rows, a.row(c))
+	}
+	return []*Table{t}
+}
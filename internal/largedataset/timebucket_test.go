@@ -0,0 +1,196 @@
+package largedataset
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBucketSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"1m", time.Minute, false},
+		{"1h", time.Hour, false},
+		{"1d", 24 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"15m30s", 15*time.Minute + 30*time.Second, false},
+		{"0s", 0, true},
+		{"-1h", 0, true},
+		{"xd", 0, true},
+		{"hour", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBucketSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBucketSize(%q) = %v, %v, want %v (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveTimeBucket(t *testing.T) {
+	spec := TimeBucketSpec{Size: "1h", Time: "Timestamp", Layout: time.DateTime, Price: "Price", Volume: "Quantity", Symbol: "Symbol"}
+	b, err := ResolveTimeBucket(spec, stockHeader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Time.Index != 1 || b.Price.Index != 8 || b.Volume.Index != 7 || b.Symbol.Index != 2 {
+		t.Errorf("unexpected columns: %+v", b)
+	}
+
+	spec.Volume, spec.Symbol = "", ""
+	if b, err = ResolveTimeBucket(spec, stockHeader); err != nil || b.Volume != nil || b.Symbol != nil {
+		t.Errorf("ResolveTimeBucket() without volume and symbol = %+v, %v", b, err)
+	}
+	spec.Price = "Cost"
+	if _, err := ResolveTimeBucket(spec, stockHeader); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
+
+// testBucket buckets Time, Symbol, Price, Quantity records per hour.
+var testBucket = TimeBucket{
+	Size:   time.Hour,
+	Time:   Column{Index: 0, Name: "Time"},
+	Layout: time.DateTime,
+	Price:  Column{Index: 2, Name: "Price"},
+	Volume: &Column{Index: 3, Name: "Quantity"},
+	Symbol: &Column{Index: 1, Name: "Symbol"},
+}
+
+var testTrades = [][]string{
+	{"2020-01-01 09:30:00", "AAPL", "100", "10"},
+	{"2020-01-01 09:45:00", "AAPL", "104", "5"},
+	{"2020-01-01 09:10:00", "MSFT", "50", "1"},
+	{"2020-01-01 09:59:59", "AAPL", "98", "2"},
+	{"2020-01-01 10:00:00", "AAPL", "99", "4"},
+	{"not a time", "AAPL", "1", "1"},
+	{"2020-01-01 10:20:00", "AAPL", "101", "3"},
+}
+
+func consumeTrades(a Aggregator, trades [][]string, firstRow int) {
+	for i, record := range trades {
+		a.Consume(&LogicalRow{Row: firstRow + i, RawRecord: record})
+	}
+}
+
+func TestTimeBucketAggregator(t *testing.T) {
+	whole := NewTimeBucketAggregator(testBucket)
+	consumeTrades(whole, testTrades, 1)
+	// Later rows first, the open and close must not depend on the merge order
+	first, second := NewTimeBucketAggregator(testBucket), NewTimeBucketAggregator(testBucket)
+	consumeTrades(first, testTrades[:3], 1)
+	consumeTrades(second, testTrades[3:], 4)
+	second.Merge(first)
+
+	for name, a := range map[string]*TimeBucketAggregator{"whole": whole, "merged": second} {
+		buckets := a.Buckets()
+		if len(buckets) != 3 {
+			t.Fatalf("%s: expected 3 buckets, got %d", name, len(buckets))
+		}
+		aapl := *buckets[0]
+		want := OHLC{Symbol: "AAPL", Open: 100, High: 104, Low: 98, Close: 98, Volume: 17, Trades: 3}
+		if aapl.Symbol != want.Symbol || aapl.Open != want.Open || aapl.High != want.High || aapl.Low != want.Low ||
+			aapl.Close != want.Close || aapl.Volume != want.Volume || aapl.Trades != want.Trades {
+			t.Errorf("%s: AAPL 09:00 bucket = %+v, want %+v", name, aapl, want)
+		}
+		if buckets[1].Symbol != "MSFT" || buckets[2].Start.Hour() != 10 || buckets[2].Close != 101 {
+			t.Errorf("%s: unexpected buckets %+v, %+v", name, *buckets[1], *buckets[2])
+		}
+	}
+
+	out := renderText(whole.Tables())
+	for _, want := range []string{"OHLC of Price per 1h0m0s", "Bucket               Symbol  Open    High    Low    Close   Quantity  Trades",
+		"2020-01-01 09:00:00  AAPL    100.00  104.00  98.00  98.00   17        3", "1 rows skipped"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestTimeBucketAggregator_Sorted(t *testing.T) {
+	var out bytes.Buffer
+	a := NewTimeBucketAggregator(testBucket)
+	a.Sorted, a.Out = true, newTextRenderer(&out)
+
+	consumeTrades(a, testTrades[:4], 1)
+	if out.Len() != 0 {
+		t.Fatalf("buckets written before they are complete:\n%s", out.String())
+	}
+	consumeTrades(a, testTrades[4:5], 5)
+	if !strings.Contains(out.String(), "09:00:00  MSFT") || len(a.Buckets()) != 1 {
+		t.Errorf("expected the 09:00 buckets written and forgotten, got %d buckets and:\n%s", len(a.Buckets()), out.String())
+	}
+
+	consumeTrades(a, [][]string{{"2020-01-01 09:50:00", "AAPL", "1", "1"}}, 6)
+	if tables := a.Tables(); tables != nil {
+		t.Errorf("expected the table to be written to Out, got %v", tables)
+	}
+	a.Out.Close()
+	if strings.Count(out.String(), "=== OHLC") != 1 {
+		t.Errorf("expected a single report header:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Warning: 1 rows were not sorted by Time, dropped") {
+		t.Errorf("expected a warning for the out of order row:\n%s", out.String())
+	}
+	if strings.Count(out.String(), "09:00:00  AAPL") != 1 {
+		t.Errorf("expected the late row not to write its bucket again:\n%s", out.String())
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	paris := time.FixedZone("CET", 3600)
+	india := time.FixedZone("IST", 5*3600+1800)
+	type test struct {
+		ts   time.Time
+		size time.Duration
+		want time.Time
+	}
+	tests := []test{
+		{time.Date(2020, 1, 1, 9, 40, 0, 0, time.UTC), time.Hour, time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)},
+		// Buckets start on the local wall clock, not on UTC
+		{time.Date(2020, 1, 1, 0, 30, 0, 0, paris), 24 * time.Hour, time.Date(2020, 1, 1, 0, 0, 0, 0, paris)},
+		{time.Date(2020, 1, 1, 23, 30, 0, 0, paris), 24 * time.Hour, time.Date(2020, 1, 1, 0, 0, 0, 0, paris)},
+		{time.Date(2020, 1, 1, 9, 40, 0, 0, india), time.Hour, time.Date(2020, 1, 1, 9, 0, 0, 0, india)},
+		{time.Date(2020, 1, 1, 9, 40, 0, 0, india), 15 * time.Minute, time.Date(2020, 1, 1, 9, 30, 0, 0, india)},
+	}
+	if newYork, err := time.LoadLocation("America/New_York"); err == nil {
+		// A 23 hour day still starts at midnight
+		tests = append(tests, test{time.Date(2020, 3, 8, 22, 0, 0, 0, newYork), 24 * time.Hour, time.Date(2020, 3, 8, 0, 0, 0, 0, newYork)})
+	}
+	for _, tt := range tests {
+		if got := bucketStart(tt.ts, tt.size); !got.Equal(tt.want) {
+			t.Errorf("bucketStart(%v, %v) = %v, want %v", tt.ts, tt.size, got, tt.want)
+		}
+	}
+
+	// Rows carrying an offset are bucketed by their local day
+	bucket := testBucket
+	bucket.Size, bucket.Layout, bucket.Symbol = 24*time.Hour, "2006-01-02 15:04:05 -0700", nil
+	a := NewTimeBucketAggregator(bucket)
+	consumeTrades(a, [][]string{
+		{"2020-01-01 00:30:00 +0100", "", "100", "1"},
+		{"2020-01-01 23:30:00 +0100", "", "101", "1"},
+		{"2020-01-02 00:10:00 +0100", "", "102", "1"},
+	}, 1)
+	buckets := a.Buckets()
+	if len(buckets) != 2 || buckets[0].Trades != 2 || buckets[0].Start.Format(time.DateTime) != "2020-01-01 00:00:00" {
+		t.Errorf("expected 2 trades on January 1st then 1, got %d buckets, first %+v", len(buckets), buckets[0])
+	}
+}
+
+func TestPipeline_SortedTimeBucketsNeedOneWorker(t *testing.T) {
+	var out bytes.Buffer
+	p := &Pipeline{Sep: ',', Workers: 2, NewAggregator: func() Aggregator {
+		a := NewTimeBucketAggregator(testBucket)
+		a.Sorted, a.Out = true, newTextRenderer(&out)
+		return NewCompositeAggregator(a)
+	}}
+	if _, _, err := p.Run(strings.NewReader("2020-01-01 09:00:00,AAPL,1,1\n")); !errors.Is(err, ErrNotMergeable) {
+		t.Errorf("error = %v, want ErrNotMergeable", err)
+	}
+}
@@ -0,0 +1,226 @@
+package largedataset
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultTopK is the number of values shown by a top-K when not given.
+const DefaultTopK = 10
+
+// TopK asks for the K most frequent values of a column.
+type TopK struct {
+	Column Column
+	K      int
+}
+
+// ParseTopK parses "column" or "column:k", e.g. "Symbol:5".
+func ParseTopK(spec string, header []string) (TopK, error) {
+	ref, count, hasCount := strings.Cut(spec, ":")
+	top := TopK{K: DefaultTopK}
+	if hasCount {
+		var err error
+		if top.K, err = strconv.Atoi(strings.TrimSpace(count)); err != nil || top.K <= 0 {
+			return TopK{}, fmt.Errorf("invalid top-K count %q", count)
+		}
+	}
+	index, name, err := ResolveColumn(ref, header)
+	if err != nil {
+		return TopK{}, err
+	}
+	top.Column = Column{Index: index, Name: name}
+	return top, nil
+}
+
+// ssCounter is a monitored value of a SpaceSaving summary.
+type ssCounter struct {
+	value string
+	count int64 // Overestimate of the value occurrences
+	err   int64 // Maximum overestimation of count
+	index int   // Position in the heap
+}
+
+// ssHeap is a min-heap of counters on their count.
+type ssHeap []*ssCounter
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *ssHeap) Push(x any) {
+	c := x.(*ssCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+func (h *ssHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// SpaceSaving finds the most frequent values of a stream (Metwally et al.)
+// with a fixed number of counters. When a new value comes and every counter
+// is taken, it replaces the least counted value and inherits its count as
+// error. Any value occurring more than n / capacity times, n being the
+// stream length, is guaranteed to be monitored, and counts overestimate the
+// real ones by at most n / capacity.
+type SpaceSaving struct {
+	capacity int
+	total    int64
+	counters map[string]*ssCounter
+	heap     ssHeap
+}
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	return &SpaceSaving{capacity: capacity, counters: make(map[string]*ssCounter, capacity)}
+}
+
+// Total returns the number of values added.
+func (s *SpaceSaving) Total() int64 {
+	return s.total
+}
+
+// MaxError returns the bound of the overestimation of any count.
+func (s *SpaceSaving) MaxError() int64 {
+	if len(s.heap) < s.capacity {
+		return 0 // Every value is counted exactly
+	}
+	return s.heap[0].count
+}
+
+func (s *SpaceSaving) Add(value string) {
+	s.total++
+	if c, ok := s.counters[value]; ok {
+		c.count++
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+	if len(s.heap) < s.capacity {
+		c := &ssCounter{value: value, count: 1}
+		s.counters[value] = c
+		heap.Push(&s.heap, c)
+		return
+	}
+	c := s.heap[0]
+	delete(s.counters, c.value)
+	c.value, c.err = value, c.count
+	c.count++
+	s.counters[value] = c
+	heap.Fix(&s.heap, 0)
+}
+
+// Merge adds the values of o to the summary (Agarwal et al., mergeable
+// summaries): a value missing from a full summary is counted as its least
+// count, then the largest counters are kept.
+func (s *SpaceSaving) Merge(o *SpaceSaving) {
+	minS, minO := s.MaxError(), o.MaxError()
+	merged := make(map[string]*ssCounter, len(s.counters)+len(o.counters))
+	for v, c := range s.counters {
+		merged[v] = &ssCounter{value: v, count: c.count + minO, err: c.err + minO}
+	}
+	for v, c := range o.counters {
+		if m, ok := merged[v]; ok {
+			m.count += c.count - minO
+			m.err += c.err - minO
+		} else {
+			merged[v] = &ssCounter{value: v, count: c.count + minS, err: c.err + minS}
+		}
+	}
+
+	all := make([]*ssCounter, 0, len(merged))
+	for _, c := range merged {
+		all = append(all, c)
+	}
+	sortCounters(all)
+	all = all[:min(len(all), s.capacity)]
+
+	s.total += o.total
+	s.counters = make(map[string]*ssCounter, s.capacity)
+	s.heap = s.heap[:0]
+	for _, c := range all {
+		s.counters[c.value] = c
+		heap.Push(&s.heap, c)
+	}
+}
+
+// Top returns the k most frequent values, by decreasing count.
+func (s *SpaceSaving) Top(k int) []TopValue {
+	all := make([]*ssCounter, 0, len(s.heap))
+	all = append(all, s.heap...)
+	sortCounters(all)
+	top := make([]TopValue, 0, min(k, len(all)))
+	for _, c := range all[:min(k, len(all))] {
+		top = append(top, TopValue{Value: c.value, Count: c.count, Error: c.err})
+	}
+	return top
+}
+
+// sortCounters sorts by decreasing count, then by value for stable reports.
+func sortCounters(cs []*ssCounter) {
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].count != cs[j].count {
+			return cs[i].count > cs[j].count
+		}
+		return cs[i].value < cs[j].value
+	})
+}
+
+// TopValue is a frequent value. Its real count is between Count - Error and
+// Count.
+type TopValue struct {
+	Value string
+	Count int64
+	Error int64
+}
+
+// TopKAggregator reports the K most frequent values of a column.
+type TopKAggregator struct {
+	TopK
+	summary *SpaceSaving
+}
+
+// NewTopKAggregator tracks the top values with 10 * K counters, at least
+// 100, so that their counts are accurate enough.
+func NewTopKAggregator(top TopK) *TopKAggregator {
+	return &TopKAggregator{TopK: top, summary: NewSpaceSaving(max(100, 10*top.K))}
+}
+
+func (t *TopKAggregator) Consume(row *LogicalRow) {
+	if v, ok := row.value(t.Column); ok {
+		t.summary.Add(v)
+	}
+}
+
+func (t *TopKAggregator) Merge(other Aggregator) {
+	t.summary.Merge(other.(*TopKAggregator).summary)
+}
+
+// Top returns the most frequent values.
+func (t *TopKAggregator) Top() []TopValue {
+	return t.summary.Top(t.K)
+}
+
+// Tables returns the top values with their counts, and the smallest their
+// real counts can be.
+func (t *TopKAggregator) Tables() []*Table {
+	table := &Table{
+		Title:   fmt.Sprintf("Top %d values of %s", t.K, t.Column.Name),
+		Columns: []string{"Rank", t.Column.Name, "Count", "MinCount"},
+	}
+	for i, v := range t.Top() {
+		table.Rows = append(table.Rows, []any{int64(i + 1), v.Value, v.Count, v.Count - v.Error})
+	}
+	if maxErr := t.summary.MaxError(); maxErr > 0 {
+		table.Notes = append(table.Notes, fmt.Sprintf("Space-Saving with %d counters over %d values: counts may exceed the real ones by up to %d",
+			t.summary.capacity, t.summary.Total(), maxErr))
+	} else {
+		table.Notes = append(table.Notes, "Exact counts (fewer distinct values than counters)")
+	}
+	return []*Table{table}
+}
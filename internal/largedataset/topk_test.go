@@ -0,0 +1,111 @@
+package largedataset
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTopK(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    TopK
+		wantErr bool
+	}{
+		{"Symbol", TopK{Column: Column{Index: 2, Name: "Symbol"}, K: DefaultTopK}, false},
+		{"exchange:3", TopK{Column: Column{Index: 3, Name: "Exchange"}, K: 3}, false},
+		{"30:5", TopK{Column: Column{Index: 30, Name: "col_30"}, K: 5}, false},
+		{"Symbol:0", TopK{}, true},
+		{"Symbol:many", TopK{}, true},
+		{"Ticker", TopK{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTopK(tt.spec, stockHeader)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTopK(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTopK(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+// zipfStream returns a stream where value i occurs about n / i times, in
+// an interleaved order.
+func zipfStream(values, n int) []string {
+	var stream []string
+	for round := 0; len(stream) < n; round++ {
+		for i := 1; i <= values; i++ {
+			if round%i == 0 {
+				stream = append(stream, fmt.Sprintf("v%d", i))
+			}
+		}
+	}
+	return stream
+}
+
+func TestSpaceSaving(t *testing.T) {
+	stream := zipfStream(2000, 100000)
+	exact := map[string]int64{}
+	for _, v := range stream {
+		exact[v]++
+	}
+
+	whole, parts := NewSpaceSaving(50), []*SpaceSaving{NewSpaceSaving(50), NewSpaceSaving(50), NewSpaceSaving(50)}
+	for i, v := range stream {
+		whole.Add(v)
+		parts[i%len(parts)].Add(v)
+	}
+	merged := NewSpaceSaving(50)
+	for _, p := range parts {
+		merged.Merge(p)
+	}
+
+	for name, s := range map[string]*SpaceSaving{"whole": whole, "merged": merged} {
+		if s.Total() != int64(len(stream)) {
+			t.Errorf("%s: Total() = %d, want %d", name, s.Total(), len(stream))
+		}
+		bound := s.Total() / 50
+		if s.MaxError() > bound {
+			t.Errorf("%s: MaxError() = %d, above n / capacity = %d", name, s.MaxError(), bound)
+		}
+		top := s.Top(5)
+		got := make([]string, len(top))
+		for i, v := range top {
+			got[i] = v.Value
+			real := exact[v.Value]
+			if v.Count < real || v.Count-v.Error > real {
+				t.Errorf("%s: %s counted %d (error %d), real count %d", name, v.Value, v.Count, v.Error, real)
+			}
+		}
+		if want := []string{"v1", "v2", "v3", "v4", "v5"}; !slices.Equal(got, want) {
+			t.Errorf("%s: Top(5) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestTopKAggregator(t *testing.T) {
+	top := NewTopKAggregator(TopK{Column: Column{Index: 0, Name: "Symbol"}, K: 2})
+	other := NewTopKAggregator(top.TopK)
+	for i, symbol := range []string{"KO", "AAPL", "KO", "MSFT", "AAPL", "KO"} {
+		agg := top
+		if i >= 3 {
+			agg = other
+		}
+		agg.Consume(&LogicalRow{RawRecord: []string{symbol}})
+	}
+	top.Merge(other)
+
+	want := []TopValue{{Value: "KO", Count: 3}, {Value: "AAPL", Count: 2}}
+	if got := top.Top(); !slices.Equal(got, want) {
+		t.Errorf("Top() = %+v, want %+v", got, want)
+	}
+	out := renderText(top.Tables())
+	for _, want := range []string{"Top 2 values of Symbol", "Exact counts", "Rank  Symbol  Count  MinCount", "1     KO      3      3"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report does not contain %q:\n%s", want, out)
+		}
+	}
+}